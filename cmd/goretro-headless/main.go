@@ -0,0 +1,49 @@
+// Command goretro-headless runs a rom without a webview, writing screenshots, audio and a cpu
+// trace, and printing the frames run and a hash of the last frame.  It exits with status 3 if
+// the -until conditions are not met in time, so that it can check test roms in CI.
+//
+//	goretro-headless -frames 600 -until '$6001==$DE,$6002==$B0,$6000!=$80' -screenshot out.png rom.nes
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/justinawrey/goretro/internal/headless"
+)
+
+func main() {
+	var opts headless.Options
+	flag.IntVar(&opts.Frames, "frames", 60, "frames to run, or the most to run with -until")
+	flag.StringVar(&opts.Until, "until", "", "stop once comma separated conditions such as '$6000!=$80' are met after a frame")
+	flag.StringVar(&opts.Script, "input", "", "input script of lines of a frame number followed by the buttons held from then on")
+	flag.StringVar(&opts.Region, "region", "", "region to run as: NTSC, PAL or Dendy (default: that of the rom)")
+	flag.StringVar(&opts.Screenshot, "screenshot", "", "write a PNG of the last frame to this path")
+	flag.IntVar(&opts.ScreenshotEvery, "screenshot-every", 0, "also write a PNG every this many frames, numbered by frame")
+	flag.StringVar(&opts.WAV, "wav", "", "write the audio output to this path as a WAV")
+	flag.StringVar(&opts.Trace, "trace", "", "write a log of every cpu instruction to this path")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %v [flags] rom\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.ROM = flag.Arg(0)
+
+	result, err := headless.Run(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("frames: %v\nhash: %v\n", result.Frames, result.Hash)
+	if opts.Until != "" && !result.Met {
+		fmt.Fprintf(os.Stderr, "conditions not met after %v frames\n", result.Frames)
+		os.Exit(3)
+	}
+}
@@ -0,0 +1,37 @@
+import { EventsOn } from '../wailsjs/runtime/runtime'
+import { SetBuffered } from '../wailsjs/go/app/WebviewAudioDriver'
+import { app } from '../wailsjs/go/models'
+
+const audioCtx = new AudioContext()
+
+// Time at which the last queued samples end, in audioCtx time
+let queuedUntil = 0
+
+EventsOn(app.AudioEvent.PLAY, play)
+
+// Browsers only allow audio to start after the user interacts with the page
+window.addEventListener('keydown', () => {
+    if (audioCtx.state === 'suspended') {
+        audioCtx.resume()
+    }
+})
+
+function play(samples: app.Samples): void {
+    const pcm = atob(samples.samples)
+    const buffer = audioCtx.createBuffer(1, pcm.length / 2, samples.rate)
+    const channel = buffer.getChannelData(0)
+    for (let i = 0; i < channel.length; i++) {
+        const sample = pcm.charCodeAt(i * 2) | (pcm.charCodeAt(i * 2 + 1) << 8)
+        channel[i] = (sample >= 0x8000 ? sample - 0x10000 : sample) / 0x8000
+    }
+
+    // Queue the samples after those already queued, or now if the queue has run dry
+    const source = audioCtx.createBufferSource()
+    source.buffer = buffer
+    source.connect(audioCtx.destination)
+    queuedUntil = Math.max(queuedUntil, audioCtx.currentTime)
+    source.start(queuedUntil)
+    queuedUntil += buffer.duration
+
+    SetBuffered(queuedUntil - audioCtx.currentTime)
+}
@@ -1,3 +1,5 @@
+import { Latency } from '../wailsjs/go/app/WebviewDisplayDriver'
+
 // import { RequestFrame } from '../wailsjs/go/main/App'
 // import trace from './trace'
 // import draw from './display'
@@ -8,4 +10,9 @@
 //     trace('roundtrip js -> go -> js asking for data', () => {
 //         RequestFrame().then((data) => draw(data))
 //     })
+
+// Logs the latency of frames, from being sent by go to being drawn.
+// eslint-disable-next-line @typescript-eslint/no-explicit-any
+;(window as any).latency = () => Latency().then((stats) => console.table(stats))
+
 export {}
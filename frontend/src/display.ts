@@ -1,8 +1,9 @@
 import { EventsOn } from '../wailsjs/runtime/runtime'
+import { FrameDrawn } from '../wailsjs/go/app/WebviewDisplayDriver'
 import { app } from '../wailsjs/go/models'
 
 const NUM_TEXELS_WIDTH = 256
-const NUM_TEXELS_HEIGHT = 256
+const NUM_TEXELS_HEIGHT = 240
 
 const canvas = document.getElementById('render-target') as HTMLCanvasElement
 const canvasCtx = canvas.getContext('2d', { alpha: false })
@@ -30,13 +31,12 @@ if (canvasCtx == null) {
 
 EventsOn(app.RenderEvent.RENDER, draw)
 
-function draw(data: number[]): void {
-    for (let i = 0; i < imageData.data.length; i += 4) {
-        imageData.data[i] = data[i]
-        imageData.data[i + 1] = data[i + 1]
-        imageData.data[i + 2] = data[i + 2]
-        imageData.data[i + 3] = data[i + 3]
+function draw(frame: app.Frame): void {
+    const pixels = atob(frame.pixels)
+    for (let i = 0; i < imageData.data.length; i++) {
+        imageData.data[i] = pixels.charCodeAt(i)
     }
 
     ctx.putImageData(imageData, 0, 0)
+    FrameDrawn(frame.sequence)
 }
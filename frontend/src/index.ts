@@ -2,6 +2,7 @@
 import './tailwind.css'
 import './index.css'
 
+import './audio'
 import './display'
 import './input'
 
@@ -1,5 +1,10 @@
 import { SetButton } from '../wailsjs/go/app/WebviewInputDriver'
+import { LoadFromSlot, Rewind, SaveToSlot } from '../wailsjs/go/core/nes'
 import { app } from '../wailsjs/go/models'
+import toast from './toast'
+
+const SLOT_COUNT = 10
+const REWIND_KEY = 'Backspace'
 
 // TODO: customizable?
 const keymap: Record<app.Button, string> = {
@@ -23,12 +28,57 @@ function handleKeypress(joypad: app.Joypad, key: string, to: boolean): void {
     }
 }
 
+// Handles the quick save slot hotkeys: F1-F10 load a slot, and Shift+F1-F10 save to it.
+// Returns whether or not the key was a hotkey.
+function handleSlotKey(e: KeyboardEvent): boolean {
+    const match = /^F(\d+)$/.exec(e.key)
+    const slot = match == null ? 0 : Number(match[1])
+    if (slot < 1 || slot > SLOT_COUNT) {
+        return false
+    }
+
+    e.preventDefault()
+    if (e.repeat) {
+        return true
+    }
+
+    if (e.shiftKey) {
+        SaveToSlot(slot)
+            .then(() => toast(`Saved to slot ${slot}`))
+            .catch((err) => toast(`Failed to save to slot ${slot}: ${err}`))
+    } else {
+        LoadFromSlot(slot)
+            .then(() => toast(`Loaded slot ${slot}`))
+            .catch((err) => toast(`Failed to load slot ${slot}: ${err}`))
+    }
+    return true
+}
+
+// Rewinds while the rewind key is held.  Returns whether or not the key was the rewind key.
+function handleRewindKey(e: KeyboardEvent, hold: boolean): boolean {
+    if (e.key !== REWIND_KEY) {
+        return false
+    }
+
+    e.preventDefault()
+    if (!e.repeat) {
+        Rewind(hold)
+    }
+    return true
+}
+
 // TODO: secondary joypad keybindings?
 // TODO: unsubscribe?
-window.addEventListener('keydown', (e) =>
-    handleKeypress(app.Joypad.PRIMARY, e.key, true),
-)
+window.addEventListener('keydown', (e) => {
+    if (handleSlotKey(e) || handleRewindKey(e, true)) {
+        return
+    }
+    handleKeypress(app.Joypad.PRIMARY, e.key, true)
+})
 
-window.addEventListener('keyup', (e) =>
-    handleKeypress(app.Joypad.PRIMARY, e.key, false),
-)
+window.addEventListener('keyup', (e) => {
+    if (handleRewindKey(e, false)) {
+        return
+    }
+    handleKeypress(app.Joypad.PRIMARY, e.key, false)
+})
@@ -0,0 +1,20 @@
+const TOAST_MS = 2000
+
+const container = document.createElement('div')
+container.className =
+    'pointer-events-none fixed bottom-4 left-0 right-0 flex flex-col items-center gap-2'
+document.body.appendChild(container)
+
+// Shows message briefly over the bottom of the screen.
+export default function toast(message: string): void {
+    const el = document.createElement('div')
+    el.className =
+        'rounded bg-black/75 px-3 py-1 text-sm text-white transition-opacity duration-300'
+    el.textContent = message
+    container.appendChild(el)
+
+    setTimeout(() => {
+        el.classList.add('opacity-0')
+        el.addEventListener('transitionend', () => el.remove())
+    }, TOAST_MS)
+}
@@ -1,7 +1,88 @@
 package app
 
-type WebviewAudioDriver struct{}
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+type AudioEvent string
+
+const Play AudioEvent = "PLAY"
+
+var AudioEvents = []struct {
+	Value  AudioEvent
+	TSName string
+}{
+	{Play, "PLAY"},
+}
+
+// Samples are mono audio samples, sent to the webview with the PLAY event.
+type Samples struct {
+	Rate    int    `json:"rate"`    // samples per second
+	Samples string `json:"samples"` // base64 encoded signed 16 bit little endian samples
+}
+
+type WebviewAudioDriver struct {
+	Ctx context.Context
+
+	mu       sync.Mutex
+	buffered float64   // seconds of audio queued by the webview, as last reported
+	sent     float64   // seconds of audio sent since the last report
+	since    time.Time // time of the last report, or of the first samples sent if none
+}
 
 func NewWebviewAudioDriver() *WebviewAudioDriver {
 	return &WebviewAudioDriver{}
 }
+
+// PlaySamples queues samples, in the range -1 to 1, for playback by the webview at rate
+// samples per second.
+func (a *WebviewAudioDriver) PlaySamples(samples []float32, rate int) {
+	if len(samples) == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	if a.since.IsZero() {
+		a.since = time.Now()
+	}
+	a.sent += float64(len(samples)) / float64(rate)
+	a.mu.Unlock()
+	if a.Ctx == nil {
+		return
+	}
+
+	pcm := make([]byte, len(samples)*2)
+	for i, sample := range samples {
+		level := math.Round(float64(sample) * math.MaxInt16)
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(max(min(level, math.MaxInt16), math.MinInt16))))
+	}
+	runtime.EventsEmit(a.Ctx, string(Play), Samples{Rate: rate, Samples: base64.StdEncoding.EncodeToString(pcm)})
+}
+
+// SetBuffered is called by the webview to report the seconds of audio it has queued.
+func (a *WebviewAudioDriver) SetBuffered(seconds float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.buffered = seconds
+	a.sent = 0
+	a.since = time.Now()
+}
+
+// Buffered returns an estimate of the seconds of audio the webview has queued now: what it last
+// reported, plus what has been sent since, less what has played since.  Reports arrive after a
+// delay, so the estimate stays current between them.
+func (a *WebviewAudioDriver) Buffered() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.since.IsZero() {
+		return a.buffered
+	}
+	return max(a.buffered+a.sent-time.Since(a.since).Seconds(), 0)
+}
@@ -2,6 +2,9 @@ package app
 
 import (
 	"context"
+	"encoding/base64"
+	"sync"
+	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
@@ -17,16 +20,101 @@ var DisplayEvents = []struct {
 	{Render, "RENDER"},
 }
 
-var frameBuffer [256 * 256 * 4]int
+// Visible frame dimensions, in pixels
+const (
+	FrameWidth  = 256
+	FrameHeight = 240
+)
+
+// latencyWindow is the number of frames in flight whose latency can be measured.  Frames
+// drawn after this many later frames have been sent are not measured.
+const latencyWindow = 8
+
+// Frame is a frame of RGBA pixels, sent to the webview with the RENDER event.
+// The pixels are base64 encoded, which is far more compact than a JSON array of numbers.
+type Frame struct {
+	Sequence int    `json:"sequence"` // number of the frame, echoed back through FrameDrawn
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Pixels   string `json:"pixels"` // base64 encoded RGBA pixels, row by row
+}
+
+// LatencyStats summarizes the latency of frames, from being sent to being drawn by the webview.
+type LatencyStats struct {
+	Sent   int     `json:"sent"`   // frames sent
+	Drawn  int     `json:"drawn"`  // frames drawn and measured
+	LastMs float64 `json:"lastMs"` // latency of the last frame drawn
+	MeanMs float64 `json:"meanMs"` // mean latency of the frames drawn
+	MaxMs  float64 `json:"maxMs"`  // worst latency of the frames drawn
+}
+
+// FrameStats summarizes the timing of the frames run by the emulator, for an fps overlay.
+type FrameStats struct {
+	TargetFPS float64 `json:"targetFps"` // frames per second of the emulated region
+	FPS       float64 `json:"fps"`       // frames per second run over the last second
+	Frames    int     `json:"frames"`    // frames run
+	Late      int     `json:"late"`      // frames which finished after they were due
+	LastMs    float64 `json:"lastMs"`    // time taken to emulate the last frame
+	MeanMs    float64 `json:"meanMs"`    // mean time taken to emulate a frame
+	MaxMs     float64 `json:"maxMs"`     // worst time taken to emulate a frame
+}
 
 type WebviewDisplayDriver struct {
 	Ctx context.Context
+
+	mu       sync.Mutex
+	sequence int                      // sequence of the next frame
+	sentAt   [latencyWindow]time.Time // when each of the last frames in flight was sent
+	stats    LatencyStats             // latency of the frames drawn so far
+	total    time.Duration            // summed latency of the frames drawn
 }
 
 func NewWebviewDisplayDriver() *WebviewDisplayDriver {
 	return &WebviewDisplayDriver{}
 }
 
-func (w *WebviewDisplayDriver) renderFrame() {
-	runtime.EventsEmit(w.Ctx, string(Render), frameBuffer)
+// RenderFrame sends a FrameWidth by FrameHeight frame of RGBA pixels to the webview.
+// rgba is not retained.
+func (w *WebviewDisplayDriver) RenderFrame(rgba []byte) {
+	frame := Frame{
+		Width:  FrameWidth,
+		Height: FrameHeight,
+		Pixels: base64.StdEncoding.EncodeToString(rgba),
+	}
+
+	w.mu.Lock()
+	frame.Sequence = w.sequence
+	w.sentAt[w.sequence%latencyWindow] = time.Now()
+	w.sequence++
+	w.stats.Sent++
+	w.mu.Unlock()
+
+	if w.Ctx != nil {
+		runtime.EventsEmit(w.Ctx, string(Render), frame)
+	}
+}
+
+// FrameDrawn is called by the webview once it has drawn the frame numbered sequence,
+// measuring the latency of the frame.
+func (w *WebviewDisplayDriver) FrameDrawn(sequence int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if sequence < 0 || sequence >= w.sequence || w.sequence-sequence > latencyWindow {
+		return
+	}
+
+	latency := time.Since(w.sentAt[sequence%latencyWindow])
+	w.total += latency
+	w.stats.Drawn++
+	w.stats.LastMs = float64(latency) / float64(time.Millisecond)
+	w.stats.MeanMs = float64(w.total) / float64(w.stats.Drawn) / float64(time.Millisecond)
+	w.stats.MaxMs = max(w.stats.MaxMs, w.stats.LastMs)
+}
+
+// Latency returns the latency of the frames drawn so far.
+func (w *WebviewDisplayDriver) Latency() LatencyStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
 }
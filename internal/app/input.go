@@ -1,5 +1,7 @@
 package app
 
+import "sync"
+
 type Button string
 
 const (
@@ -42,7 +44,12 @@ var Joypads = []struct {
 	{Secondary, "SECONDARY"},
 }
 
+// buttonOrder is the order in which a joypad reports its buttons, from least significant bit.
+// See https://wiki.nesdev.com/w/index.php/Standard_controller.
+var buttonOrder = [8]Button{A, B, Select, Start, Up, Down, Left, Right}
+
 type WebviewInputDriver struct {
+	mu      sync.Mutex
 	joypad1 map[Button]bool
 	joypad2 map[Button]bool
 }
@@ -85,9 +92,26 @@ func (w *WebviewInputDriver) getJoypadState(joypad Joypad, button Button) bool {
 }
 
 func (w *WebviewInputDriver) SetButton(joypad Joypad, button Button, to bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.setJoypadState(joypad, button, to)
 }
 
 func (w *WebviewInputDriver) getButton(joypad Joypad, button Button) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	return w.getJoypadState(joypad, button)
 }
+
+// JoypadButtons returns the buttons held on joypad, one bit per button in the order the joypad
+// reports them, A first.
+func (w *WebviewInputDriver) JoypadButtons(joypad Joypad) (buttons byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for bit, button := range buttonOrder {
+		if w.getJoypadState(joypad, button) {
+			buttons |= 1 << bit
+		}
+	}
+	return buttons
+}
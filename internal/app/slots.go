@@ -0,0 +1,10 @@
+package app
+
+// SaveSlot describes a quick save slot of the loaded game.
+type SaveSlot struct {
+	Slot      int     `json:"slot"`                // number of the slot, from 1
+	Used      bool    `json:"used"`                // whether or not a state is saved in the slot
+	SavedAt   int64   `json:"savedAt"`             // when the state was saved, in milliseconds since the unix epoch
+	PlayTime  float64 `json:"playTime"`            // seconds of emulated play when the state was saved
+	Thumbnail string  `json:"thumbnail,omitempty"` // base64 encoded PNG of the frame when the state was saved
+}
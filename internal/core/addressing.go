@@ -37,12 +37,14 @@ func (c *cpu) getAddressWithMode(addressingMode int) (addr uint16) {
 		// The address will only be jumped to if the branch succeeeds.
 		// Note: relative addressing uses twos complement to branch both
 		// forwards and backwards.
+		// The offset is relative to the instruction following the branch.
 		offset := uint16(c.Read(c.pc + 1))
+		next := c.pc + 2
 		if offset >= 0x80 {
 			// interpret as negative number
-			return c.pc + offset - 0x100
+			return next + offset - 0x100
 		}
-		return c.pc + offset
+		return next + offset
 
 	case modeImmediate:
 		// Instructions with modeImmediate take 2 bytes:
@@ -73,11 +75,11 @@ func (c *cpu) getAddressWithMode(addressingMode int) (addr uint16) {
 
 	case modeAbsoluteX:
 		// Same as modeAbsolute, with address being added to contents of X register
-		return c.read16(c.pc+1) + uint16(c.x)
+		return c.indexed(c.read16(c.pc+1), c.x)
 
 	case modeAbsoluteY:
 		// Same as modeAbsolute, with address being added to contents of Y register
-		return c.read16(c.pc+1) + uint16(c.y)
+		return c.indexed(c.read16(c.pc+1), c.y)
 
 	case modeIndirect:
 		// Instructions with modeIndirect take 3 bytes:
@@ -85,16 +87,18 @@ func (c *cpu) getAddressWithMode(addressingMode int) (addr uint16) {
 		// 2. least significant byte of address
 		// 3. most significant byte of address
 		// The formulated address, along with the next,
-		// are then accessed again to get the final address.
-		return c.read16(c.read16(c.pc + 1))
+		// are then accessed again to get the final address.  The second byte is read from
+		// the same page as the first, even if the first is at the end of a page.
+		return c.read16Bugged(c.read16(c.pc + 1))
 
 	case modeIndirectX:
 		// Instructions with modeIndirectX take 2 bytes:
 		// 1. opcode
 		// 2. single byte
 		// The byte is then added to the X register, which then
-		// gives the least significant byte of the target address.
-		return c.read16(uint16(c.Read(c.pc+1) + c.x))
+		// gives the least significant byte of the target address.  Both bytes of the target
+		// address are read from the zero page.
+		return c.read16Bugged(uint16(c.Read(c.pc+1) + c.x))
 
 	case modeIndirectY:
 		// Instructions with modeIndirectY take 2 bytes:
@@ -103,7 +107,7 @@ func (c *cpu) getAddressWithMode(addressingMode int) (addr uint16) {
 		// The zero page address is then accessed, and the data
 		// is added to the Y register. The resulting data is the
 		// target address.
-		return c.read16(uint16(c.Read(c.pc+1))) + uint16(c.y)
+		return c.indexed(c.read16Bugged(uint16(c.Read(c.pc+1))), c.y)
 
 	default:
 		// shouldn't happen, but handle gracefully
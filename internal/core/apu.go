@@ -1,9 +1,901 @@
 package core
 
-// apu is the audio processing unit of the nes.
-type apu struct{}
+// apu register addresses
+const (
+	apuStart      = 0x4000
+	apuStatus     = 0x4015
+	apuFrameCount = 0x4017
+	apuEnd        = 0x4017
+)
 
-// NewApu creates a new apu.
-func newApu() (a *apu) {
-	return &apu{}
+// sampleRate is the rate at which the apu outputs audio samples, in Hz.
+const sampleRate = 44100
+
+// lengthTable maps the length counter load values written to the channel registers
+// to length counter values.
+// See https://wiki.nesdev.com/w/index.php/APU_Length_Counter.
+var lengthTable = [32]byte{
+	10, 254, 20, 2, 40, 4, 80, 6, 160, 8, 60, 10, 14, 12, 26, 14,
+	12, 16, 24, 18, 48, 20, 96, 22, 192, 24, 72, 26, 16, 28, 32, 30,
+}
+
+// dutyTable holds the waveforms of the pulse channel duty cycles.
+var dutyTable = [4][8]byte{
+	{0, 1, 0, 0, 0, 0, 0, 0},
+	{0, 1, 1, 0, 0, 0, 0, 0},
+	{0, 1, 1, 1, 1, 0, 0, 0},
+	{1, 0, 0, 1, 1, 1, 1, 1},
+}
+
+// triangleTable is the waveform of the triangle channel.
+var triangleTable = [32]byte{
+	15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0,
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+}
+
+// Mixer lookup tables, approximating the nonlinear output of the apu DACs.
+// See https://wiki.nesdev.com/w/index.php/APU_Mixer#Lookup_Table.
+var (
+	pulseMixTable [31]float32
+	tndMixTable   [203]float32
+)
+
+func init() {
+	for n := 1; n < len(pulseMixTable); n++ {
+		pulseMixTable[n] = 95.52 / (8128/float32(n) + 100)
+	}
+	for n := 1; n < len(tndMixTable); n++ {
+		tndMixTable[n] = 163.67 / (24329/float32(n) + 100)
+	}
+}
+
+// expansionAudio is implemented by mappers with their own sound channels, which are mixed
+// with the output of the apu.
+type expansionAudio interface {
+	// audioOutput returns the current output level of the expansion channels, from 0 to 1
+	// relative to the apu at full volume.
+	audioOutput() float32
+}
+
+// envelope is the volume envelope of the pulse and noise channels.
+// See https://wiki.nesdev.com/w/index.php/APU_Envelope.
+type envelope struct {
+	start    bool // whether or not the envelope restarts on its next clock
+	loop     bool // whether or not the decay level loops (also halts the length counter)
+	constant bool // whether or not volume is output directly
+	volume   byte // constant volume, or the divider period
+	divider  byte
+	decay    byte
+}
+
+// write writes the envelope bits of a channel control register.
+func (e *envelope) write(data byte) {
+	e.loop = data&mask5 != 0
+	e.constant = data&mask4 != 0
+	e.volume = data & 0x0F
+}
+
+// clock clocks the envelope, once per quarter frame.
+func (e *envelope) clock() {
+	if e.start {
+		e.start = false
+		e.decay = 15
+		e.divider = e.volume
+		return
+	}
+
+	if e.divider > 0 {
+		e.divider--
+		return
+	}
+	e.divider = e.volume
+
+	switch {
+	case e.decay > 0:
+		e.decay--
+	case e.loop:
+		e.decay = 15
+	}
+}
+
+// output returns the current volume of the envelope.
+func (e *envelope) output() byte {
+	if e.constant {
+		return e.volume
+	}
+	return e.decay
+}
+
+// pulse is a square wave channel, at $4000-$4003 or $4004-$4007.
+// See https://wiki.nesdev.com/w/index.php/APU_Pulse.
+type pulse struct {
+	envelope
+
+	enabled   bool
+	second    bool // whether or not this is pulse 2, whose sweep negates in two's complement
+	sweepless bool // whether or not the channel lacks a sweep unit, as on the MMC5
+	duty      byte
+	step      byte // position within the duty cycle
+	timer     uint16
+	period    uint16 // 11 bit timer period
+	length    byte
+
+	sweepEnabled bool
+	sweepNegate  bool
+	sweepReload  bool
+	sweepPeriod  byte
+	sweepShift   byte
+	sweepDivider byte
+}
+
+// write writes to register 0-3 of the channel.
+func (p *pulse) write(register uint16, data byte) {
+	switch register {
+	case 0:
+		p.duty = data >> 6
+		p.envelope.write(data)
+	case 1:
+		p.sweepEnabled = data&mask7 != 0
+		p.sweepPeriod = data >> 4 & 0x07
+		p.sweepNegate = data&mask3 != 0
+		p.sweepShift = data & 0x07
+		p.sweepReload = true
+	case 2:
+		p.period = p.period&0x0700 | uint16(data)
+	case 3:
+		p.period = p.period&0x00FF | uint16(data&0x07)<<8
+		if p.enabled {
+			p.length = lengthTable[data>>3]
+		}
+		p.step = 0
+		p.start = true
+	}
+}
+
+// setEnabled enables or disables the channel through $4015.
+func (p *pulse) setEnabled(enabled bool) {
+	p.enabled = enabled
+	if !enabled {
+		p.length = 0
+	}
+}
+
+// clockTimer clocks the channel timer, once every other cpu cycle.
+func (p *pulse) clockTimer() {
+	if p.timer > 0 {
+		p.timer--
+		return
+	}
+	p.timer = p.period
+	p.step = (p.step + 1) % 8
+}
+
+// clockLength clocks the length counter, once per half frame.
+func (p *pulse) clockLength() {
+	if p.length > 0 && !p.loop {
+		p.length--
+	}
+}
+
+// sweepTarget returns the period the sweep unit is moving towards.
+func (p *pulse) sweepTarget() uint16 {
+	change := p.period >> p.sweepShift
+	if !p.sweepNegate {
+		return p.period + change
+	}
+	if p.second {
+		return p.period - change
+	}
+	return p.period - change - 1
+}
+
+// clockSweep clocks the sweep unit, once per half frame.
+func (p *pulse) clockSweep() {
+	target := p.sweepTarget()
+	if p.sweepDivider == 0 && p.sweepEnabled && p.sweepShift > 0 && p.period >= 8 && target <= 0x07FF {
+		p.period = target
+	}
+
+	if p.sweepDivider == 0 || p.sweepReload {
+		p.sweepDivider = p.sweepPeriod
+		p.sweepReload = false
+	} else {
+		p.sweepDivider--
+	}
+}
+
+// output returns the current 4 bit output of the channel.
+func (p *pulse) output() byte {
+	if p.length == 0 || dutyTable[p.duty][p.step] == 0 {
+		return 0
+	}
+	if !p.sweepless && (p.period < 8 || p.sweepTarget() > 0x07FF) {
+		return 0
+	}
+	return p.envelope.output()
+}
+
+// triangle is the triangle wave channel, at $4008-$400B.
+// See https://wiki.nesdev.com/w/index.php/APU_Triangle.
+type triangle struct {
+	enabled       bool
+	control       bool // whether or not the linear counter reloads forever (also halts the length counter)
+	linearLoad    byte
+	linear        byte
+	linearReload  bool
+	step          byte // position within triangleTable
+	timer, period uint16
+	length        byte
+}
+
+// write writes to register 0-3 of the channel.
+func (t *triangle) write(register uint16, data byte) {
+	switch register {
+	case 0:
+		t.control = data&mask7 != 0
+		t.linearLoad = data & 0x7F
+	case 2:
+		t.period = t.period&0x0700 | uint16(data)
+	case 3:
+		t.period = t.period&0x00FF | uint16(data&0x07)<<8
+		if t.enabled {
+			t.length = lengthTable[data>>3]
+		}
+		t.linearReload = true
+	}
+}
+
+// setEnabled enables or disables the channel through $4015.
+func (t *triangle) setEnabled(enabled bool) {
+	t.enabled = enabled
+	if !enabled {
+		t.length = 0
+	}
+}
+
+// clockTimer clocks the channel timer, once every cpu cycle.
+func (t *triangle) clockTimer() {
+	if t.timer > 0 {
+		t.timer--
+		return
+	}
+	t.timer = t.period
+
+	// Periods below 2 are ultrasonic, and are silenced rather than producing a pop
+	if t.length > 0 && t.linear > 0 && t.period >= 2 {
+		t.step = (t.step + 1) % 32
+	}
+}
+
+// clockLinear clocks the linear counter, once per quarter frame.
+func (t *triangle) clockLinear() {
+	if t.linearReload {
+		t.linear = t.linearLoad
+	} else if t.linear > 0 {
+		t.linear--
+	}
+
+	if !t.control {
+		t.linearReload = false
+	}
+}
+
+// clockLength clocks the length counter, once per half frame.
+func (t *triangle) clockLength() {
+	if t.length > 0 && !t.control {
+		t.length--
+	}
+}
+
+// output returns the current 4 bit output of the channel.
+func (t *triangle) output() byte {
+	return triangleTable[t.step]
+}
+
+// noise is the pseudo-random noise channel, at $400C-$400F.
+// See https://wiki.nesdev.com/w/index.php/APU_Noise.
+type noise struct {
+	envelope
+
+	enabled       bool
+	mode          bool   // whether or not the shift register produces short, metallic sequences
+	shift         uint16 // 15 bit linear feedback shift register
+	timer, period uint16
+	length        byte
+
+	periods *[16]uint16 // timer periods selected by $400E
+}
+
+// write writes to register 0-3 of the channel.
+func (n *noise) write(register uint16, data byte) {
+	switch register {
+	case 0:
+		n.envelope.write(data)
+	case 2:
+		n.mode = data&mask7 != 0
+		n.period = n.periods[data&0x0F]
+	case 3:
+		if n.enabled {
+			n.length = lengthTable[data>>3]
+		}
+		n.start = true
+	}
+}
+
+// setEnabled enables or disables the channel through $4015.
+func (n *noise) setEnabled(enabled bool) {
+	n.enabled = enabled
+	if !enabled {
+		n.length = 0
+	}
+}
+
+// clockTimer clocks the channel timer, once every other cpu cycle.
+func (n *noise) clockTimer() {
+	if n.timer > 0 {
+		n.timer--
+		return
+	}
+	n.timer = n.period
+
+	tap := uint16(1)
+	if n.mode {
+		tap = 6
+	}
+	feedback := (n.shift ^ n.shift>>tap) & 1
+	n.shift = n.shift>>1 | feedback<<14
+}
+
+// clockLength clocks the length counter, once per half frame.
+func (n *noise) clockLength() {
+	if n.length > 0 && !n.loop {
+		n.length--
+	}
+}
+
+// output returns the current 4 bit output of the channel.
+func (n *noise) output() byte {
+	if n.length == 0 || n.shift&1 != 0 {
+		return 0
+	}
+	return n.envelope.output()
+}
+
+// dmc is the delta modulation channel, at $4010-$4013, which plays 1 bit delta encoded
+// samples read from cpu memory.
+// See https://wiki.nesdev.com/w/index.php/APU_DMC.
+type dmc struct {
+	mem   *memory     // memory samples are read from
+	rates *[16]uint16 // timer periods selected by $4010
+
+	irqEnabled    bool
+	irq           bool
+	loop          bool
+	timer, period uint16
+	level         byte // 7 bit output level
+
+	sampleAddress uint16
+	sampleLength  uint16
+	address       uint16 // address of the next sample byte
+	remaining     uint16 // sample bytes remaining
+
+	buffer      byte // sample buffer
+	bufferEmpty bool
+	shift       byte // output shift register
+	bitsLeft    byte
+	silent      bool
+
+	stall     int  // cpu cycles stolen by sample fetches, not yet taken by takeStall
+	duringOAM bool // whether or not an oam dma is in progress, making sample fetches cheaper
+}
+
+// write writes to register 0-3 of the channel.
+func (d *dmc) write(register uint16, data byte) {
+	switch register {
+	case 0:
+		d.irqEnabled = data&mask7 != 0
+		d.loop = data&mask6 != 0
+		d.period = d.rates[data&0x0F]
+		if !d.irqEnabled {
+			d.irq = false
+		}
+	case 1:
+		d.level = data & 0x7F
+	case 2:
+		d.sampleAddress = 0xC000 | uint16(data)<<6
+	case 3:
+		d.sampleLength = uint16(data)<<4 | 1
+	}
+}
+
+// setEnabled enables or disables the channel through $4015.
+func (d *dmc) setEnabled(enabled bool) {
+	d.irq = false
+	if !enabled {
+		d.remaining = 0
+		return
+	}
+
+	if d.remaining == 0 {
+		d.restart()
+		d.fillBuffer()
+	}
+}
+
+// restart restarts the sample from its beginning.
+func (d *dmc) restart() {
+	d.address = d.sampleAddress
+	d.remaining = d.sampleLength
+}
+
+// fillBuffer reads the next sample byte into the sample buffer, if it is empty.
+func (d *dmc) fillBuffer() {
+	if !d.bufferEmpty || d.remaining == 0 || d.mem == nil {
+		return
+	}
+
+	d.buffer = d.mem.Read(d.address)
+	d.bufferEmpty = false
+	if d.duringOAM {
+		d.stall += dmcOAMStallCycles
+	} else {
+		d.stall += dmcStallCycles
+	}
+	d.address++
+	if d.address == 0 {
+		d.address = 0x8000
+	}
+
+	d.remaining--
+	if d.remaining == 0 {
+		switch {
+		case d.loop:
+			d.restart()
+		case d.irqEnabled:
+			d.irq = true
+		}
+	}
+}
+
+// clockTimer clocks the channel timer, once every cpu cycle.
+func (d *dmc) clockTimer() {
+	if d.timer > 0 {
+		d.timer--
+		return
+	}
+	d.timer = d.period - 1
+
+	if !d.silent {
+		if d.shift&1 != 0 {
+			if d.level <= 125 {
+				d.level += 2
+			}
+		} else if d.level >= 2 {
+			d.level -= 2
+		}
+	}
+	d.shift >>= 1
+
+	if d.bitsLeft > 0 {
+		d.bitsLeft--
+	}
+	if d.bitsLeft == 0 {
+		d.bitsLeft = 8
+		d.silent = d.bufferEmpty
+		if !d.bufferEmpty {
+			d.shift = d.buffer
+			d.bufferEmpty = true
+			d.fillBuffer()
+		}
+	}
+}
+
+// output returns the current 7 bit output of the channel.
+func (d *dmc) output() byte {
+	return d.level
+}
+
+// apu is the audio processing unit of the nes, mapped to $4000-$4013, $4015 and $4017.
+// See https://wiki.nesdev.com/w/index.php/APU.
+type apu struct {
+	pulse1   pulse
+	pulse2   pulse
+	triangle triangle
+	noise    noise
+	dmc      dmc
+
+	timing *timing // clock rate and period tables of the region
+
+	// frame counter ($4017)
+	cycle      int  // cpu cycles since the start of the frame counter sequence
+	fiveStep   bool // whether or not the 5 step sequence is selected
+	irqInhibit bool
+	frameIRQ   bool
+	evenCycle  bool // whether or not the current cpu cycle is the first of an apu cycle
+
+	expansion expansionAudio // cartridge sound channels, or nil if there are none
+
+	// output sampling
+	sampleTimer int       // accumulates sampleRate each cpu cycle, emitting a sample at the cpu clock rate
+	sampleSum   float32   // sum of outputs since the last sample
+	sampleCount int       // number of outputs summed in sampleSum
+	samples     []float32 // samples output since the last drainSamples
+}
+
+// NewApu creates a new apu, timed as t.
+func newApu(t *timing) (a *apu) {
+	a = &apu{}
+	a.pulse2.second = true
+	a.noise.shift = 1
+	a.dmc.bufferEmpty = true
+	a.dmc.bitsLeft = 8
+	a.useTiming(t)
+	a.noise.period = a.noise.periods[0]
+	a.dmc.period = a.dmc.rates[0]
+	return a
+}
+
+// useTiming times the apu as t.  Periods already selected are kept until next written.
+func (a *apu) useTiming(t *timing) {
+	a.timing = t
+	a.noise.periods = &t.noisePeriods
+	a.dmc.rates = &t.dmcRates
+}
+
+// useMemory associates the apu with main memory m, from which dmc samples are read.
+func (a *apu) useMemory(m *memory) {
+	a.dmc.mem = m
+}
+
+// useExpansionAudio mixes the sound channels of e into the output of the apu.
+// If e is nil, only the apu channels are output.
+func (a *apu) useExpansionAudio(e expansionAudio) {
+	a.expansion = e
+}
+
+// readRegister implements memoryMappedIO.
+// Only $4015 is readable; the remaining registers read as open bus.
+func (a *apu) readRegister(address uint16) (data byte) {
+	if address != apuStatus {
+		return 0x00
+	}
+
+	if a.pulse1.length > 0 {
+		data |= mask0
+	}
+	if a.pulse2.length > 0 {
+		data |= mask1
+	}
+	if a.triangle.length > 0 {
+		data |= mask2
+	}
+	if a.noise.length > 0 {
+		data |= mask3
+	}
+	if a.dmc.remaining > 0 {
+		data |= mask4
+	}
+	if a.frameIRQ {
+		data |= mask6
+	}
+	if a.dmc.irq {
+		data |= mask7
+	}
+
+	a.frameIRQ = false
+	return data
+}
+
+// writeRegister implements memoryMappedIO.
+func (a *apu) writeRegister(address uint16, data byte) {
+	switch {
+	case address <= 0x4003:
+		a.pulse1.write(address-0x4000, data)
+	case address <= 0x4007:
+		a.pulse2.write(address-0x4004, data)
+	case address <= 0x400B:
+		a.triangle.write(address-0x4008, data)
+	case address <= 0x400F:
+		a.noise.write(address-0x400C, data)
+	case address <= 0x4013:
+		a.dmc.write(address-0x4010, data)
+	case address == apuStatus:
+		a.pulse1.setEnabled(data&mask0 != 0)
+		a.pulse2.setEnabled(data&mask1 != 0)
+		a.triangle.setEnabled(data&mask2 != 0)
+		a.noise.setEnabled(data&mask3 != 0)
+		a.dmc.setEnabled(data&mask4 != 0)
+	case address == apuFrameCount:
+		a.fiveStep = data&mask7 != 0
+		a.irqInhibit = data&mask6 != 0
+		if a.irqInhibit {
+			a.frameIRQ = false
+		}
+		a.cycle = 0
+		if a.fiveStep {
+			a.clockQuarterFrame()
+			a.clockHalfFrame()
+		}
+	}
+}
+
+// clock clocks the apu once per cpu cycle.
+func (a *apu) clock() {
+	a.clockFrameCounter()
+
+	a.triangle.clockTimer()
+	a.dmc.clockTimer()
+	if a.evenCycle {
+		a.pulse1.clockTimer()
+		a.pulse2.clockTimer()
+		a.noise.clockTimer()
+	}
+	a.evenCycle = !a.evenCycle
+
+	a.sampleSum += a.output()
+	a.sampleCount++
+	a.sampleTimer += sampleRate
+	if a.sampleTimer >= a.timing.cpuClockRate {
+		a.sampleTimer -= a.timing.cpuClockRate
+		a.samples = append(a.samples, a.sampleSum/float32(a.sampleCount))
+		a.sampleSum, a.sampleCount = 0, 0
+	}
+}
+
+// clockFrameCounter advances the frame counter sequence by one cpu cycle.
+// See https://wiki.nesdev.com/w/index.php/APU_Frame_Counter.
+func (a *apu) clockFrameCounter() {
+	a.cycle++
+
+	frameCounterSteps := &a.timing.frameCounterSteps
+	switch a.cycle {
+	case frameCounterSteps[0], frameCounterSteps[2]:
+		a.clockQuarterFrame()
+	case frameCounterSteps[1]:
+		a.clockQuarterFrame()
+		a.clockHalfFrame()
+	case frameCounterSteps[3]:
+		if a.fiveStep {
+			return
+		}
+		a.clockQuarterFrame()
+		a.clockHalfFrame()
+		if !a.irqInhibit {
+			a.frameIRQ = true
+		}
+		a.cycle = 0
+	case frameCounterSteps[4]:
+		a.clockQuarterFrame()
+		a.clockHalfFrame()
+		a.cycle = 0
+	}
+}
+
+// clockQuarterFrame clocks the envelopes and the triangle linear counter.
+func (a *apu) clockQuarterFrame() {
+	a.pulse1.envelope.clock()
+	a.pulse2.envelope.clock()
+	a.noise.envelope.clock()
+	a.triangle.clockLinear()
+}
+
+// clockHalfFrame clocks the length counters and sweep units.
+func (a *apu) clockHalfFrame() {
+	a.pulse1.clockLength()
+	a.pulse2.clockLength()
+	a.triangle.clockLength()
+	a.noise.clockLength()
+	a.pulse1.clockSweep()
+	a.pulse2.clockSweep()
+}
+
+// takeStall returns the number of cpu cycles the dmc has stolen to fetch samples since it was
+// last called.
+func (a *apu) takeStall() (cycles int) {
+	cycles = a.dmc.stall
+	a.dmc.stall = 0
+	return cycles
+}
+
+// irqPending returns whether or not the frame counter or dmc irq is asserted.
+func (a *apu) irqPending() bool {
+	return a.frameIRQ || a.dmc.irq
+}
+
+// output returns the current mixed output level of the apu and any expansion audio.
+func (a *apu) output() (level float32) {
+	level = pulseMixTable[a.pulse1.output()+a.pulse2.output()]
+	level += tndMixTable[3*int(a.triangle.output())+2*int(a.noise.output())+int(a.dmc.output())]
+	if a.expansion != nil {
+		level += a.expansion.audioOutput()
+	}
+	return level
+}
+
+// drainSamples returns the audio samples output since the last call to drainSamples.
+func (a *apu) drainSamples() (samples []float32) {
+	samples, a.samples = a.samples, nil
+	return samples
+}
+
+// saveState writes the state of the envelope to w.
+func (e *envelope) saveState(w *stateWriter) {
+	w.bool(e.start)
+	w.bool(e.loop)
+	w.bool(e.constant)
+	w.u8(e.volume)
+	w.u8(e.divider)
+	w.u8(e.decay)
+}
+
+// loadState reads the state of the envelope from r.
+func (e *envelope) loadState(r *stateReader) {
+	e.start = r.bool()
+	e.loop = r.bool()
+	e.constant = r.bool()
+	e.volume = r.u8Index(16)
+	e.divider = r.u8()
+	e.decay = r.u8Index(16)
+}
+
+// saveState implements stateful.
+func (p *pulse) saveState(w *stateWriter) {
+	p.envelope.saveState(w)
+	w.bool(p.enabled)
+	w.u8(p.duty)
+	w.u8(p.step)
+	w.u16(p.timer)
+	w.u16(p.period)
+	w.u8(p.length)
+	w.bool(p.sweepEnabled)
+	w.bool(p.sweepNegate)
+	w.bool(p.sweepReload)
+	w.u8(p.sweepPeriod)
+	w.u8(p.sweepShift)
+	w.u8(p.sweepDivider)
+}
+
+// loadState implements stateful.
+func (p *pulse) loadState(r *stateReader) {
+	p.envelope.loadState(r)
+	p.enabled = r.bool()
+	p.duty = r.u8Index(len(dutyTable))
+	p.step = r.u8Index(len(dutyTable[0]))
+	p.timer = r.u16()
+	p.period = r.u16()
+	p.length = r.u8()
+	p.sweepEnabled = r.bool()
+	p.sweepNegate = r.bool()
+	p.sweepReload = r.bool()
+	p.sweepPeriod = r.u8()
+	p.sweepShift = r.u8()
+	p.sweepDivider = r.u8()
+}
+
+// saveState implements stateful.
+func (t *triangle) saveState(w *stateWriter) {
+	w.bool(t.enabled)
+	w.bool(t.control)
+	w.u8(t.linearLoad)
+	w.u8(t.linear)
+	w.bool(t.linearReload)
+	w.u8(t.step)
+	w.u16(t.timer)
+	w.u16(t.period)
+	w.u8(t.length)
+}
+
+// loadState implements stateful.
+func (t *triangle) loadState(r *stateReader) {
+	t.enabled = r.bool()
+	t.control = r.bool()
+	t.linearLoad = r.u8()
+	t.linear = r.u8()
+	t.linearReload = r.bool()
+	t.step = r.u8Index(len(triangleTable))
+	t.timer = r.u16()
+	t.period = r.u16()
+	t.length = r.u8()
+}
+
+// saveState implements stateful.
+func (n *noise) saveState(w *stateWriter) {
+	n.envelope.saveState(w)
+	w.bool(n.enabled)
+	w.bool(n.mode)
+	w.u16(n.shift)
+	w.u16(n.timer)
+	w.u16(n.period)
+	w.u8(n.length)
+}
+
+// loadState implements stateful.
+func (n *noise) loadState(r *stateReader) {
+	n.envelope.loadState(r)
+	n.enabled = r.bool()
+	n.mode = r.bool()
+	n.shift = r.u16()
+	n.timer = r.u16()
+	n.period = r.u16()
+	n.length = r.u8()
+}
+
+// saveState implements stateful.
+func (d *dmc) saveState(w *stateWriter) {
+	w.bool(d.irqEnabled)
+	w.bool(d.irq)
+	w.bool(d.loop)
+	w.u16(d.timer)
+	w.u16(d.period)
+	w.u8(d.level)
+	w.u16(d.sampleAddress)
+	w.u16(d.sampleLength)
+	w.u16(d.address)
+	w.u16(d.remaining)
+	w.u8(d.buffer)
+	w.bool(d.bufferEmpty)
+	w.u8(d.shift)
+	w.u8(d.bitsLeft)
+	w.bool(d.silent)
+	w.int(d.stall)
+	w.bool(d.duringOAM)
+}
+
+// loadState implements stateful.
+func (d *dmc) loadState(r *stateReader) {
+	d.irqEnabled = r.bool()
+	d.irq = r.bool()
+	d.loop = r.bool()
+	d.timer = r.u16()
+	d.period = r.u16()
+	d.level = r.u8Index(0x80)
+	d.sampleAddress = r.u16()
+	d.sampleLength = r.u16()
+	d.address = r.u16()
+	d.remaining = r.u16()
+	d.buffer = r.u8()
+	d.bufferEmpty = r.bool()
+	d.shift = r.u8()
+	d.bitsLeft = r.u8()
+	d.silent = r.bool()
+	d.stall = r.int()
+	d.duringOAM = r.bool()
+}
+
+// saveState implements stateful.  Expansion audio is saved with the mapper providing it.
+func (a *apu) saveState(w *stateWriter) {
+	a.pulse1.saveState(w)
+	a.pulse2.saveState(w)
+	a.triangle.saveState(w)
+	a.noise.saveState(w)
+	a.dmc.saveState(w)
+
+	w.int(a.cycle)
+	w.bool(a.fiveStep)
+	w.bool(a.irqInhibit)
+	w.bool(a.frameIRQ)
+	w.bool(a.evenCycle)
+
+	w.int(a.sampleTimer)
+	w.f32(a.sampleSum)
+	w.int(a.sampleCount)
+}
+
+// loadState implements stateful.
+func (a *apu) loadState(r *stateReader) {
+	a.pulse1.loadState(r)
+	a.pulse2.loadState(r)
+	a.triangle.loadState(r)
+	a.noise.loadState(r)
+	a.dmc.loadState(r)
+
+	a.cycle = r.int()
+	a.fiveStep = r.bool()
+	a.irqInhibit = r.bool()
+	a.frameIRQ = r.bool()
+	a.evenCycle = r.bool()
+
+	a.sampleTimer = r.int()
+	a.sampleSum = r.f32()
+	a.sampleCount = r.int()
 }
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+)
+
+// Archive magic numbers
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	gzipMagic     = []byte{0x1F, 0x8B}
+	sevenZipMagic = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+)
+
+// romExts are the extensions of files picked by default from a multi-file archive, in order of
+// preference.
+var romExts = []string{".nes", ".unf", ".unif", ".fds", ".nsf", ".nsfe"}
+
+// maxEntrySize is the most bytes decompressed from an archive entry, which is far more than any
+// rom needs, so that a maliciously compressed archive cannot exhaust memory.
+const maxEntrySize = 64 << 20
+
+// errArchiveEntryNotFound is an error related to a requested entry not being present in an archive.
+type errArchiveEntryNotFound string
+
+// Error implements error.
+func (err errArchiveEntryNotFound) Error() string {
+	return fmt.Sprintf("archive entry not found: %v", string(err))
+}
+
+// archiveEntry is a single file within an archive.
+type archiveEntry struct {
+	name string
+	open func() (io.ReadCloser, error)
+	sole bool // whether the archive format holds only this entry, as gzip does
+}
+
+// openArchive opens the archive at path, detecting its format (zip, gzip or 7z) from its contents.
+// If the file at path is not an archive, entries is nil.  closer must be closed once all entries
+// have been read.
+func openArchive(path string) (entries []archiveEntry, closer io.Closer, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	magic := make([]byte, len(sevenZipMagic))
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		file.Close()
+		return nil, nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		file.Close()
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range r.File {
+			if !f.FileInfo().IsDir() {
+				entries = append(entries, archiveEntry{name: f.Name, open: f.Open})
+			}
+		}
+		return entries, r, nil
+
+	case bytes.HasPrefix(magic, sevenZipMagic):
+		file.Close()
+		r, err := sevenzip.OpenReader(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, f := range r.File {
+			if !f.FileInfo().IsDir() {
+				entries = append(entries, archiveEntry{name: f.Name, open: f.Open})
+			}
+		}
+		return entries, r, nil
+
+	case bytes.HasPrefix(magic, gzipMagic):
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		r, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		// gzip holds a single file, named in its header or after the archive itself
+		name := r.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		open := func() (io.ReadCloser, error) {
+			return r, nil
+		}
+		return []archiveEntry{{name: name, open: open, sole: true}}, file, nil
+
+	default:
+		file.Close()
+		return nil, nil, nil
+	}
+}
+
+// archiveEntries returns the names of the files within the archive at path.
+// If the file at path is not an archive, returns nil.
+func archiveEntries(path string) ([]string, error) {
+	entries, closer, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.name
+	}
+	return names, nil
+}
+
+// defaultEntry picks the entry to load from an archive when none is requested: the first .nes
+// entry, or failing that the first entry with another rom extension.  The entry of a gzip is
+// picked whatever its name, which is often just that of the archive without ".gz".
+func defaultEntry(entries []archiveEntry) (archiveEntry, bool) {
+	for _, romExt := range romExts {
+		for _, entry := range entries {
+			if strings.ToLower(filepath.Ext(entry.name)) == romExt {
+				return entry, true
+			}
+		}
+	}
+	if len(entries) == 1 && entries[0].sole {
+		return entries[0], true
+	}
+	return archiveEntry{}, false
+}
+
+// readROM reads the contents of the rom at path.  If path is a zip, gzip or 7z archive, the rom
+// is decompressed from the archive entry named entry, or the default entry if entry is empty.
+// Returns the name of the entry read, which is empty if path is not an archive.
+func readROM(path, entry string) (data []byte, entryName string, err error) {
+	entries, closer, err := openArchive(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if closer == nil {
+		if entry != "" {
+			return nil, "", errArchiveEntryNotFound(fmt.Sprintf("%v is not an archive", path))
+		}
+		data, err := os.ReadFile(path)
+		return data, "", err
+	}
+	defer closer.Close()
+
+	var selected archiveEntry
+	found := false
+	if entry == "" {
+		selected, found = defaultEntry(entries)
+	} else {
+		for _, e := range entries {
+			if e.name == entry {
+				selected, found = e, true
+				break
+			}
+		}
+	}
+	switch {
+	case !found && entry == "":
+		return nil, "", errArchiveEntryNotFound(fmt.Sprintf("no rom in %v", path))
+	case !found:
+		return nil, "", errArchiveEntryNotFound(fmt.Sprintf("%q in %v", entry, path))
+	}
+
+	r, err := selected.open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+
+	data, err = io.ReadAll(io.LimitReader(r, maxEntrySize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxEntrySize {
+		return nil, "", fmt.Errorf("%v in %v is larger than %vMB", selected.name, path, maxEntrySize>>20)
+	}
+
+	return data, selected.name, nil
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCartridgeFromZip(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 1}
+	rom, err := os.ReadFile(writeROM(t, "game.nes", header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"readme.txt", []byte("hello")},
+		{"game (USA).nes", rom},
+		{"game (Europe).nes", rom},
+	} {
+		f, err := w.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(file.data)
+	}
+	w.Close()
+
+	path := filepath.Join(t.TempDir(), "game.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := archiveEntries(path)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("want 3 entries, got %v (%v)", entries, err)
+	}
+
+	cart, err := newCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.entry != "game (USA).nes" {
+		t.Errorf("default entry: want first .nes file, got %q", cart.entry)
+	}
+
+	cart, err = newArchivedCartridge(path, "game (Europe).nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.entry != "game (Europe).nes" || cart.path != path {
+		t.Errorf("wrong entry loaded: %v", cart)
+	}
+
+	if _, err := newArchivedCartridge(path, "missing.nes"); err == nil {
+		t.Error("want error for missing entry")
+	}
+}
+
+func TestCartridgeFromGzip(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 1}
+	rom, err := os.ReadFile(writeROM(t, "game.nes", header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(rom)
+	w.Close()
+
+	// The entry is named after the archive, and is loaded even without a rom extension
+	for _, name := range []string{"game.nes", "game"} {
+		path := filepath.Join(t.TempDir(), name+".gz")
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		cart, err := newCartridge(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cart.entry != name || len(cart.prgROM) != prgROMBankLen {
+			t.Errorf("gzip not decompressed: %v", cart)
+		}
+	}
+}
+
+// write7z writes a 7z archive holding data, stored uncompressed as the file name, returning its
+// path.  See 7zFormat.txt in the 7-Zip sources.
+func write7z(t *testing.T, name string, data []byte) string {
+	t.Helper()
+
+	number := func(v int) []byte {
+		return binary.LittleEndian.AppendUint64([]byte{0xFF}, uint64(v))
+	}
+
+	header := []byte{0x01, 0x04}        // header, main streams info
+	header = append(header, 0x06, 0x00) // pack info at offset 0
+	header = append(header, 0x01, 0x09) // one pack stream, of size
+	header = append(header, number(len(data))...)
+	header = append(header, 0x00, 0x07, 0x0B, 0x01, 0x00) // end, unpack info, one folder
+	header = append(header, 0x01, 0x01, 0x00)             // with one coder: copy
+	header = append(header, 0x0C)                         // of unpack size
+	header = append(header, number(len(data))...)
+	header = append(header, 0x00, 0x00) // end of unpack info, end of streams info
+
+	utf16 := []byte{}
+	for _, r := range name + "\x00" {
+		utf16 = binary.LittleEndian.AppendUint16(utf16, uint16(r))
+	}
+	header = append(header, 0x05, 0x01, 0x11) // files info: one file, names
+	header = append(header, number(len(utf16)+1)...)
+	header = append(header, 0x00) // not external
+	header = append(header, utf16...)
+	header = append(header, 0x00, 0x00) // end of files info, end of header
+
+	start := binary.LittleEndian.AppendUint64(nil, uint64(len(data)))
+	start = binary.LittleEndian.AppendUint64(start, uint64(len(header)))
+	start = binary.LittleEndian.AppendUint32(start, crc32.ChecksumIEEE(header))
+
+	archive := append(append([]byte{}, sevenZipMagic...), 0, 4)
+	archive = binary.LittleEndian.AppendUint32(archive, crc32.ChecksumIEEE(start))
+	archive = append(archive, start...)
+	archive = append(archive, data...)
+	archive = append(archive, header...)
+
+	path := filepath.Join(t.TempDir(), "archive.7z")
+	if err := os.WriteFile(path, archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCartridgeFrom7z(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 1}
+	rom, err := os.ReadFile(writeROM(t, "game.nes", header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := newCartridge(write7z(t, "game (USA).nes", rom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.entry != "game (USA).nes" || !bytes.Equal(cart.prgROM, rom[iNesHeaderLen:iNesHeaderLen+prgROMBankLen]) {
+		t.Errorf("7z not decompressed: %v", cart)
+	}
+
+	if _, err := newCartridge(write7z(t, "readme.txt", []byte("hello"))); !errors.As(err, new(errArchiveEntryNotFound)) {
+		t.Errorf("archive without a rom: want errArchiveEntryNotFound, got %v", err)
+	}
+}
+
+func TestDefaultEntry(t *testing.T) {
+	var entries []archiveEntry
+	for _, name := range []string{"readme.txt", "music.nsf", "game.FDS", "game.nes"} {
+		entries = append(entries, archiveEntry{name: name})
+	}
+
+	for _, want := range []string{"game.nes", "game.FDS", "music.nsf"} {
+		entry, ok := defaultEntry(entries)
+		if !ok || entry.name != want {
+			t.Errorf("default entry: want %q, got %q", want, entry.name)
+		}
+		entries = entries[:len(entries)-1]
+	}
+	if entry, ok := defaultEntry(entries); ok {
+		t.Errorf("default entry of %v: want none, got %q", entries, entry.name)
+	}
+}
@@ -0,0 +1,216 @@
+package core
+
+// Bandai FCG registers, mirrored every 16 bytes
+const (
+	fcgChrBankReg = 0x0 // $0-$7: 1kB chr bank selects
+	fcgPrgBankReg = 0x8
+	fcgMirrorReg  = 0x9
+	fcgIRQCtrlReg = 0xA
+	fcgIRQLoReg   = 0xB
+	fcgIRQHiReg   = 0xC
+	fcgEepromReg  = 0xD
+)
+
+// Bandai FCG board sizes
+const (
+	fcgChrBankLen = 0x0400
+)
+
+// bandaiFCG - iNES mappers #16 and #159.
+// Mapper #16 submapper 4 is the FCG-1/2 asic with registers at $6000-$7FFF.
+// Mapper #16 submapper 5 is the LZ93D50 asic with a 24C02 eeprom and registers at $8000-$FFFF.
+// Mapper #159 is the LZ93D50 asic with a 24C01 eeprom.
+// Mapper #16 submapper 0 is ambiguous, so registers are decoded at both ranges and a 24C02 is
+// assumed if the cartridge is battery backed.
+// See https://wiki.nesdev.com/w/index.php/Bandai_FCG_board.
+type bandaiFCG struct {
+	*cartridge
+
+	eeprom     *eeprom // nil if the board has no eeprom
+	regsAt6000 bool    // whether or not registers are decoded at $6000-$7FFF
+	regsAt8000 bool    // whether or not registers are decoded at $8000-$FFFF
+	latchedIRQ bool    // whether or not irq counter writes go to a latch (LZ93D50 only)
+
+	chrBanks   [8]byte // 1kB chr banks for $0000-$1FFF
+	prgBank    byte    // 16kB prg bank for $8000-$BFFF ($C000-$FFFF is fixed to the last bank)
+	mirroring  byte    // 0: vertical, 1: horizontal, 2: single screen low, 3: single screen high
+	eepromCtrl byte    // last value written to the eeprom control register
+
+	irqEnabled  bool
+	irqCounter  uint16
+	irqLatch    uint16
+	irqAsserted bool
+}
+
+// newBandaiFCG creates a Bandai FCG mapper for cartridge c.
+func newBandaiFCG(c *cartridge) *bandaiFCG {
+	b := &bandaiFCG{cartridge: c}
+
+	switch {
+	case c.mapperNum == 159:
+		b.regsAt8000 = true
+		b.latchedIRQ = true
+		b.eeprom = newEeprom(eeprom24C01Size)
+	case c.subMapperNum == 4:
+		b.regsAt6000 = true
+	case c.subMapperNum == 5:
+		b.regsAt8000 = true
+		b.latchedIRQ = true
+		b.eeprom = newEeprom(eeprom24C02Size)
+	default:
+		b.regsAt6000 = true
+		b.regsAt8000 = true
+		if c.hasSRAM {
+			b.latchedIRQ = true
+			b.eeprom = newEeprom(eeprom24C02Size)
+		}
+	}
+
+	return b
+}
+
+// readRegister implements memoryMappedIO.
+func (b *bandaiFCG) readRegister(address uint16) (data byte) {
+	switch {
+	case address >= 0xC000:
+		lastBank := len(b.prgROM)/prgROMBankLen - 1
+		return b.prgROM[lastBank*prgROMBankLen+int(address-0xC000)]
+	case address >= prgROMStart:
+		bank := int(b.prgBank) % (len(b.prgROM) / prgROMBankLen)
+		return b.prgROM[bank*prgROMBankLen+int(address-prgROMStart)]
+	case address >= prgRAMStart && b.eeprom != nil:
+		// Bit 4 reflects the eeprom data line, but only while reads are enabled
+		if b.eepromCtrl&mask7 != 0 && b.eeprom.output() {
+			return mask4
+		}
+		return 0x00
+	default:
+		return 0x00
+	}
+}
+
+// writeRegister implements memoryMappedIO.
+func (b *bandaiFCG) writeRegister(address uint16, data byte) {
+	at6000 := address >= prgRAMStart && address < prgROMStart
+	at8000 := address >= prgROMStart
+	if !(at6000 && b.regsAt6000 || at8000 && b.regsAt8000) {
+		return
+	}
+
+	switch reg := address & 0x000F; {
+	case reg < fcgPrgBankReg:
+		b.chrBanks[reg] = data
+	case reg == fcgPrgBankReg:
+		b.prgBank = data & 0x0F
+	case reg == fcgMirrorReg:
+		b.mirroring = data & mask01
+	case reg == fcgIRQCtrlReg:
+		b.irqEnabled = data&mask0 != 0
+		b.irqAsserted = false
+		if b.latchedIRQ {
+			b.irqCounter = b.irqLatch
+		}
+	case reg == fcgIRQLoReg:
+		b.writeIRQ(0xFF00, uint16(data))
+	case reg == fcgIRQHiReg:
+		b.writeIRQ(0x00FF, uint16(data)<<8)
+	case reg == fcgEepromReg:
+		b.eepromCtrl = data
+		if b.eeprom != nil {
+			b.eeprom.write(data&mask5 != 0, data&mask6 != 0)
+		}
+	}
+}
+
+// writeIRQ writes one byte of the irq counter (or latch, on the LZ93D50).
+// keep masks the byte which is not being written.
+func (b *bandaiFCG) writeIRQ(keep uint16, value uint16) {
+	if b.latchedIRQ {
+		b.irqLatch = b.irqLatch&keep | value
+	} else {
+		b.irqCounter = b.irqCounter&keep | value
+	}
+}
+
+// clock clocks the irq counter once per cpu cycle.
+func (b *bandaiFCG) clock() {
+	if !b.irqEnabled {
+		return
+	}
+
+	if b.irqCounter == 0 {
+		b.irqAsserted = true
+	}
+	b.irqCounter--
+}
+
+// irqPending returns whether or not the irq counter has asserted an irq.
+func (b *bandaiFCG) irqPending() bool {
+	return b.irqAsserted
+}
+
+// chrOffset returns the offset within chr memory of the ppu address.
+// Boards with chrRAM do not bank switch it.
+func (b *bandaiFCG) chrOffset(address uint16) int {
+	if len(b.chrROM) == 0 {
+		return int(address)
+	}
+	bank := int(b.chrBanks[address/fcgChrBankLen])
+	return bank*fcgChrBankLen + int(address%fcgChrBankLen)
+}
+
+// readChr implements mapper.
+func (b *bandaiFCG) readChr(address uint16) (data byte) {
+	return b.readChrMem(b.chrOffset(address))
+}
+
+// writeChr implements mapper.
+func (b *bandaiFCG) writeChr(address uint16, data byte) {
+	b.writeChrMem(b.chrOffset(address), data)
+}
+
+// nametableMirroring implements mirrorController.
+func (b *bandaiFCG) nametableMirroring() mirroring {
+	return [4]mirroring{mirrorVertical, mirrorHorizontal, mirrorSingleLow, mirrorSingleHigh}[b.mirroring]
+}
+
+// batteryRAM implements batteryBacked.
+// Bandai FCG boards store saves in an eeprom rather than battery backed prgRAM.
+func (b *bandaiFCG) batteryRAM() []byte {
+	if b.eeprom == nil {
+		return nil
+	}
+	return b.eeprom.data
+}
+
+// saveState implements stateful.
+func (b *bandaiFCG) saveState(w *stateWriter) {
+	b.cartridge.saveState(w)
+	w.bytes(b.chrBanks[:])
+	w.u8(b.prgBank)
+	w.u8(b.mirroring)
+	w.u8(b.eepromCtrl)
+	w.bool(b.irqEnabled)
+	w.u16(b.irqCounter)
+	w.u16(b.irqLatch)
+	w.bool(b.irqAsserted)
+	if b.eeprom != nil {
+		b.eeprom.saveState(w)
+	}
+}
+
+// loadState implements stateful.
+func (b *bandaiFCG) loadState(r *stateReader) {
+	b.cartridge.loadState(r)
+	r.bytes(b.chrBanks[:])
+	b.prgBank = r.u8()
+	b.mirroring = r.u8()
+	b.eepromCtrl = r.u8()
+	b.irqEnabled = r.bool()
+	b.irqCounter = r.u16()
+	b.irqLatch = r.u16()
+	b.irqAsserted = r.bool()
+	if b.eeprom != nil {
+		b.eeprom.loadState(r)
+	}
+}
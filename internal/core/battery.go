@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// saveFileExt is the extension of files holding battery backed memory.
+const saveFileExt = ".sav"
+
+// saveFlushInterval is how often battery backed memory is written to disk while a cartridge is inserted.
+const saveFlushInterval = 5 * time.Second
+
+// batteryBacked is implemented by mappers with memory which survives the nes being powered off,
+// e.g. battery backed prgRAM or a serial eeprom.
+type batteryBacked interface {
+	// batteryRAM returns the live contents of battery backed memory, or nil if there is none.
+	batteryRAM() []byte
+}
+
+// diffBacked is implemented by batteryBacked mappers whose battery backed memory is large and
+// mostly unchanged, such as a writable disk.  Their save files hold an ips patch against the
+// original contents, rather than the entire memory.
+type diffBacked interface {
+	batteryBacked
+
+	// original returns the contents of battery backed memory as loaded from the rom.
+	original() []byte
+}
+
+// ipsSaveExt is appended to the save file path of diffBacked mappers.
+const ipsSaveExt = ".ips"
+
+// saveFile persists the battery backed memory of a cartridge to disk.
+type saveFile struct {
+	path    string        // path of the .sav file
+	ram     batteryBacked // memory being persisted
+	flushed []byte        // contents of ram as of the last load or flush
+}
+
+// savePath returns the path of the save file for the rom at romPath.
+// Save files are kept next to the rom, unless savesDir is set.
+func savePath(romPath, savesDir string) string {
+	dir, name := filepath.Split(romPath)
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + saveFileExt
+	if savesDir != "" {
+		dir = savesDir
+	}
+
+	return filepath.Join(dir, name)
+}
+
+// newSaveFile creates a saveFile persisting ram to path.
+// If path already exists, its contents are loaded into ram.
+// If ram is diffBacked, ipsSaveExt is appended to path.
+func newSaveFile(path string, ram batteryBacked) (*saveFile, error) {
+	diff, isDiff := ram.(diffBacked)
+	if isDiff {
+		path += ipsSaveExt
+	}
+	s := &saveFile{path: path, ram: ram}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case isDiff:
+		patched, err := applyIPS(diff.original(), data)
+		if err != nil {
+			return nil, err
+		}
+		copy(ram.batteryRAM(), patched)
+	default:
+		copy(ram.batteryRAM(), data)
+	}
+
+	s.flushed = bytes.Clone(ram.batteryRAM())
+	return s, nil
+}
+
+// flush writes battery backed memory to disk, if it has changed since the last flush.
+func (s *saveFile) flush() error {
+	current := s.ram.batteryRAM()
+	if bytes.Equal(current, s.flushed) {
+		return nil
+	}
+
+	data := current
+	if diff, ok := s.ram.(diffBacked); ok {
+		data = makeIPS(diff.original(), current)
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+
+	s.flushed = bytes.Clone(current)
+	return nil
+}
+
+// writeFileAtomic writes data to path such that path either holds its previous contents or
+// all of data, even if the process crashes midway.  data is written to a temporary file in the
+// same directory, synced, and then renamed over path.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// i2c drives an eeprom the way a game would, through the Bandai FCG eeprom control register.
+type i2c struct {
+	b *bandaiFCG
+}
+
+func (bus i2c) set(scl, sda bool) {
+	data := byte(mask7)
+	if scl {
+		data |= mask5
+	}
+	if sda {
+		data |= mask6
+	}
+	bus.b.writeRegister(0x800D, data)
+}
+
+func (bus i2c) start() {
+	bus.set(true, true)
+	bus.set(true, false)
+	bus.set(false, false)
+}
+
+func (bus i2c) stop() {
+	bus.set(false, false)
+	bus.set(true, false)
+	bus.set(true, true)
+}
+
+func (bus i2c) sendByte(data byte) {
+	for i := 7; i >= 0; i-- {
+		bit := data&(1<<i) != 0
+		bus.set(false, bit)
+		bus.set(true, bit)
+		bus.set(false, bit)
+	}
+
+	// acknowledge clock
+	bus.set(false, true)
+	bus.set(true, true)
+	bus.set(false, true)
+}
+
+func (bus i2c) receiveByte() (data byte) {
+	for i := 7; i >= 0; i-- {
+		bus.set(false, true)
+		bus.set(true, true)
+		if bus.b.readRegister(0x6000)&mask4 != 0 {
+			data |= 1 << i
+		}
+		bus.set(false, true)
+	}
+
+	// no acknowledge, ending the sequential read
+	bus.set(false, true)
+	bus.set(true, true)
+	bus.set(false, true)
+	return data
+}
+
+func TestBandaiEepromSave(t *testing.T) {
+	cart := &cartridge{mapperNum: 16, subMapperNum: 5, prgROM: make([]byte, 2*prgROMBankLen)}
+	b := newBandaiFCG(cart)
+	bus := i2c{b}
+
+	// write 0x42 to word address 0x10
+	bus.start()
+	bus.sendByte(0xA0)
+	bus.sendByte(0x10)
+	bus.sendByte(0x42)
+	bus.stop()
+
+	// random read of word address 0x10
+	bus.start()
+	bus.sendByte(0xA0)
+	bus.sendByte(0x10)
+	bus.start()
+	bus.sendByte(0xA1)
+	if got := bus.receiveByte(); got != 0x42 {
+		t.Errorf("eeprom read: want 0x42, got 0x%02X", got)
+	}
+	bus.stop()
+
+	path := filepath.Join(t.TempDir(), "saves", "game.sav")
+	save, err := newSaveFile(path, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := save.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("unchanged save file should not be written")
+	}
+
+	b.batteryRAM()[0] = 0x99
+	if err := save.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newBandaiFCG(cart)
+	if _, err := newSaveFile(path, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reloaded.batteryRAM(), b.batteryRAM()) {
+		t.Error("reloaded save does not match flushed save")
+	}
+}
+
+func TestSavePath(t *testing.T) {
+	if got, want := savePath(filepath.Join("roms", "zelda.nes"), ""), filepath.Join("roms", "zelda.sav"); got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if got, want := savePath(filepath.Join("roms", "zelda.nes"), "saves"), filepath.Join("saves", "zelda.sav"); got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestUseCartridgeSaveFileError(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 1, mask1}
+	first, second := writeROM(t, "first.nes", header), writeROM(t, "second.nes", header)
+
+	n := NewNes(nil, nil, nil)
+	n.SetSavesDir(t.TempDir())
+	if err := n.UseCartridge(first); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown()
+
+	// A saves dir which is a file cannot hold save files
+	n.SetSavesDir(first)
+	if err := n.UseCartridge(second); err == nil {
+		t.Fatal("save file in a file: want error, got none")
+	}
+	if n.cart == nil || n.cart.path != first || n.save == nil {
+		t.Error("want the first cartridge to stay inserted and saving")
+	}
+}
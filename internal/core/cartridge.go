@@ -2,8 +2,13 @@ package core
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
 	"fmt"
-	"os"
+	"hash/crc32"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // iNES related memory sizes
@@ -12,8 +17,50 @@ const (
 	iNesTrainerLen = 0x200
 	prgROMBankLen  = 0x4000
 	chrROMBankLen  = 0x2000
+	prgRAMBankLen  = 0x2000
+	chrRAMLen      = 0x2000 // chrRAM size of boards without chrROM, unless given by the header
+	vRAMLen        = 0x0800 // extra nametable RAM of boards with four screen mirroring
 )
 
+// UNIF related sizes
+const (
+	unifHeaderLen      = 0x20
+	unifChunkHeaderLen = 0x08
+)
+
+// unifMagic is the magic number at the start of every UNIF file.
+var unifMagic = []byte("UNIF")
+
+// region is the console region (tv system) a cartridge was made for.
+// Values match the NES 2.0 cpu/ppu timing field.
+// See https://wiki.nesdev.com/w/index.php/NES_2.0#CPU.2FPPU_Timing.
+type region int
+
+// Console regions
+const (
+	regionUnknown region = iota - 1
+	regionNTSC
+	regionPAL
+	regionMulti
+	regionDendy
+)
+
+// String implements Stringer.
+func (r region) String() string {
+	switch r {
+	case regionNTSC:
+		return "NTSC"
+	case regionPAL:
+		return "PAL"
+	case regionMulti:
+		return "multi-region"
+	case regionDendy:
+		return "Dendy"
+	default:
+		return "unknown"
+	}
+}
+
 // errINesFileInvalid is an error related to a given iNES file being invalid.
 // This means that the file has an invalid header, e.g. the file is < 16 bytes long or
 // is >= 16 bytes long but the first 16 bytes have malformed contents.
@@ -29,73 +76,535 @@ func (err errINesFileInvalid) Error() string {
 	return fmt.Sprintf("iNES file invalid: %v", string(err))
 }
 
+// errUnifFileInvalid is an error related to a given UNIF file being invalid.
+// See https://wiki.nesdev.com/w/index.php/UNIF.
+type errUnifFileInvalid string
+
+// Error implements error.
+func (err errUnifFileInvalid) Error() string {
+	return fmt.Sprintf("UNIF file invalid: %v", string(err))
+}
+
+// errUnifBoardUnknown is an error related to a given UNIF file using a board which
+// does not map to any implemented mapper.
+type errUnifBoardUnknown string
+
+// Error implements error.
+func (err errUnifBoardUnknown) Error() string {
+	return fmt.Sprintf("UNIF board unknown: %v", string(err))
+}
+
+// unifBoards maps UNIF board names, with any prefix such as "NES-" or "UNL-" removed,
+// to iNES mapper and submapper numbers.  Only the licensed boards and the Bandai boards are
+// listed; most multicart and pirate boards are not, and are reported as errUnifBoardUnknown.
+// Boards listed here whose mapper is not implemented fail with errMapperUnsupported instead.
+// See https://wiki.nesdev.com/w/index.php/UNIF_to_NES_2.0_Mapping.
+var unifBoards = map[string]struct{ mapperNum, subMapperNum int }{
+	"NROM":          {0, 0},
+	"NROM-128":      {0, 0},
+	"NROM-256":      {0, 0},
+	"RROM":          {0, 0},
+	"RROM-128":      {0, 0},
+	"SAROM":         {1, 0},
+	"SBROM":         {1, 0},
+	"SCROM":         {1, 0},
+	"SEROM":         {1, 0},
+	"SGROM":         {1, 0},
+	"SKROM":         {1, 0},
+	"SLROM":         {1, 0},
+	"SL1ROM":        {1, 0},
+	"SNROM":         {1, 0},
+	"SOROM":         {1, 0},
+	"SUROM":         {1, 0},
+	"SXROM":         {1, 0},
+	"UNROM":         {2, 0},
+	"UOROM":         {2, 0},
+	"CNROM":         {3, 0},
+	"TBROM":         {4, 0},
+	"TEROM":         {4, 0},
+	"TFROM":         {4, 0},
+	"TGROM":         {4, 0},
+	"TKROM":         {4, 0},
+	"TLROM":         {4, 0},
+	"TL1ROM":        {4, 0},
+	"TSROM":         {4, 0},
+	"TVROM":         {4, 0},
+	"HKROM":         {4, 1},
+	"EKROM":         {5, 0},
+	"ELROM":         {5, 0},
+	"ETROM":         {5, 0},
+	"EWROM":         {5, 0},
+	"AMROM":         {7, 0},
+	"ANROM":         {7, 0},
+	"AN1ROM":        {7, 0},
+	"AOROM":         {7, 0},
+	"PNROM":         {9, 0},
+	"PEEOROM":       {9, 0},
+	"FJROM":         {10, 0},
+	"FKROM":         {10, 0},
+	"CPROM":         {13, 0},
+	"FCG-1":         {16, 4},
+	"FCG-2":         {16, 4},
+	"LZ93D50+24C01": {159, 0},
+	"LZ93D50+24C02": {16, 5},
+	"BNROM":         {34, 0},
+	"GNROM":         {66, 0},
+	"MHROM":         {66, 0},
+	"TLSROM":        {118, 0},
+	"TKSROM":        {118, 0},
+	"TQROM":         {119, 0},
+}
+
+// unifBoardPrefixes are manufacturer prefixes stripped from UNIF board names.
+var unifBoardPrefixes = []string{"NES-", "HVC-", "UNL-", "BTL-", "BMC-", "BANDAI-"}
+
 // cartridge represents a nes cartridge.
 type cartridge struct {
-	path string // the path at which the backing iNES file resides on disk
+	path    string   // the path at which the backing iNES file (or archive containing it) resides on disk
+	entry   string   // the name of the iNES file within the archive at path, if path is an archive
+	title   string   // the game title, if known by the game database
+	patches []string // paths of patches applied to the iNES file, in order
 
-	mapperNum   int // iNES mapper #
-	prgROMBanks int // Number of prgROM banks
-	chrROMBanks int // Number of chrROM (VROM) banks
-	ramBanks    int // Number of RAM banks
+	mapperNum    int    // iNES mapper #
+	subMapperNum int    // NES 2.0 submapper #
+	prgROMBanks  int    // Number of prgROM banks
+	chrROMBanks  int    // Number of chrROM (VROM) banks
+	ramBanks     int    // Number of RAM banks
+	prgRAMSize   int    // Size of volatile prgRAM in bytes (NES 2.0 or game database only)
+	prgNVRAMSize int    // Size of battery backed prgRAM in bytes (NES 2.0 or game database only)
+	chrRAMSize   int    // Size of volatile chrRAM in bytes (NES 2.0 or game database only)
+	chrNVRAMSize int    // Size of battery backed chrRAM in bytes (NES 2.0 or game database only)
+	region       region // The region this cartridge was made for
 
 	hasSRAM             bool // whether or not this cart supports SRAM
 	hasTrainer          bool // whether or not there is a 512kB trainer preceding rom
 	vertMirroring       bool // whether to use vertical mirroring (or horizontal mirroring)
 	fourScreenMirroring bool // whether or not to ignore above flag and use four screen mirroring
+	singleScreen        bool // whether or not to ignore above flags and use single screen mirroring (UNIF only)
+	singleScreenHigh    bool // whether single screen mirroring uses the second 1kB of RAM, or the first
+	nes20               bool // whether or not the header is in NES 2.0 format
+	exactRAMSizes       bool // whether or not ram sizes are known exactly (NES 2.0 or game database)
+
+	trainer []byte // 512 byte trainer, if present
+	prgROM  []byte // raw prgROM contents
+	chrROM  []byte // raw chrROM contents
+	prgRAM  []byte // prgRAM, mapped into $6000-$7FFF by most mappers
+	chrRAM  []byte // chrRAM, used in place of chrROM by boards without it
+	vRAM    []byte // extra nametable RAM for four screen mirroring, nil otherwise
+	disk    []byte // Famicom Disk System disk sides, in .fds image format
+	bios    []byte // Famicom Disk System BIOS
+
+	crc32 uint32          // CRC32 of prgROM followed by chrROM
+	sha1  [sha1.Size]byte // SHA-1 of prgROM followed by chrROM
 }
 
 // String implements Stringer.
 func (c *cartridge) String() string {
-	return fmt.Sprintf("[%v] mapper: %v, prg ROM banks: %v, chr ROM banks: %v, RAM banks: %v", c.path, c.mapperNum, c.prgROMBanks, c.chrROMBanks, c.ramBanks)
+	name := c.path
+	if c.entry != "" {
+		name = fmt.Sprintf("%v:%v", c.path, c.entry)
+	}
+
+	repr := fmt.Sprintf("[%v] mapper: %v, prg ROM banks: %v, chr ROM banks: %v, RAM banks: %v, region: %v, crc32: %08X", name, c.mapperNum, c.prgROMBanks, c.chrROMBanks, c.ramBanks, c.region, c.crc32)
+	if c.title != "" {
+		repr = fmt.Sprintf("%v, title: %v", repr, c.title)
+	}
+	if len(c.patches) > 0 {
+		names := make([]string, len(c.patches))
+		for i, patch := range c.patches {
+			names[i] = filepath.Base(patch)
+		}
+		repr = fmt.Sprintf("%v, patches: %v", repr, strings.Join(names, ", "))
+	}
+
+	return repr
 }
 
 // newCartridge creates a new catridge from the file specified at relative path path.
-// Only supports the iNES file type.  If the file type is detected to be iNES, parse out
+// Supports the iNES and UNIF file types.  If the file type is detected to be iNES, parse out
 // and store all relevant information.  If the file is found but does not satisfy the iNES format,
-// returns an error of type errINesFileInvalid.
-func newCartridge(path string) (*cartridge, error) {
-	decode := func(file []byte, c *cartridge) error {
-		if len(file) < iNesHeaderLen {
-			return newErrINesFileInvalid("file less than 16 bytes long")
+// returns an error of type errINesFileInvalid.  UNIF files are detected by their magic number,
+// and return errors of type errUnifFileInvalid or errUnifBoardUnknown.
+// Header fields are then corrected using the game database, if the rom is known to it.
+//
+// The ips, bps or ups patches at paths patches are applied in order before decoding.  If no
+// patches are given, a patch with the same name as the file next to it is applied if one exists.
+// The file on disk is never modified.
+func newCartridge(path string, patches ...string) (*cartridge, error) {
+	return newArchivedCartridge(path, "", patches...)
+}
+
+// newArchivedCartridge is like newCartridge, but path may also be a zip, gzip or 7z archive.
+// entry names the rom within the archive; if empty, the first .nes file is used, or failing that
+// the first file of another rom format.
+// See readROM.
+func newArchivedCartridge(path, entry string, patches ...string) (*cartridge, error) {
+	c := &cartridge{path: path}
+
+	bytes, entry, err := readROM(path, entry)
+	if err != nil {
+		return nil, err
+	}
+	c.entry = entry
+
+	if len(patches) == 0 {
+		patch, err := findPatch(path)
+		if err != nil {
+			return nil, err
 		}
+		if patch != "" {
+			patches = []string{patch}
+		}
+	}
 
-		if !bytes.Equal(file[:4], []byte{0x4E, 0x45, 0x53, 0x1A}) {
-			return newErrINesFileInvalid("invalid first 4 bytes, should be 'NES' followed by MS-DOS EOF")
+	for _, patch := range patches {
+		if bytes, err = applyPatchFile(bytes, patch); err != nil {
+			return nil, fmt.Errorf("%v: %w", filepath.Base(patch), err)
 		}
+		c.patches = append(c.patches, patch)
+	}
 
-		c.prgROMBanks = int(file[4])
-		c.chrROMBanks = int(file[5])
+	// Decode useful information from ROM header, and split out rom contents
+	decode := c.decodeINes
+	switch {
+	case len(bytes) >= len(unifMagic) && string(bytes[:len(unifMagic)]) == string(unifMagic):
+		decode = c.decodeUnif
+	case isFDSImage(bytes):
+		decode = c.decodeFDS
+	}
+	if err := decode(bytes); err != nil {
+		return nil, err
+	}
+
+	// Fix up bad headers with known-good values
+	c.hash()
+	if info, ok := lookupGame(c.sha1, c.crc32); ok {
+		info.apply(c)
+	}
 
-		control := file[6]
-		control2 := file[7]
+	c.allocateRAM()
 
-		c.vertMirroring = control&mask0 != 0
-		c.hasSRAM = control&mask1 != 0
-		c.hasTrainer = control&mask2 != 0
-		c.fourScreenMirroring = control&mask3 != 0
-		c.mapperNum = int(byte(control&0b11110000>>4) | byte(control2&0b11110000))
+	return c, nil
+}
+
+// decodeINes decodes an iNES (or NES 2.0) file into c.
+// See http://wiki.nesdev.com/w/index.php/INES and https://wiki.nesdev.com/w/index.php/NES_2.0.
+func (c *cartridge) decodeINes(file []byte) error {
+	if len(file) < iNesHeaderLen {
+		return newErrINesFileInvalid("file less than 16 bytes long")
+	}
 
-		// for compatibility with old iNES versions, when 0 is indicated
-		// in byte 8 we should consider it as 1 ram bank
+	if !bytes.Equal(file[:4], []byte{0x4E, 0x45, 0x53, 0x1A}) {
+		return newErrINesFileInvalid("invalid first 4 bytes, should be 'NES' followed by MS-DOS EOF")
+	}
+
+	c.prgROMBanks = int(file[4])
+	c.chrROMBanks = int(file[5])
+
+	control := file[6]
+	control2 := file[7]
+
+	c.vertMirroring = control&mask0 != 0
+	c.hasSRAM = control&mask1 != 0
+	c.hasTrainer = control&mask2 != 0
+	c.fourScreenMirroring = control&mask3 != 0
+	c.nes20 = control2&0b00001100 == 0b00001000
+	c.region = regionNTSC
+
+	switch {
+	case c.nes20:
+		c.decodeNes20(file)
+	case bytes.Equal(file[12:16], []byte{0, 0, 0, 0}):
+		c.mapperNum = int(control>>4 | control2&0b11110000)
+		if file[9]&mask0 != 0 {
+			c.region = regionPAL
+		}
+	default:
+		// Bytes 12-15 contain garbage (e.g. "DiskDude!"), meaning byte 7 was most likely
+		// clobbered as well.  Only trust the lower nibble of the mapper number.
+		c.mapperNum = int(control >> 4)
+	}
+
+	// for compatibility with old iNES versions, when 0 is indicated
+	// in byte 8 we should consider it as 1 ram bank
+	if !c.nes20 {
 		c.ramBanks = int(file[8])
 		if c.ramBanks == 0 {
 			c.ramBanks = 1
 		}
+	}
 
-		return nil
+	if c.prgROMBanks == 0 {
+		return newErrINesFileInvalid("no prg ROM banks")
 	}
 
-	c := &cartridge{path: path}
+	offset := iNesHeaderLen
+	if c.hasTrainer {
+		if len(file) < offset+iNesTrainerLen {
+			return newErrINesFileInvalid("file too short to contain trainer")
+		}
+		c.trainer = file[offset : offset+iNesTrainerLen]
+		offset += iNesTrainerLen
+	}
 
-	bytes, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	prgLen := c.prgROMBanks * prgROMBankLen
+	chrLen := c.chrROMBanks * chrROMBankLen
+	if len(file) < offset+prgLen+chrLen {
+		return newErrINesFileInvalid(fmt.Sprintf("file too short to contain %v bytes of prg ROM and %v bytes of chr ROM", prgLen, chrLen))
 	}
 
-	// Decode useful information from ROM header
-	if err := decode(bytes[:iNesHeaderLen], c); err != nil {
-		return nil, err
+	c.prgROM = file[offset : offset+prgLen]
+	c.chrROM = file[offset+prgLen : offset+prgLen+chrLen]
+
+	return nil
+}
+
+// decodeNes20 decodes the NES 2.0 specific fields of header into c.
+// See https://wiki.nesdev.com/w/index.php/NES_2.0.
+func (c *cartridge) decodeNes20(header []byte) {
+	// NES 2.0 ram sizes are stored as shift counts, where 0 means no ram
+	shiftSize := func(shift byte) int {
+		if shift == 0 {
+			return 0
+		}
+		return 64 << shift
 	}
 
-	return c, nil
+	c.mapperNum = int(header[6]>>4) | int(header[7]&0b11110000) | int(header[8]&0b00001111)<<8
+	c.subMapperNum = int(header[8] >> 4)
+	c.prgROMBanks |= int(header[9]&0b00001111) << 8
+	c.chrROMBanks |= int(header[9]&0b11110000) << 4
+	c.prgRAMSize = shiftSize(header[10] & 0b00001111)
+	c.prgNVRAMSize = shiftSize(header[10] >> 4)
+	c.chrRAMSize = shiftSize(header[11] & 0b00001111)
+	c.chrNVRAMSize = shiftSize(header[11] >> 4)
+	c.region = region(header[12] & mask01)
+	c.exactRAMSizes = true
+	c.ramBanks = (c.prgRAMSize + c.prgNVRAMSize + prgRAMBankLen - 1) / prgRAMBankLen
+}
+
+// decodeUnif decodes a UNIF file into c.  UNIF files consist of a header followed by chunks,
+// each of which has a 4 byte id, 4 byte little endian length and data.
+// See https://wiki.nesdev.com/w/index.php/UNIF.
+func (c *cartridge) decodeUnif(file []byte) error {
+	if len(file) < unifHeaderLen {
+		return errUnifFileInvalid("file less than 32 bytes long")
+	}
+
+	var prgChunks, chrChunks [16][]byte
+	board := ""
+	c.region = regionNTSC
+	c.ramBanks = 1
+
+	for pos := unifHeaderLen; pos < len(file); {
+		if pos+unifChunkHeaderLen > len(file) {
+			return errUnifFileInvalid("truncated chunk header")
+		}
+
+		id := string(file[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(file[pos+4:]))
+		pos += unifChunkHeaderLen
+		if length > len(file)-pos {
+			return errUnifFileInvalid(fmt.Sprintf("chunk %v longer than file", id))
+		}
+		data := file[pos : pos+length]
+		pos += length
+
+		switch {
+		case id == "MAPR":
+			board = nullTerminated(data)
+		case id == "NAME":
+			c.title = nullTerminated(data)
+		case id == "BATR":
+			c.hasSRAM = len(data) == 0 || data[0] != 0
+		case id == "MIRR" && len(data) > 0:
+			// 0: horizontal, 1: vertical, 2 and 3: single screen low and high, 4: four screen,
+			// others: mapper controlled
+			c.vertMirroring = data[0] == 1
+			c.singleScreen = data[0] == 2 || data[0] == 3
+			c.singleScreenHigh = data[0] == 3
+			c.fourScreenMirroring = data[0] == 4
+		case id == "TVCI" && len(data) > 0:
+			switch data[0] {
+			case 1:
+				c.region = regionPAL
+			case 2:
+				c.region = regionMulti
+			}
+		case strings.HasPrefix(id, "PRG"), strings.HasPrefix(id, "CHR"):
+			index, err := strconv.ParseUint(id[3:], 16, 4)
+			if err != nil {
+				continue
+			}
+			if id[:3] == "PRG" {
+				prgChunks[index] = data
+			} else {
+				chrChunks[index] = data
+			}
+		}
+	}
+
+	if board == "" {
+		return errUnifFileInvalid("missing MAPR chunk")
+	}
+
+	name := board
+	for _, prefix := range unifBoardPrefixes {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	mapping, ok := unifBoards[name]
+	if !ok {
+		return errUnifBoardUnknown(board)
+	}
+	c.mapperNum = mapping.mapperNum
+	c.subMapperNum = mapping.subMapperNum
+
+	c.prgROM = bytes.Join(prgChunks[:], nil)
+	c.chrROM = bytes.Join(chrChunks[:], nil)
+	if len(c.prgROM) == 0 {
+		return errUnifFileInvalid("missing PRG chunks")
+	}
+	c.prgROMBanks = (len(c.prgROM) + prgROMBankLen - 1) / prgROMBankLen
+	c.chrROMBanks = (len(c.chrROM) + chrROMBankLen - 1) / chrROMBankLen
+
+	return nil
+}
+
+// nullTerminated returns the string in data up to the first null byte.
+func nullTerminated(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+// hash computes the CRC32 and SHA-1 digests of the rom (or disk) contents of c.
+// The header and trainer are excluded so that the digests identify the game
+// regardless of how its header was written.
+func (c *cartridge) hash() {
+	crc := crc32.NewIEEE()
+	sha := sha1.New()
+	for _, rom := range [][]byte{c.prgROM, c.chrROM, c.disk} {
+		crc.Write(rom)
+		sha.Write(rom)
+	}
+
+	c.crc32 = crc.Sum32()
+	copy(c.sha1[:], sha.Sum(nil))
+}
+
+// allocateRAM allocates the prgRAM, chrRAM and extra nametable RAM of c.  When the header does
+// not specify exact sizes (i.e. plain iNES), the number of 8kB RAM banks from the header is used
+// for prgRAM, and 8kB of chrRAM is allocated if there is no chrROM.
+// If present, the trainer is loaded into prgRAM at $7000.
+func (c *cartridge) allocateRAM() {
+	size := c.ramBanks * prgRAMBankLen
+	if c.exactRAMSizes {
+		size = c.prgRAMSize + c.prgNVRAMSize
+	}
+	c.prgRAM = make([]byte, size)
+
+	if c.hasTrainer && size >= prgRAMBankLen {
+		copy(c.prgRAM[trainerStart-prgRAMStart:], c.trainer)
+	}
+
+	chrSize := c.chrRAMSize + c.chrNVRAMSize
+	if chrSize == 0 && len(c.chrROM) == 0 {
+		chrSize = chrRAMLen
+	}
+	c.chrRAM = make([]byte, chrSize)
+
+	if c.fourScreenMirroring {
+		c.vRAM = make([]byte, vRAMLen)
+	}
+}
+
+// headerMirroring returns the nametable mirroring given by the header (or game database).
+func (c *cartridge) headerMirroring() mirroring {
+	switch {
+	case c.fourScreenMirroring:
+		return mirrorFourScreen
+	case c.singleScreen && c.singleScreenHigh:
+		return mirrorSingleHigh
+	case c.singleScreen:
+		return mirrorSingleLow
+	case c.vertMirroring:
+		return mirrorVertical
+	default:
+		return mirrorHorizontal
+	}
+}
+
+// readChrMem reads data from chrROM, or chrRAM if there is no chrROM, at offset.
+// offset is mirrored across the size of chr memory.
+func (c *cartridge) readChrMem(offset int) (data byte) {
+	chr := c.chrROM
+	if len(chr) == 0 {
+		chr = c.chrRAM
+	}
+	if len(chr) == 0 {
+		return 0x00
+	}
+	return chr[offset%len(chr)]
+}
+
+// writeChrMem writes data to chrRAM at offset, mirrored across the size of chrRAM.
+// Writes are ignored if the cartridge has chrROM.
+func (c *cartridge) writeChrMem(offset int, data byte) {
+	if len(c.chrROM) != 0 || len(c.chrRAM) == 0 {
+		return
+	}
+	c.chrRAM[offset%len(c.chrRAM)] = data
+}
+
+// readPrgRAM reads data from prgRAM, mirrored across $6000-$7FFF.
+func (c *cartridge) readPrgRAM(address uint16) (data byte) {
+	if len(c.prgRAM) == 0 {
+		return 0x00
+	}
+	return c.prgRAM[int(address-prgRAMStart)%len(c.prgRAM)]
+}
+
+// writePrgRAM writes data to prgRAM, mirrored across $6000-$7FFF.
+func (c *cartridge) writePrgRAM(address uint16, data byte) {
+	if len(c.prgRAM) == 0 {
+		return
+	}
+	c.prgRAM[int(address-prgRAMStart)%len(c.prgRAM)] = data
+}
+
+// batteryRAM implements batteryBacked.
+// When exact ram sizes are known, the battery backed portion of prgRAM comes first.
+func (c *cartridge) batteryRAM() []byte {
+	if !c.hasSRAM {
+		return nil
+	}
+	if c.exactRAMSizes {
+		return c.prgRAM[:c.prgNVRAMSize]
+	}
+	return c.prgRAM
+}
+
+// saveState implements stateful, saving the RAM of the cartridge.  Mappers with registers of
+// their own save them after it.
+func (c *cartridge) saveState(w *stateWriter) {
+	w.bytes(c.prgRAM)
+	w.bytes(c.chrRAM)
+	w.bytes(c.vRAM)
+}
+
+// loadState implements stateful.
+func (c *cartridge) loadState(r *stateReader) {
+	r.bytes(c.prgRAM)
+	r.bytes(c.chrRAM)
+	r.bytes(c.vRAM)
+}
+
+// clone returns a copy of c with RAM of its own, sharing its read only memory.
+func (c *cartridge) clone() *cartridge {
+	clone := *c
+	clone.prgRAM = bytes.Clone(c.prgRAM)
+	clone.chrRAM = bytes.Clone(c.chrRAM)
+	clone.vRAM = bytes.Clone(c.vRAM)
+	return &clone
 }
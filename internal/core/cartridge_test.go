@@ -0,0 +1,201 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeROM writes an iNES file with the given header and zeroed rom contents to a
+// temporary directory, returning its path.
+func writeROM(t *testing.T, name string, header [iNesHeaderLen]byte) string {
+	t.Helper()
+
+	rom := append([]byte{}, header[:]...)
+	rom = append(rom, make([]byte, int(header[4])*prgROMBankLen+int(header[5])*chrROMBankLen)...)
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, rom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestCartridgeDiskDudeHeader(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 1, 0x11}
+	copy(header[7:], "DiskDude!")
+
+	cart, err := newCartridge(writeROM(t, "diskdude.nes", header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cart.mapperNum != 1 {
+		t.Errorf("mapper: want 1, got %v", cart.mapperNum)
+	}
+	if !cart.vertMirroring {
+		t.Error("want vertical mirroring")
+	}
+}
+
+func TestCartridgeNoPrgROM(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 0, 1}
+	if _, err := newCartridge(writeROM(t, "empty.nes", header)); !errors.As(err, new(errINesFileInvalid)) {
+		t.Errorf("no prg ROM: want errINesFileInvalid, got %v", err)
+	}
+}
+
+func TestCartridgeGameDatabase(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 1}
+	path := writeROM(t, "game.nes", header)
+
+	cart, err := newCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbPath := filepath.Join(t.TempDir(), "user.csv")
+	db := fmt.Sprintf("%08X,,4,1,four-screen,1,pal,,8192,,,Test Game\n", cart.crc32)
+	if err := os.WriteFile(dbPath, []byte(db), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		gameDBMu.Lock()
+		defer gameDBMu.Unlock()
+		userDB = nil
+	})
+	if err := useGameDatabase(dbPath); err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err = newCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cart.title != "Test Game" {
+		t.Errorf("title: want %q, got %q", "Test Game", cart.title)
+	}
+	if cart.mapperNum != 4 || cart.subMapperNum != 1 {
+		t.Errorf("mapper: want 4.1, got %v.%v", cart.mapperNum, cart.subMapperNum)
+	}
+	if !cart.fourScreenMirroring || !cart.hasSRAM || cart.region != regionPAL {
+		t.Errorf("header fields not overridden: %v", cart)
+	}
+	if cart.prgNVRAMSize != 8192 || cart.prgRAMSize != 0 {
+		t.Errorf("prg RAM: want 0 + 8192 battery backed, got %v + %v", cart.prgRAMSize, cart.prgNVRAMSize)
+	}
+}
+
+func TestBuiltinGameDatabase(t *testing.T) {
+	const smbCRC32 = 0x3337EC46
+	smbSHA1, _ := hex.DecodeString("EA343F4E445A9050D4B4FBAC2C77D0693B1D0922")
+
+	info, ok := lookupGame([sha1.Size]byte(smbSHA1), smbCRC32)
+	if !ok || info.title != "Super Mario Bros." || info.mapperNum != 0 {
+		t.Errorf("Super Mario Bros.: want mapper 0 from the builtin database, got %+v", info)
+	}
+
+	// The user supplied database takes precedence
+	dbPath := filepath.Join(t.TempDir(), "user.csv")
+	if err := os.WriteFile(dbPath, []byte("3337EC46,,,,,,,,,,,Renamed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		gameDBMu.Lock()
+		defer gameDBMu.Unlock()
+		userDB = nil
+	})
+	if err := useGameDatabase(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := lookupGame([sha1.Size]byte(smbSHA1), smbCRC32); info.title != "Renamed" {
+		t.Errorf("title: want the user supplied %q, got %q", "Renamed", info.title)
+	}
+}
+
+func TestCartridgeUnif(t *testing.T) {
+	chunk := func(id string, data []byte) []byte {
+		out := append([]byte(id), byte(len(data)), byte(len(data)>>8), byte(len(data)>>16), byte(len(data)>>24))
+		return append(out, data...)
+	}
+	unif := func(board string, mirr byte) []byte {
+		file := append([]byte("UNIF"), make([]byte, unifHeaderLen-4)...)
+		file = append(file, chunk("MAPR", []byte(board+"\x00"))...)
+		file = append(file, chunk("MIRR", []byte{mirr})...)
+		file = append(file, chunk("PRG0", make([]byte, prgROMBankLen))...)
+		file = append(file, chunk("CHR0", make([]byte, chrROMBankLen))...)
+		return file
+	}
+
+	dir := t.TempDir()
+	known := filepath.Join(dir, "known.unf")
+	unknown := filepath.Join(dir, "unknown.unf")
+	os.WriteFile(known, unif("NES-NROM-128", 1), 0o644)
+	os.WriteFile(unknown, unif("UNL-NOT-A-BOARD", 1), 0o644)
+
+	cart, err := newCartridge(known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.mapperNum != 0 || !cart.vertMirroring || cart.prgROMBanks != 1 || cart.chrROMBanks != 1 {
+		t.Errorf("UNIF decoded incorrectly: %v", cart)
+	}
+
+	if _, err := newCartridge(unknown); !errors.As(err, new(errUnifBoardUnknown)) {
+		t.Errorf("want errUnifBoardUnknown, got %v", err)
+	}
+
+	for mirr, want := range map[byte]mirroring{0: mirrorHorizontal, 2: mirrorSingleLow, 3: mirrorSingleHigh, 4: mirrorFourScreen} {
+		os.WriteFile(known, unif("NES-SLROM", mirr), 0o644)
+		cart, err := newCartridge(known)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cart.headerMirroring(); cart.mapperNum != 1 || got != want {
+			t.Errorf("MIRR %v on SLROM: want mapper 1 with %v mirroring, got mapper %v with %v", mirr, want, cart.mapperNum, got)
+		}
+	}
+}
+
+func TestCartridgeChrRAMAndFourScreenVRAM(t *testing.T) {
+	// NROM with no chrROM and four screen mirroring
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 0, 0x08}
+	cart, err := newCartridge(writeROM(t, "gauntlet.nes", header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cart.chrRAM) != chrRAMLen || len(cart.vRAM) != vRAMLen || len(cart.prgRAM) != prgRAMBankLen {
+		t.Fatalf("ram sizes: chr %v, vram %v, prg %v", len(cart.chrRAM), len(cart.vRAM), len(cart.prgRAM))
+	}
+
+	m, err := newMapper(cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPpu()
+	p.useCartridge(m, cart.headerMirroring(), cart.vRAM)
+
+	p.write(0x1234, 0xAB)
+	if got := p.read(0x1234); got != 0xAB {
+		t.Errorf("chrRAM: want $AB, got $%02X", got)
+	}
+
+	// Each of the four nametables is distinct, with the last two held by the cartridge
+	for table := uint16(0); table < 4; table++ {
+		p.write(nametableStart+table*nametableLen, byte(table+1))
+	}
+	for table := uint16(0); table < 4; table++ {
+		if got := p.read(nametableStart + nametableMirrors + table*nametableLen); got != byte(table+1) {
+			t.Errorf("nametable %v: want %v, got %v", table, table+1, got)
+		}
+	}
+	if cart.vRAM[nametableLen] != 4 {
+		t.Errorf("nametable 3 not held in cartridge vRAM")
+	}
+}
@@ -3,7 +3,8 @@ package core
 import (
 	"fmt"
 	"io"
-	"log"
+
+	"github.com/justinawrey/goretro/internal/log"
 )
 
 // key memory locations
@@ -50,7 +51,7 @@ func (c *cpu) GenerateInterrupt(interruptType int) {
 
 // handleInterrupt causes cpu c to handle the interrupt specified by c.interruptType.
 // Briefly, this consists of:
-// 1. Push the program counter and status register on to the stack.
+// 1. Push the program counter and status register on to the stack, with the break flag clear.
 // 2. Set the interrupt disable flag to prevent further interrupts.
 // 3. Load the address of the interrupt handling routine from the vector table into the program
 // counter.
@@ -58,9 +59,9 @@ func (c *cpu) GenerateInterrupt(interruptType int) {
 func (c *cpu) handleInterrupt() {
 	c.mustHandleInterrupt = false
 
-	// 1. Push PC and SP onto stack
+	// 1. Push PC and status onto stack
 	c.push16(c.pc)
-	c.pushStack(c.status.asByte())
+	c.pushStack(c.status.asByte()&^mask4 | mask5)
 
 	// 2. Set interrupt disable flag
 	c.status.i = true
@@ -152,6 +153,7 @@ type cpu struct {
 	branchSucceeded     bool                  // Whether or not the most recently executed branch instruction succeeded
 	mustHandleInterrupt bool                  // Whether or not the cpu must handle an interrupt on its next step
 	interruptType       int                   // The type of interrupt that must be handled (assuming cpu is interrupted)
+	fault               error                 // The errCPUJammed which jammed the cpu until reset, or nil if it is running
 
 	// For logging only
 	debug  bool      // Whether or not to output logs
@@ -189,6 +191,7 @@ func (c *cpu) Init() {
 	c.status.i = true
 	c.status.u = true
 	c.sp = 0xFD
+	c.fault = nil
 	// TODO: APU start-up state
 }
 
@@ -204,8 +207,22 @@ func (c *cpu) Clear() {
 // setPageCrossed sets the cpu to whether or not a page has been crossed
 // according to the current PC and the provided address.
 func (c *cpu) setPageCrossed(address uint16) {
-	// TODO: implement page cross logic
-	c.pageCrossed = false
+	c.pageCrossed = c.pc&0xFF00 != address&0xFF00
+}
+
+// read16Bugged reads a two byte word at from, as the 6502 does for indirect addresses:
+// the high byte is read from the start of the page of from if the word would cross it.
+func (c *cpu) read16Bugged(from uint16) (word uint16) {
+	lo := uint16(c.Read(from))
+	hi := uint16(c.Read(from&0xFF00 | uint16(byte(from)+1)))
+	return hi<<8 | lo
+}
+
+// indexed returns base indexed by index, recording whether or not doing so crossed a page.
+func (c *cpu) indexed(base uint16, index byte) (address uint16) {
+	address = base + uint16(index)
+	c.pageCrossed = base&0xFF00 != address&0xFF00
+	return address
 }
 
 // branchTo branches the cpu program counter to address.
@@ -223,12 +240,12 @@ func (c *cpu) pushStack(data byte) {
 	c.sp--
 }
 
-// push16 pushes a 16 byte word onto the stack, low byte and then high byte.
+// push16 pushes a 16 byte word onto the stack, high byte and then low byte.
 func (c *cpu) push16(word uint16) {
 	lo := byte(word & 0x00FF)
-	hi := byte(word & 0xFF00)
-	c.pushStack(lo)
+	hi := byte(word >> 8)
 	c.pushStack(hi)
+	c.pushStack(lo)
 }
 
 // pullStack pulls a byte of data from the stack.
@@ -238,10 +255,10 @@ func (c *cpu) pullStack() (data byte) {
 	return c.Read(stackStart + uint16(c.sp))
 }
 
-// pull16 pulls a 16 byte word from the stack, high byte and then low byte.
+// pull16 pulls a 16 byte word from the stack, low byte and then high byte.
 func (c *cpu) pull16() (word uint16) {
-	hi := uint16(c.pullStack())
 	lo := uint16(c.pullStack())
+	hi := uint16(c.pullStack())
 	return hi<<8 | lo
 }
 
@@ -254,6 +271,12 @@ func (c *cpu) pull16() (word uint16) {
 //  4. Performing the instruction. This is done after (3) because jump instructions may directly change the PC.
 //  5. Add cpu cycles based on instruction execution.
 func (c *cpu) step() {
+	// A jammed cpu does nothing until reset, while the rest of the nes keeps running
+	if c.fault != nil {
+		c.cycles++
+		return
+	}
+
 	// Reset instruction-wise flags
 	c.pageCrossed = false
 	c.branchSucceeded = false
@@ -270,8 +293,10 @@ func (c *cpu) step() {
 	// 2. Decode opcode
 	instr, err := c.decode(opcode)
 	if IsInvalidOpcodeErr(err) {
-		// TODO: If the opcode is invalid, shut down everything for now.
-		log.Fatalln(err)
+		// Unofficial opcodes are not supported, so jam as the KIL opcodes do
+		c.fault = errCPUJammed{pc: c.pc, opcode: opcode}
+		log.Log(c.fault)
+		c.cycles++
 		return
 	}
 	instructionAddress := c.getAddressWithMode(instr.addressingMode)
@@ -307,3 +332,45 @@ func (c *cpu) step() {
 		c.cycles += instr.pageCrossCycleCost
 	}
 }
+
+// saveState implements stateful.
+func (c *cpu) saveState(w *stateWriter) {
+	w.u16(c.pc)
+	w.u8(c.sp)
+	w.u8(c.a)
+	w.u8(c.x)
+	w.u8(c.y)
+	w.u8(c.status.asByte())
+	w.int(c.cycles)
+	w.bool(c.pageCrossed)
+	w.bool(c.branchSucceeded)
+	w.bool(c.mustHandleInterrupt)
+	w.int(c.interruptType)
+	jam, jammed := c.fault.(errCPUJammed)
+	w.bool(jammed)
+	w.u16(jam.pc)
+	w.u8(jam.opcode)
+}
+
+// loadState implements stateful.
+func (c *cpu) loadState(r *stateReader) {
+	c.pc = r.u16()
+	c.sp = r.u8()
+	c.a = r.u8()
+	c.x = r.u8()
+	c.y = r.u8()
+	c.status.fromByte(r.u8())
+	c.cycles = r.int()
+	c.pageCrossed = r.bool()
+	c.branchSucceeded = r.bool()
+	c.mustHandleInterrupt = r.bool()
+	c.interruptType = r.int()
+	c.fault = nil
+	if r.version >= 2 {
+		jammed := r.bool()
+		jam := errCPUJammed{pc: r.u16(), opcode: r.u8()}
+		if jammed {
+			c.fault = jam
+		}
+	}
+}
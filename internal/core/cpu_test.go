@@ -1,4 +1,145 @@
-package core_test
+package core
+
+import "testing"
+
+// newTestCpu returns a powered up cpu whose RAM holds program at $0200, where its pc points.
+func newTestCpu(program ...byte) *cpu {
+	c := newCpu()
+	c.UseMemory(newMemory())
+	c.Init()
+	copy(c.internal[0x0200:], program)
+	c.pc = 0x0200
+	return c
+}
+
+func TestCpuStackWords(t *testing.T) {
+	c := newTestCpu()
+
+	c.push16(0x1234)
+	if hi, lo := c.internal[0x01FD], c.internal[0x01FC]; hi != 0x12 || lo != 0x34 || c.sp != 0xFB {
+		t.Errorf("push16: want $12 at $01FD and $34 at $01FC, got $%02X and $%02X with sp $%02X", hi, lo, c.sp)
+	}
+	if word := c.pull16(); word != 0x1234 || c.sp != 0xFD {
+		t.Errorf("pull16: want $1234, got $%04X with sp $%02X", word, c.sp)
+	}
+}
+
+func TestCpuJSRAndRTS(t *testing.T) {
+	c := newTestCpu(
+		0x20, 0x10, 0x02, // JSR $0210
+	)
+	c.internal[0x0210] = 0x60 // RTS
+
+	c.step()
+	if c.pc != 0x0210 || c.cycles != 6 {
+		t.Errorf("JSR: want pc $0210 after 6 cycles, got $%04X after %v", c.pc, c.cycles)
+	}
+	if pushed := uint16(c.internal[0x01FD])<<8 | uint16(c.internal[0x01FC]); pushed != 0x0202 {
+		t.Errorf("JSR: want the address of its last byte, $0202, pushed, got $%04X", pushed)
+	}
+
+	c.step()
+	if c.pc != 0x0203 || c.sp != 0xFD {
+		t.Errorf("RTS: want pc $0203 and sp $FD, got $%04X and $%02X", c.pc, c.sp)
+	}
+}
+
+func TestCpuBranchOffset(t *testing.T) {
+	c := newTestCpu(
+		0xD0, 0x02, // BNE +2
+		0xEA, 0xEA, // NOP, NOP
+		0xD0, 0xFA, // BNE -6
+	)
+
+	c.step()
+	if c.pc != 0x0204 {
+		t.Errorf("forward branch: want pc $0204, got $%04X", c.pc)
+	}
+	c.step()
+	if c.pc != 0x0200 {
+		t.Errorf("backward branch: want pc $0200, got $%04X", c.pc)
+	}
+}
+
+func TestCpuBreakFlag(t *testing.T) {
+	c := newTestCpu(
+		0x08,       // PHP
+		0x00, 0xEA, // BRK, padding
+	)
+
+	c.step()
+	if pushed := c.internal[0x01FD]; pushed != 0x34 {
+		t.Errorf("PHP: want status $34 pushed with the break flag set, got $%02X", pushed)
+	}
+
+	c.step()
+	if pushed := uint16(c.internal[0x01FC])<<8 | uint16(c.internal[0x01FB]); pushed != 0x0203 {
+		t.Errorf("BRK: want the address past its padding byte, $0203, pushed, got $%04X", pushed)
+	}
+	if pushed := c.internal[0x01FA]; pushed != 0x34 {
+		t.Errorf("BRK: want status $34 pushed with the break flag set, got $%02X", pushed)
+	}
+
+	c.GenerateInterrupt(nmi)
+	c.status.i = false
+	c.handleInterrupt()
+	if pushed := c.internal[0x01F7]; pushed != 0x20 {
+		t.Errorf("nmi: want status $20 pushed with the break flag clear, got $%02X", pushed)
+	}
+	if c.status.b || !c.status.i {
+		t.Errorf("nmi: want the break flag clear and interrupts disabled, got %v", c.status)
+	}
+}
+
+func TestCpuPullStatus(t *testing.T) {
+	c := newTestCpu(
+		0x28, // PLP
+		0x40, // RTI
+	)
+	c.push16(0x0300)
+	c.pushStack(0x04) // I set
+	c.pushStack(0x10) // B set, I clear
+
+	c.step()
+	if c.status.b || !c.status.u || c.status.i {
+		t.Errorf("PLP: want the break flag ignored and interrupts enabled, got %v", c.status)
+	}
+
+	c.step()
+	if !c.status.i || c.pc != 0x0300 {
+		t.Errorf("RTI: want interrupts disabled as pulled and pc $0300, got %v and $%04X", c.status, c.pc)
+	}
+}
+
+func TestCpuIndirectJMPPageWrap(t *testing.T) {
+	c := newTestCpu(
+		0x6C, 0xFF, 0x02, // JMP ($02FF)
+	)
+	c.internal[0x02FF] = 0x34
+	c.internal[0x0300] = 0x56 // not read: the high byte is the JMP opcode at $0200 instead
+
+	c.step()
+	if c.pc != 0x6C34 {
+		t.Errorf("JMP ($02FF): want pc $6C34, got $%04X", c.pc)
+	}
+}
+
+func TestCpuPageCrossCycles(t *testing.T) {
+	for _, test := range []struct {
+		x      byte
+		cycles int
+	}{{0x00, 4}, {0x01, 5}} {
+		c := newTestCpu(
+			0xBD, 0xFF, 0x02, // LDA $02FF,X
+		)
+		c.x = test.x
+
+		c.step()
+		if c.cycles != test.cycles {
+			t.Errorf("LDA $02FF,X with X=%v: want %v cycles, got %v", test.x, test.cycles, c.cycles)
+		}
+	}
+}
 
 //
 // import (
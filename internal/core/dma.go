@@ -0,0 +1,32 @@
+package core
+
+// dma stall lengths, in cpu cycles
+// See https://wiki.nesdev.com/w/index.php/DMA.
+const (
+	oamDMACycles      = 513 // cycles an oam dma stalls the cpu, plus 1 if it starts on an odd cycle
+	dmcStallCycles    = 4   // cycles a dmc sample fetch stalls the cpu
+	dmcOAMStallCycles = 2   // cycles a dmc sample fetch adds to an oam dma it interrupts
+)
+
+// oamDMA copies the 256 bytes of cpu memory page into ppu SPR-RAM, starting at SPR-RAM address
+// $2003, stalling the cpu while it does so.  The dma waits a cycle to start, and another if it
+// would otherwise start on an odd cycle, before alternating reads and writes.  dmc sample
+// fetches during the dma steal fewer cycles than they otherwise would.
+// n.mu must be held.
+func (n *nes) oamDMA(page byte) {
+	n.apu.dmc.duringOAM = true
+	start := n.cpu.cycles
+	n.cpu.cycles += oamDMACycles - sprRAMSize*2
+	if start%2 == 1 {
+		n.cpu.cycles++
+	}
+	n.clock(n.cpu.cycles - start)
+
+	for i := range sprRAMSize {
+		data := n.mem.Read(uint16(page)<<8 | uint16(i))
+		n.ppu.writeRegister(sprRAMDataReg, data)
+		n.cpu.cycles += 2
+		n.clock(2)
+	}
+	n.apu.dmc.duringOAM = false
+}
@@ -0,0 +1,31 @@
+package core
+
+import "testing"
+
+func TestOAMDMA(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	for i := range sprRAMSize {
+		n.mem.write(0x0200+uint16(i), byte(i))
+	}
+	n.ppu.writeRegister(sprRAMAddrReg, 0x10)
+
+	for _, start := range []int{0, 1} {
+		n.cpu.cycles = start
+		n.mem.write(oamDMA, 0x02)
+		page, ok := n.mem.takeDMA()
+		if !ok || page != 0x02 {
+			t.Fatalf("$4014 write: want page $02, got $%02X (requested %v)", page, ok)
+		}
+		n.oamDMA(page)
+
+		want := oamDMACycles + start%2
+		if got := n.cpu.cycles - start; got != want {
+			t.Errorf("dma starting on cycle %v: want %v stall cycles, got %v", start, want, got)
+		}
+	}
+
+	// The copy starts at the SPR-RAM address, wrapping around
+	if n.ppu.sprRAM[0x10] != 0x00 || n.ppu.sprRAM[0x0F] != 0xFF {
+		t.Errorf("SPR-RAM: want $00 at $10 and $FF at $0F, got $%02X and $%02X", n.ppu.sprRAM[0x10], n.ppu.sprRAM[0x0F])
+	}
+}
@@ -0,0 +1,251 @@
+package core
+
+// eeprom sizes
+const (
+	eeprom24C01Size = 0x80
+	eeprom24C02Size = 0x100
+)
+
+// eepromMode is the state of the serial protocol of an eeprom.
+type eepromMode int
+
+// eeprom modes
+const (
+	eepromIdle    eepromMode = iota // waiting for a start condition
+	eepromDevice                    // receiving the device address byte (24C02 only)
+	eepromAddress                   // receiving the word address
+	eepromRead                      // sending a data byte
+	eepromWrite                     // receiving a data byte
+	eepromSendAck                   // acknowledging a received byte
+	eepromWaitAck                   // waiting for the master to acknowledge a sent byte
+)
+
+// eeprom is a 24C01 or 24C02 serial eeprom, as found on some Bandai FCG boards.
+// The mapper drives the clock (scl) and data (sda) lines through write, and reads
+// back the data line driven by the eeprom through output.
+// The 24C01 is addressed directly after a start condition and transfers bits least
+// significant first, whereas the 24C02 expects a device address byte first and
+// transfers bits most significant first.
+// See https://wiki.nesdev.com/w/index.php/Bandai_FCG_board#Serial_EEPROM.
+type eeprom struct {
+	data    []byte // eeprom contents
+	is24C01 bool   // whether or not this is a 24C01 (as opposed to a 24C02)
+
+	mode     eepromMode // current protocol state
+	nextMode eepromMode // state to enter after the current acknowledge
+	device   byte       // device address byte being received
+	address  byte       // current word address
+	buffer   byte       // data byte being sent or received
+	bit      int        // number of bits of the current byte transferred so far
+	out      bool       // level the eeprom is driving on sda
+	prevSCL  bool
+	prevSDA  bool
+}
+
+// newEeprom creates a new eeprom of size bytes (eeprom24C01Size or eeprom24C02Size).
+func newEeprom(size int) *eeprom {
+	return &eeprom{
+		data:    make([]byte, size),
+		is24C01: size == eeprom24C01Size,
+		out:     true,
+	}
+}
+
+// output returns the level the eeprom is driving on the data line.
+func (e *eeprom) output() bool {
+	return e.out
+}
+
+// shiftIn stores bit into dst, in the bit order of the eeprom.
+func (e *eeprom) shiftIn(dst *byte, bit bool) {
+	if e.bit >= 8 {
+		return
+	}
+
+	pos := 7 - e.bit
+	if e.is24C01 {
+		pos = e.bit
+	}
+
+	*dst &^= 1 << pos
+	if bit {
+		*dst |= 1 << pos
+	}
+	e.bit++
+}
+
+// shiftOut drives the next bit of buffer onto the data line, in the bit order of the eeprom.
+func (e *eeprom) shiftOut() {
+	if e.bit >= 8 {
+		return
+	}
+
+	pos := 7 - e.bit
+	if e.is24C01 {
+		pos = e.bit
+	}
+
+	e.out = e.buffer&(1<<pos) != 0
+	e.bit++
+}
+
+// mask wraps a word address to the size of the eeprom.
+func (e *eeprom) mask(address byte) byte {
+	return address & byte(len(e.data)-1)
+}
+
+// write updates the clock and data lines driven by the mapper.
+func (e *eeprom) write(scl, sda bool) {
+	switch {
+	case e.prevSCL && scl && e.prevSDA && !sda:
+		// start condition: sda falls while scl is high
+		e.mode = eepromDevice
+		if e.is24C01 {
+			e.mode = eepromAddress
+		}
+		e.bit = 0
+		e.out = true
+
+	case e.prevSCL && scl && !e.prevSDA && sda:
+		// stop condition: sda rises while scl is high
+		e.mode = eepromIdle
+		e.out = true
+
+	case !e.prevSCL && scl:
+		e.risingEdge(sda)
+
+	case e.prevSCL && !scl:
+		e.fallingEdge()
+	}
+
+	e.prevSCL = scl
+	e.prevSDA = sda
+}
+
+// risingEdge handles a rising edge of the clock line, on which data bits are sampled.
+func (e *eeprom) risingEdge(sda bool) {
+	switch e.mode {
+	case eepromDevice:
+		e.shiftIn(&e.device, sda)
+
+	case eepromAddress:
+		if !e.is24C01 {
+			e.shiftIn(&e.address, sda)
+			return
+		}
+
+		// The 24C01 receives a 7 bit address followed by the read/write bit
+		if e.bit < 7 {
+			e.shiftIn(&e.address, sda)
+			return
+		}
+		e.bit = 8
+		e.nextMode = eepromWrite
+		if sda {
+			e.nextMode = eepromRead
+			e.buffer = e.data[e.mask(e.address)]
+		}
+
+	case eepromRead:
+		e.shiftOut()
+
+	case eepromWrite:
+		e.shiftIn(&e.buffer, sda)
+
+	case eepromSendAck:
+		e.out = false
+
+	case eepromWaitAck:
+		// The master acknowledges (pulls sda low) to continue a sequential read
+		e.nextMode = eepromIdle
+		if !sda {
+			e.nextMode = eepromRead
+			e.buffer = e.data[e.mask(e.address)]
+		}
+	}
+}
+
+// fallingEdge handles a falling edge of the clock line, on which the eeprom changes state.
+func (e *eeprom) fallingEdge() {
+	switch e.mode {
+	case eepromDevice:
+		if e.bit < 8 {
+			return
+		}
+
+		// Only respond to the 1010xxx device address
+		if e.device&0xF0 != 0xA0 {
+			e.mode = eepromIdle
+			e.out = true
+			return
+		}
+
+		e.mode = eepromSendAck
+		e.nextMode = eepromAddress
+		if e.device&mask0 != 0 {
+			e.nextMode = eepromRead
+			e.buffer = e.data[e.mask(e.address)]
+		}
+
+	case eepromAddress:
+		if e.bit < 8 {
+			return
+		}
+
+		e.mode = eepromSendAck
+		if !e.is24C01 {
+			e.nextMode = eepromWrite
+		}
+
+	case eepromRead:
+		if e.bit < 8 {
+			return
+		}
+
+		e.mode = eepromWaitAck
+		e.address = e.mask(e.address + 1)
+
+	case eepromWrite:
+		if e.bit < 8 {
+			return
+		}
+
+		e.data[e.mask(e.address)] = e.buffer
+		e.address = e.mask(e.address + 1)
+		e.mode = eepromSendAck
+		e.nextMode = eepromWrite
+
+	case eepromSendAck, eepromWaitAck:
+		e.mode = e.nextMode
+		e.bit = 0
+		e.out = true
+	}
+}
+
+// saveState implements stateful.
+func (e *eeprom) saveState(w *stateWriter) {
+	w.bytes(e.data)
+	w.int(int(e.mode))
+	w.int(int(e.nextMode))
+	w.u8(e.device)
+	w.u8(e.address)
+	w.u8(e.buffer)
+	w.int(e.bit)
+	w.bool(e.out)
+	w.bool(e.prevSCL)
+	w.bool(e.prevSDA)
+}
+
+// loadState implements stateful.
+func (e *eeprom) loadState(r *stateReader) {
+	r.bytes(e.data)
+	e.mode = eepromMode(r.int())
+	e.nextMode = eepromMode(r.int())
+	e.device = r.u8()
+	e.address = r.u8()
+	e.buffer = r.u8()
+	e.bit = r.int()
+	e.out = r.bool()
+	e.prevSCL = r.bool()
+	e.prevSDA = r.bool()
+}
@@ -0,0 +1,656 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FDS image sizes
+const (
+	fdsHeaderLen     = 0x10
+	fdsSideLen       = 65500  // length of a disk side in an .fds image
+	fdsBIOSLen       = 0x2000 // length of disksys.rom
+	fdsPrgRAMLen     = 0x8000
+	fdsChrRAMLen     = 0x2000
+	fdsLeadingGapLen = 28300 / 8 // gap before the first block of a side
+	fdsBlockGapLen   = 976 / 8   // gap after every block
+)
+
+// FDS memory map
+const (
+	fdsBIOSStart = 0xE000
+	fdsIOStart   = 0x4020
+	fdsIOEnd     = 0x4033
+	fdsAudioEnd  = 0x4092
+)
+
+// FDS drive timing, in cpu cycles
+const (
+	fdsByteDelay   = 149     // time for one byte to pass under the head (~96.4 kHz bit rate)
+	fdsRewindDelay = 50000   // time for the head to return to the start of the disk
+	fdsSwapDelay   = 1789773 // time the drive reads as empty while flipping the disk (about a second)
+)
+
+// fdsMapperNum is the mapper number conventionally used for the FDS RAM adapter.
+const fdsMapperNum = 20
+
+// fdsNoDisk is the disk side value meaning no disk is inserted.
+const fdsNoDisk = -1
+
+// FDS magic numbers
+var (
+	fdsMagic     = []byte("FDS\x1A")
+	fdsDiskMagic = []byte("\x01*NINTENDO-HVC*")
+)
+
+// fdsBIOSName is the name of the FDS BIOS file, searched for next to .fds images.
+const fdsBIOSName = "disksys.rom"
+
+// errFDSBiosMissing is an error related to the FDS BIOS (disksys.rom) not being found.
+// The BIOS is copyrighted and must be provided by the user.
+type errFDSBiosMissing string
+
+// Error implements error.
+func (err errFDSBiosMissing) Error() string {
+	return fmt.Sprintf("FDS BIOS missing: %v", string(err))
+}
+
+// errFDS is an error related to operating the FDS disk drive.
+type errFDS string
+
+// Error implements error.
+func (err errFDS) Error() string {
+	return fmt.Sprintf("FDS: %v", string(err))
+}
+
+// errNotFDS is returned by disk operations when the loaded cartridge is not an FDS disk.
+const errNotFDS = errFDS("no disk loaded")
+
+// isFDSImage returns whether or not file is an .fds image, with or without the fwNES header.
+func isFDSImage(file []byte) bool {
+	return bytes.HasPrefix(file, fdsMagic) || bytes.HasPrefix(file, fdsDiskMagic)
+}
+
+// decodeFDS decodes an .fds image into c.  The disk sides are stored in c.disk.
+// See https://wiki.nesdev.com/w/index.php/FDS_file_format.
+func (c *cartridge) decodeFDS(file []byte) error {
+	if bytes.HasPrefix(file, fdsMagic) {
+		if len(file) < fdsHeaderLen {
+			return errINesFileInvalid("FDS header less than 16 bytes long")
+		}
+		file = file[fdsHeaderLen:]
+	}
+
+	sides := len(file) / fdsSideLen
+	if sides == 0 {
+		return errINesFileInvalid("FDS image shorter than one disk side")
+	}
+
+	c.mapperNum = fdsMapperNum
+	c.region = regionNTSC
+	c.disk = file[:sides*fdsSideLen]
+	return nil
+}
+
+// loadFDSBIOS reads the FDS BIOS from biosPath, or if empty, from disksys.rom next to romPath.
+// Returns an error of type errFDSBiosMissing if there is no BIOS.
+func loadFDSBIOS(biosPath, romPath string) ([]byte, error) {
+	if biosPath == "" {
+		biosPath = filepath.Join(filepath.Dir(romPath), fdsBIOSName)
+	}
+
+	bios, err := os.ReadFile(biosPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errFDSBiosMissing(biosPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Some dumps are wrapped in an iNES header; the BIOS is always the last 8kB
+	if len(bios) < fdsBIOSLen {
+		return nil, errFDSBiosMissing(fmt.Sprintf("%v is not an %v byte BIOS", biosPath, fdsBIOSLen))
+	}
+	return bios[len(bios)-fdsBIOSLen:], nil
+}
+
+// fdsRawSide expands a disk side from an .fds image into the bitstream the drive sees,
+// adding the gaps, gap end markers and crcs which .fds images omit.
+// The result is padded so that games have room to write new files.
+func fdsRawSide(side []byte) []byte {
+	raw := make([]byte, fdsLeadingGapLen, fdsLeadingGapLen+fdsSideLen)
+
+	for pos := 0; pos < len(side); {
+		var blockLen int
+		switch side[pos] {
+		case 1:
+			blockLen = 56 // disk info
+		case 2:
+			blockLen = 2 // file amount
+		case 3:
+			blockLen = 16 // file header
+		case 4:
+			// file data, whose size is in the preceding file header
+			if pos < 3 {
+				return fdsPad(raw)
+			}
+			blockLen = 1 + (int(side[pos-3]) | int(side[pos-2])<<8)
+		default:
+			return fdsPad(raw)
+		}
+
+		if pos+blockLen > len(side) {
+			return fdsPad(raw)
+		}
+
+		raw = append(raw, 0x80)
+		raw = append(raw, side[pos:pos+blockLen]...)
+		crc := fdsCRC(side[pos : pos+blockLen])
+		raw = append(raw, byte(crc), byte(crc>>8))
+		raw = append(raw, make([]byte, fdsBlockGapLen)...)
+		pos += blockLen
+	}
+
+	return fdsPad(raw)
+}
+
+// fdsPad pads a raw disk side to at least the length of an .fds side plus its leading gap.
+func fdsPad(raw []byte) []byte {
+	if len(raw) < fdsLeadingGapLen+fdsSideLen {
+		raw = append(raw, make([]byte, fdsLeadingGapLen+fdsSideLen-len(raw))...)
+	}
+	return raw
+}
+
+// fdsUpdateCRC updates the FDS block crc (CRC-16/KERMIT, as computed by the RAM adapter) with data.
+func fdsUpdateCRC(crc uint16, data byte) uint16 {
+	for n := 0; n < 8; n++ {
+		carry := crc&1 != 0
+		crc >>= 1
+		if carry {
+			crc ^= 0x8408
+		}
+		if data&(1<<n) != 0 {
+			crc ^= 0x8000
+		}
+	}
+	return crc
+}
+
+// fdsCRC computes the crc which follows block on disk.
+func fdsCRC(block []byte) uint16 {
+	crc := fdsUpdateCRC(0, 0x80)
+	for _, data := range block {
+		crc = fdsUpdateCRC(crc, data)
+	}
+	return fdsUpdateCRC(fdsUpdateCRC(crc, 0), 0)
+}
+
+// fds - the Famicom Disk System RAM adapter, conventionally iNES mapper #20.
+// It provides 32kB of prgRAM at $6000-$DFFF, the BIOS at $E000-$FFFF, 8kB of chrRAM,
+// a cpu cycle timer irq, the disk drive interface and a wavetable sound channel.
+// See https://wiki.nesdev.com/w/index.php/Family_Computer_Disk_System.
+type fds struct {
+	*cartridge
+	*fdsAudio
+
+	bios   []byte
+	prgRAM [fdsPrgRAMLen]byte
+	chrRAM [fdsChrRAMLen]byte
+
+	// disk contents, as seen by the drive
+	raw      []byte // raw disk sides, concatenated
+	pristine []byte // raw disk sides as loaded, before any writes
+	sides    []int  // offset of each side within raw, followed by len(raw)
+
+	// $4023
+	diskIOEnabled  bool
+	soundIOEnabled bool
+
+	// timer irq ($4020-$4022)
+	timerReload  uint16
+	timerCounter uint16
+	timerRepeat  bool
+	timerEnabled bool
+	timerIRQ     bool
+
+	// $4025
+	motorOn           bool
+	resetTransfer     bool
+	readMode          bool
+	horizontalMirror  bool
+	crcControl        bool
+	diskReady         bool
+	transferIRQEnable bool
+
+	// drive state
+	side             int  // inserted side, or fdsNoDisk
+	pendingSide      int  // side to insert once swapDelay elapses, or fdsNoDisk
+	swapDelay        int  // cpu cycles until pendingSide is inserted
+	position         int  // head position within the inserted side
+	delay            int  // cpu cycles until the next byte passes under the head
+	endOfHead        bool // whether or not the head has reached the end of the disk
+	scanning         bool // whether or not the head is moving across the disk
+	gapEnded         bool // whether or not the gap before the current block has been read
+	prevCRCControl   bool
+	crc              uint16
+	readData         byte
+	writeData        byte
+	transferComplete bool
+	diskIRQ          bool
+}
+
+// newFDS creates an FDS RAM adapter for cartridge c, with side 0 of the disk inserted.
+func newFDS(c *cartridge) *fds {
+	f := &fds{
+		cartridge:   c,
+		fdsAudio:    newFdsAudio(),
+		bios:        c.bios,
+		side:        0,
+		pendingSide: fdsNoDisk,
+		endOfHead:   true,
+	}
+
+	for pos := 0; pos < len(c.disk); pos += fdsSideLen {
+		f.sides = append(f.sides, len(f.raw))
+		f.raw = append(f.raw, fdsRawSide(c.disk[pos:pos+fdsSideLen])...)
+	}
+	f.sides = append(f.sides, len(f.raw))
+	f.pristine = bytes.Clone(f.raw)
+
+	return f
+}
+
+// readRegister implements memoryMappedIO.
+func (f *fds) readRegister(address uint16) (data byte) {
+	switch {
+	case address >= fdsBIOSStart:
+		return f.bios[address-fdsBIOSStart]
+	case address >= prgRAMStart:
+		return f.prgRAM[address-prgRAMStart]
+	case address >= 0x4040 && address <= fdsAudioEnd:
+		if !f.soundIOEnabled {
+			return 0x00
+		}
+		return f.fdsAudio.readRegister(address)
+	case address > fdsIOEnd || !f.diskIOEnabled:
+		return 0x00
+	}
+
+	switch address {
+	case 0x4030:
+		// Reading the disk status acknowledges both irqs
+		if f.timerIRQ {
+			data |= mask0
+		}
+		if f.transferComplete {
+			data |= mask1
+		}
+		if f.horizontalMirror {
+			data |= mask3
+		}
+		if f.endOfHead {
+			data |= mask6
+		}
+		f.timerIRQ = false
+		f.diskIRQ = false
+		f.transferComplete = false
+		return data
+	case 0x4031:
+		f.transferComplete = false
+		f.diskIRQ = false
+		return f.readData
+	case 0x4032:
+		if f.side == fdsNoDisk {
+			data |= mask0 | mask2
+		}
+		if f.side == fdsNoDisk || !f.scanning {
+			data |= mask1
+		}
+		return data
+	case 0x4033:
+		// Battery is good
+		return mask7
+	default:
+		return 0x00
+	}
+}
+
+// writeRegister implements memoryMappedIO.
+func (f *fds) writeRegister(address uint16, data byte) {
+	switch {
+	case address >= fdsBIOSStart:
+		return
+	case address >= prgRAMStart:
+		f.prgRAM[address-prgRAMStart] = data
+		return
+	case address >= 0x4040 && address <= fdsAudioEnd:
+		if f.soundIOEnabled {
+			f.fdsAudio.writeRegister(address, data)
+		}
+		return
+	case address == 0x4023:
+		f.diskIOEnabled = data&mask0 != 0
+		f.soundIOEnabled = data&mask1 != 0
+		if !f.diskIOEnabled {
+			f.timerEnabled = false
+			f.timerIRQ = false
+			f.diskIRQ = false
+		}
+		return
+	case address > fdsIOEnd || !f.diskIOEnabled:
+		return
+	}
+
+	switch address {
+	case 0x4020:
+		f.timerReload = f.timerReload&0xFF00 | uint16(data)
+	case 0x4021:
+		f.timerReload = f.timerReload&0x00FF | uint16(data)<<8
+	case 0x4022:
+		f.timerRepeat = data&mask0 != 0
+		f.timerEnabled = data&mask1 != 0
+		if f.timerEnabled {
+			f.timerCounter = f.timerReload
+		} else {
+			f.timerIRQ = false
+		}
+	case 0x4024:
+		f.writeData = data
+		f.transferComplete = false
+		f.diskIRQ = false
+	case 0x4025:
+		f.motorOn = data&mask0 != 0
+		f.resetTransfer = data&mask1 != 0
+		f.readMode = data&mask2 != 0
+		f.horizontalMirror = data&mask3 != 0
+		f.crcControl = data&mask4 != 0
+		f.diskReady = data&mask6 != 0
+		f.transferIRQEnable = data&mask7 != 0
+		f.diskIRQ = false
+	}
+}
+
+// nametableMirroring implements mirrorController.
+func (f *fds) nametableMirroring() mirroring {
+	if f.horizontalMirror {
+		return mirrorHorizontal
+	}
+	return mirrorVertical
+}
+
+// readChr implements mapper.
+func (f *fds) readChr(address uint16) (data byte) {
+	return f.chrRAM[address%fdsChrRAMLen]
+}
+
+// writeChr implements mapper.
+func (f *fds) writeChr(address uint16, data byte) {
+	f.chrRAM[address%fdsChrRAMLen] = data
+}
+
+// clock clocks the timer, disk drive and sound channel once per cpu cycle.
+func (f *fds) clock() {
+	f.clockTimer()
+	f.clockDrive()
+	f.fdsAudio.clock()
+}
+
+// irqPending returns whether or not the timer or disk transfer irq is asserted.
+func (f *fds) irqPending() bool {
+	return f.timerIRQ || f.diskIRQ
+}
+
+// clockTimer clocks the cpu cycle timer.
+func (f *fds) clockTimer() {
+	if !f.timerEnabled || !f.diskIOEnabled {
+		return
+	}
+
+	if f.timerCounter > 0 {
+		f.timerCounter--
+		return
+	}
+
+	f.timerIRQ = true
+	f.timerCounter = f.timerReload
+	if !f.timerRepeat {
+		f.timerEnabled = false
+	}
+}
+
+// clockDrive clocks the disk drive, transferring a byte between the disk and the data
+// registers every time one passes under the head.
+// See https://wiki.nesdev.com/w/index.php/FDS_disk_drive.
+func (f *fds) clockDrive() {
+	if f.pendingSide != fdsNoDisk {
+		if f.swapDelay--; f.swapDelay <= 0 {
+			f.side = f.pendingSide
+			f.pendingSide = fdsNoDisk
+		}
+	}
+
+	if f.side == fdsNoDisk || !f.motorOn {
+		f.endOfHead = true
+		f.scanning = false
+		return
+	}
+
+	if f.resetTransfer && !f.scanning {
+		return
+	}
+
+	if f.endOfHead {
+		f.delay = fdsRewindDelay
+		f.endOfHead = false
+		f.position = 0
+		f.gapEnded = false
+		return
+	}
+
+	if f.delay > 0 {
+		f.delay--
+		return
+	}
+
+	f.scanning = true
+	offset := f.sides[f.side] + f.position
+
+	if f.readMode {
+		f.readByte(f.raw[offset])
+	} else {
+		f.raw[offset] = f.writeByte()
+		f.gapEnded = false
+	}
+	f.prevCRCControl = f.crcControl
+
+	f.position++
+	if offset+1 >= f.sides[f.side+1] {
+		f.endOfHead = true
+		return
+	}
+	f.delay = fdsByteDelay
+}
+
+// readByte handles data passing under the head in read mode.
+func (f *fds) readByte(data byte) {
+	if !f.prevCRCControl {
+		f.crc = fdsUpdateCRC(f.crc, data)
+	}
+
+	// Data is only transferred once the gap end marker of a block has been seen
+	raiseIRQ := f.transferIRQEnable
+	if !f.diskReady {
+		f.gapEnded = false
+		f.crc = 0
+	} else if data != 0 && !f.gapEnded {
+		f.gapEnded = true
+		raiseIRQ = false
+	}
+
+	if f.gapEnded {
+		f.transferComplete = true
+		f.readData = data
+		if raiseIRQ {
+			f.diskIRQ = true
+		}
+	}
+}
+
+// writeByte handles data passing under the head in write mode, returning the byte written.
+func (f *fds) writeByte() (data byte) {
+	if !f.crcControl {
+		f.transferComplete = true
+		data = f.writeData
+		if f.transferIRQEnable {
+			f.diskIRQ = true
+		}
+	}
+
+	if !f.diskReady {
+		data = 0x00
+	}
+
+	if !f.crcControl {
+		f.crc = fdsUpdateCRC(f.crc, data)
+		return data
+	}
+
+	// Once crc control is set, the crc is written out one byte at a time
+	if !f.prevCRCControl {
+		f.crc = fdsUpdateCRC(fdsUpdateCRC(f.crc, 0), 0)
+	}
+	data = byte(f.crc)
+	f.crc >>= 8
+	return data
+}
+
+// diskSides returns the number of disk sides.
+func (f *fds) diskSides() int {
+	return len(f.sides) - 1
+}
+
+// insertDisk ejects the current disk side and inserts side.  The drive reads as empty for a
+// short time first, so that games notice the disk being flipped.
+func (f *fds) insertDisk(side int) error {
+	if side < 0 || side >= f.diskSides() {
+		return errFDS(fmt.Sprintf("disk side %v out of range [0, %v)", side, f.diskSides()))
+	}
+
+	f.side = fdsNoDisk
+	f.pendingSide = side
+	f.swapDelay = fdsSwapDelay
+	return nil
+}
+
+// ejectDisk ejects the current disk side.
+func (f *fds) ejectDisk() {
+	f.side = fdsNoDisk
+	f.pendingSide = fdsNoDisk
+}
+
+// batteryRAM implements batteryBacked.
+// The disk itself is persisted, as a diff against the disk as loaded.
+func (f *fds) batteryRAM() []byte {
+	return f.raw
+}
+
+// original implements diffBacked.
+func (f *fds) original() []byte {
+	return f.pristine
+}
+
+// saveState implements stateful.  The disk is saved as written to, so that loading a state
+// also restores the save data of the game as of when the state was saved.
+func (f *fds) saveState(w *stateWriter) {
+	w.bytes(f.prgRAM[:])
+	w.bytes(f.chrRAM[:])
+	w.bytes(f.raw)
+	f.fdsAudio.saveState(w)
+
+	w.bool(f.diskIOEnabled)
+	w.bool(f.soundIOEnabled)
+
+	w.u16(f.timerReload)
+	w.u16(f.timerCounter)
+	w.bool(f.timerRepeat)
+	w.bool(f.timerEnabled)
+	w.bool(f.timerIRQ)
+
+	w.bool(f.motorOn)
+	w.bool(f.resetTransfer)
+	w.bool(f.readMode)
+	w.bool(f.horizontalMirror)
+	w.bool(f.crcControl)
+	w.bool(f.diskReady)
+	w.bool(f.transferIRQEnable)
+
+	w.int(f.side)
+	w.int(f.pendingSide)
+	w.int(f.swapDelay)
+	w.int(f.position)
+	w.int(f.delay)
+	w.bool(f.endOfHead)
+	w.bool(f.scanning)
+	w.bool(f.gapEnded)
+	w.bool(f.prevCRCControl)
+	w.u16(f.crc)
+	w.u8(f.readData)
+	w.u8(f.writeData)
+	w.bool(f.transferComplete)
+	w.bool(f.diskIRQ)
+}
+
+// loadState implements stateful.
+func (f *fds) loadState(r *stateReader) {
+	r.bytes(f.prgRAM[:])
+	r.bytes(f.chrRAM[:])
+	r.bytes(f.raw)
+	f.fdsAudio.loadState(r)
+
+	f.diskIOEnabled = r.bool()
+	f.soundIOEnabled = r.bool()
+
+	f.timerReload = r.u16()
+	f.timerCounter = r.u16()
+	f.timerRepeat = r.bool()
+	f.timerEnabled = r.bool()
+	f.timerIRQ = r.bool()
+
+	f.motorOn = r.bool()
+	f.resetTransfer = r.bool()
+	f.readMode = r.bool()
+	f.horizontalMirror = r.bool()
+	f.crcControl = r.bool()
+	f.diskReady = r.bool()
+	f.transferIRQEnable = r.bool()
+
+	f.side = r.int()
+	f.pendingSide = r.int()
+	f.swapDelay = r.int()
+	f.position = r.int()
+	f.delay = r.int()
+	f.endOfHead = r.bool()
+	f.scanning = r.bool()
+	f.gapEnded = r.bool()
+	f.prevCRCControl = r.bool()
+	f.crc = r.u16()
+	f.readData = r.u8()
+	f.writeData = r.u8()
+	f.transferComplete = r.bool()
+	f.diskIRQ = r.bool()
+
+	for _, side := range []int{f.side, f.pendingSide} {
+		if side < fdsNoDisk || side >= f.diskSides() {
+			r.fail(fmt.Sprintf("disk side %v out of range", side))
+			return
+		}
+	}
+	if f.side != fdsNoDisk && (f.position < 0 || f.position > f.sides[f.side+1]-f.sides[f.side]) {
+		r.fail(fmt.Sprintf("disk position %v out of range", f.position))
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFDSDiskSaveRoundTrip(t *testing.T) {
+	// One side holding a disk info block, a file amount block, and one 4 byte file
+	side := make([]byte, fdsSideLen)
+	copy(side, fdsDiskMagic)
+	pos := 56
+	side[pos], side[pos+1] = 2, 1
+	pos += 2
+	header := []byte{3, 0, 0, 'F', 'I', 'L', 'E', ' ', ' ', ' ', ' ', 0, 0, 4, 0, 0}
+	copy(side[pos:], header)
+	pos += len(header)
+	copy(side[pos:], []byte{4, 0xDE, 0xAD, 0xBE, 0xEF})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "disk.fds")
+	image := append(append([]byte{}, fdsMagic...), make([]byte, fdsHeaderLen-len(fdsMagic))...)
+	image[4] = 1
+	if err := os.WriteFile(path, append(image, side...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.mapperNum != fdsMapperNum {
+		t.Fatalf("mapper: want %v, got %v", fdsMapperNum, c.mapperNum)
+	}
+
+	f := newFDS(c)
+	if f.diskSides() != 1 {
+		t.Fatalf("disk sides: want 1, got %v", f.diskSides())
+	}
+
+	// Every block is preceded by a gap end marker and followed by its crc
+	data := []byte{0x80, 4, 0xDE, 0xAD, 0xBE, 0xEF}
+	crc := fdsCRC(data[1:])
+	data = append(data, byte(crc), byte(crc>>8))
+	at := bytes.Index(f.raw, data)
+	if at < 0 {
+		t.Fatal("file data block not found in raw disk")
+	}
+
+	save, err := newSaveFile(savePath(path, ""), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.raw[at+2] = 0x42
+	if err := save.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newFDS(c)
+	if _, err := newSaveFile(savePath(path, ""), reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reloaded.raw, f.raw) {
+		t.Error("disk contents not restored from save file")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "disk.sav"+ipsSaveExt)); err != nil {
+		t.Error(err)
+	}
+}
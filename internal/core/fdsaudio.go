@@ -0,0 +1,300 @@
+package core
+
+// FDS audio sizes
+const (
+	fdsWaveLen     = 0x40
+	fdsModTableLen = 0x40
+)
+
+// fdsModTableSteps are the changes to the modulation counter for each mod table entry.
+// An entry of 4 (represented here by fdsModReset) resets the counter to 0.
+var fdsModTableSteps = [8]int{0, 1, 2, 4, fdsModReset, -4, -2, -1}
+
+// fdsModReset marks the mod table entry which resets the modulation counter.
+const fdsModReset = 0x80
+
+// fdsMasterVolumes are the output multipliers selected by $4089, as fractions of 30.
+var fdsMasterVolumes = [4]int{30, 20, 15, 12}
+
+// fdsEnvelope is a volume or modulation envelope of the FDS audio channel.
+type fdsEnvelope struct {
+	disabled bool // whether or not gain is set directly rather than by the envelope
+	increase bool // whether the envelope ramps gain up or down
+	speed    byte // envelope speed (6 bits)
+	gain     byte // current gain (6 bits, may exceed 32 when set directly)
+	timer    int  // cpu cycles until the next envelope clock
+}
+
+// write writes to the envelope control register ($4080 or $4084).
+func (e *fdsEnvelope) write(data byte) {
+	e.disabled = data&mask7 != 0
+	e.increase = data&mask6 != 0
+	e.speed = data & 0x3F
+	if e.disabled {
+		e.gain = e.speed
+	}
+}
+
+// clock clocks the envelope once per cpu cycle.  masterSpeed is the value of $408A.
+func (e *fdsEnvelope) clock(masterSpeed byte) {
+	if e.disabled || masterSpeed == 0 {
+		return
+	}
+
+	if e.timer > 0 {
+		e.timer--
+		return
+	}
+	e.timer = 8 * (int(e.speed) + 1) * int(masterSpeed)
+
+	switch {
+	case e.increase && e.gain < 32:
+		e.gain++
+	case !e.increase && e.gain > 0:
+		e.gain--
+	}
+}
+
+// fdsAudio is the wavetable sound channel of the Famicom Disk System RAM adapter,
+// mapped to $4040-$4092.
+// See https://wiki.nesdev.com/w/index.php/FDS_audio.
+type fdsAudio struct {
+	wave      [fdsWaveLen]byte     // 6 bit wavetable samples
+	modTable  [fdsModTableLen]byte // 3 bit modulation table entries
+	volume    fdsEnvelope          // $4080
+	mod       fdsEnvelope          // $4084
+	waveFreq  uint16               // 12 bit wave frequency
+	modFreq   uint16               // 12 bit modulation frequency
+	waveAccum uint32               // wave phase accumulator
+	modAccum  uint32               // modulation phase accumulator
+	modPos    int                  // position within modTable
+	modCount  int                  // 7 bit signed modulation counter
+
+	waveHalted     bool // $4083 bit 7
+	envelopeHalted bool // $4083 bit 6
+	modHalted      bool // $4087 bit 7
+	waveWrite      bool // $4089 bit 7
+	masterVolume   byte // $4089 bits 0-1
+	envelopeSpeed  byte // $408A
+
+	out byte // last output wave sample, held while the wave is halted
+}
+
+// newFdsAudio creates a new FDS audio channel in its power up state.
+func newFdsAudio() *fdsAudio {
+	return &fdsAudio{envelopeSpeed: 0xE8}
+}
+
+// readRegister implements memoryMappedIO.
+func (f *fdsAudio) readRegister(address uint16) (data byte) {
+	switch {
+	case address >= 0x4040 && address <= 0x407F:
+		return f.wave[address-0x4040] | 0x40
+	case address == 0x4090:
+		return f.volume.gain | 0x40
+	case address == 0x4092:
+		return f.mod.gain | 0x40
+	default:
+		return 0x00
+	}
+}
+
+// writeRegister implements memoryMappedIO.
+func (f *fdsAudio) writeRegister(address uint16, data byte) {
+	switch {
+	case address >= 0x4040 && address <= 0x407F:
+		if f.waveWrite {
+			f.wave[address-0x4040] = data & 0x3F
+		}
+	case address == 0x4080:
+		f.volume.write(data)
+	case address == 0x4082:
+		f.waveFreq = f.waveFreq&0x0F00 | uint16(data)
+	case address == 0x4083:
+		f.waveFreq = f.waveFreq&0x00FF | uint16(data&0x0F)<<8
+		f.waveHalted = data&mask7 != 0
+		f.envelopeHalted = data&mask6 != 0
+		if f.waveHalted {
+			f.waveAccum = 0
+		}
+	case address == 0x4084:
+		f.mod.write(data)
+	case address == 0x4085:
+		f.modCount = signExtend7(data)
+	case address == 0x4086:
+		f.modFreq = f.modFreq&0x0F00 | uint16(data)
+	case address == 0x4087:
+		f.modFreq = f.modFreq&0x00FF | uint16(data&0x0F)<<8
+		f.modHalted = data&mask7 != 0
+		if f.modHalted {
+			f.modAccum = 0
+		}
+	case address == 0x4088:
+		// The mod table is a ring buffer written two entries at a time while halted
+		if f.modHalted {
+			f.modTable[f.modPos] = data & 0x07
+			f.modTable[(f.modPos+1)%fdsModTableLen] = data & 0x07
+			f.modPos = (f.modPos + 2) % fdsModTableLen
+		}
+	case address == 0x4089:
+		f.waveWrite = data&mask7 != 0
+		f.masterVolume = data & mask01
+	case address == 0x408A:
+		f.envelopeSpeed = data
+	}
+}
+
+// signExtend7 interprets the low 7 bits of data as a signed number.
+func signExtend7(data byte) int {
+	n := int(data & 0x7F)
+	if n >= 0x40 {
+		n -= 0x80
+	}
+	return n
+}
+
+// clock clocks the channel once per cpu cycle.
+func (f *fdsAudio) clock() {
+	if !f.envelopeHalted && !f.waveHalted {
+		f.volume.clock(f.envelopeSpeed)
+		f.mod.clock(f.envelopeSpeed)
+	}
+
+	if !f.modHalted && f.modFreq != 0 {
+		f.modAccum += uint32(f.modFreq)
+		if f.modAccum >= 0x10000 {
+			f.modAccum &= 0xFFFF
+			f.stepMod()
+		}
+	}
+
+	if f.waveHalted || f.waveWrite {
+		return
+	}
+
+	f.waveAccum = (f.waveAccum + f.pitch()) & 0x3FFFFF
+	f.out = f.wave[f.waveAccum>>16]
+}
+
+// stepMod advances the modulation unit by one mod table entry.
+func (f *fdsAudio) stepMod() {
+	step := fdsModTableSteps[f.modTable[f.modPos]]
+	f.modPos = (f.modPos + 1) % fdsModTableLen
+
+	if step == fdsModReset {
+		f.modCount = 0
+		return
+	}
+
+	f.modCount += step
+	if f.modCount >= 0x40 {
+		f.modCount -= 0x80
+	} else if f.modCount < -0x40 {
+		f.modCount += 0x80
+	}
+}
+
+// pitch returns the wave frequency, adjusted by the modulation unit.
+// See https://wiki.nesdev.com/w/index.php/FDS_audio#Frequency_calculation.
+func (f *fdsAudio) pitch() uint32 {
+	temp := f.modCount * int(f.mod.gain)
+	remainder := temp & 0x0F
+	temp >>= 4
+	if remainder > 0 && temp&0x80 == 0 {
+		if f.modCount < 0 {
+			temp--
+		} else {
+			temp += 2
+		}
+	}
+
+	if temp >= 192 {
+		temp -= 256
+	} else if temp < -64 {
+		temp += 256
+	}
+
+	temp *= int(f.waveFreq)
+	remainder = temp & 0x3F
+	temp >>= 6
+	if remainder >= 32 {
+		temp++
+	}
+
+	pitch := int(f.waveFreq) + temp
+	if pitch < 0 {
+		return 0
+	}
+	return uint32(pitch)
+}
+
+// audioOutput implements expansionAudio.
+func (f *fdsAudio) audioOutput() float32 {
+	gain := min(f.volume.gain, 32)
+	level := int(f.out) * int(gain) * fdsMasterVolumes[f.masterVolume] / 30
+	return float32(level) / (63 * 32) * fdsMixLevel
+}
+
+// fdsMixLevel is the output level of the FDS audio channel at full volume, relative to the
+// output level of the apu at full volume.
+const fdsMixLevel = 0.6
+
+// saveState writes the state of the envelope to w.
+func (e *fdsEnvelope) saveState(w *stateWriter) {
+	w.bool(e.disabled)
+	w.bool(e.increase)
+	w.u8(e.speed)
+	w.u8(e.gain)
+	w.int(e.timer)
+}
+
+// loadState reads the state of the envelope from r.
+func (e *fdsEnvelope) loadState(r *stateReader) {
+	e.disabled = r.bool()
+	e.increase = r.bool()
+	e.speed = r.u8()
+	e.gain = r.u8()
+	e.timer = r.int()
+}
+
+// saveState implements stateful.
+func (f *fdsAudio) saveState(w *stateWriter) {
+	w.bytes(f.wave[:])
+	w.bytes(f.modTable[:])
+	f.volume.saveState(w)
+	f.mod.saveState(w)
+	w.u16(f.waveFreq)
+	w.u16(f.modFreq)
+	w.u32(f.waveAccum)
+	w.u32(f.modAccum)
+	w.int(f.modPos)
+	w.int(f.modCount)
+	w.bool(f.waveHalted)
+	w.bool(f.envelopeHalted)
+	w.bool(f.modHalted)
+	w.bool(f.waveWrite)
+	w.u8(f.masterVolume)
+	w.u8(f.envelopeSpeed)
+	w.u8(f.out)
+}
+
+// loadState implements stateful.
+func (f *fdsAudio) loadState(r *stateReader) {
+	r.bytes(f.wave[:])
+	r.bytes(f.modTable[:])
+	f.volume.loadState(r)
+	f.mod.loadState(r)
+	f.waveFreq = r.u16()
+	f.modFreq = r.u16()
+	f.waveAccum = r.u32()
+	f.modAccum = r.u32()
+	f.modPos = r.index(fdsModTableLen)
+	f.modCount = r.int()
+	f.waveHalted = r.bool()
+	f.envelopeHalted = r.bool()
+	f.modHalted = r.bool()
+	f.waveWrite = r.bool()
+	f.masterVolume = r.u8Index(len(fdsMasterVolumes))
+	f.envelopeSpeed = r.u8()
+	f.out = r.u8()
+}
@@ -0,0 +1,303 @@
+package core
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// builtinGameDB is the game database shipped with goretro.
+// Each record is keyed by the CRC32 and SHA-1 of a game's prgROM followed by its chrROM,
+// and lists known-good values for header fields which are often wrong in the wild.
+//
+//go:embed gamedb.csv
+var builtinGameDB string
+
+// Columns of a game database record
+const (
+	gameDBColCRC32 = iota
+	gameDBColSHA1
+	gameDBColMapper
+	gameDBColSubMapper
+	gameDBColMirroring
+	gameDBColBattery
+	gameDBColRegion
+	gameDBColPrgRAM
+	gameDBColPrgNVRAM
+	gameDBColChrRAM
+	gameDBColChrNVRAM
+	gameDBColTitle
+	gameDBNumCols
+)
+
+// errGameDBInvalid is an error related to a game database file being malformed.
+type errGameDBInvalid string
+
+func newErrGameDBInvalid(line int, message string) errGameDBInvalid {
+	return errGameDBInvalid(fmt.Sprintf("line %v: %v", line, message))
+}
+
+// Error implements error.
+func (err errGameDBInvalid) Error() string {
+	return fmt.Sprintf("game database invalid: %v", string(err))
+}
+
+// gameInfo holds known-good cartridge information for a single game.
+// Integer fields are -1 and string fields are empty when unknown, in which case
+// the value decoded from the cartridge header is kept.
+type gameInfo struct {
+	title        string
+	mapperNum    int
+	subMapperNum int
+	mirroring    string // "horizontal", "vertical" or "four-screen"
+	battery      int    // 0 or 1
+	region       region
+	prgRAMSize   int
+	prgNVRAMSize int
+	chrRAMSize   int
+	chrNVRAMSize int
+}
+
+// apply overrides the header fields of c with all fields of g that are known.
+func (g *gameInfo) apply(c *cartridge) {
+	if g.title != "" {
+		c.title = g.title
+	}
+	if g.mapperNum >= 0 {
+		c.mapperNum = g.mapperNum
+	}
+	if g.subMapperNum >= 0 {
+		c.subMapperNum = g.subMapperNum
+	}
+	switch g.mirroring {
+	case "horizontal":
+		c.vertMirroring = false
+		c.fourScreenMirroring = false
+		c.singleScreen = false
+	case "vertical":
+		c.vertMirroring = true
+		c.fourScreenMirroring = false
+		c.singleScreen = false
+	case "four-screen":
+		c.fourScreenMirroring = true
+	}
+	if g.battery >= 0 {
+		c.hasSRAM = g.battery == 1
+	}
+	if g.region != regionUnknown {
+		c.region = g.region
+	}
+	if g.prgRAMSize >= 0 || g.prgNVRAMSize >= 0 {
+		c.exactRAMSizes = true
+		c.prgRAMSize = max(g.prgRAMSize, 0)
+		c.prgNVRAMSize = max(g.prgNVRAMSize, 0)
+	}
+	if g.chrRAMSize >= 0 {
+		c.chrRAMSize = g.chrRAMSize
+	}
+	if g.chrNVRAMSize >= 0 {
+		c.chrNVRAMSize = g.chrNVRAMSize
+	}
+}
+
+// gameDatabase is a collection of gameInfo, indexed by rom digests.
+type gameDatabase struct {
+	bySHA1  map[[sha1.Size]byte]*gameInfo
+	byCRC32 map[uint32]*gameInfo
+}
+
+// lookup finds the gameInfo for a rom, preferring a SHA-1 match over a CRC32 match.
+func (db *gameDatabase) lookup(sha [sha1.Size]byte, crc uint32) (info *gameInfo, ok bool) {
+	if info, ok := db.bySHA1[sha]; ok {
+		return info, true
+	}
+
+	info, ok = db.byCRC32[crc]
+	return info, ok
+}
+
+// parseGameDatabase parses a game database in csv format from r.
+// Lines starting with '#' are comments.  Each record has the following columns:
+//
+//	crc32,sha1,mapper,submapper,mirroring,battery,region,prgram,prgnvram,chrram,chrnvram,title
+//
+// crc32 and sha1 are hex encoded, and at least one of them is required.
+// mirroring is one of horizontal, vertical or four-screen.  region is one of
+// ntsc, pal, multi or dendy.  ram sizes are in bytes.  Any column other than
+// the digests may be left empty if unknown.
+func parseGameDatabase(r io.Reader) (*gameDatabase, error) {
+	db := &gameDatabase{
+		bySHA1:  make(map[[sha1.Size]byte]*gameInfo),
+		byCRC32: make(map[uint32]*gameInfo),
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = gameDBNumCols
+	reader.TrimLeadingSpace = true
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return db, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		info, err := parseGameInfo(record)
+		if err != nil {
+			return nil, newErrGameDBInvalid(line, err.Error())
+		}
+
+		crcField := record[gameDBColCRC32]
+		shaField := record[gameDBColSHA1]
+		if crcField == "" && shaField == "" {
+			return nil, newErrGameDBInvalid(line, "record has neither a crc32 nor a sha1")
+		}
+
+		if crcField != "" {
+			crc, err := strconv.ParseUint(crcField, 16, 32)
+			if err != nil {
+				return nil, newErrGameDBInvalid(line, fmt.Sprintf("invalid crc32 %q", crcField))
+			}
+			db.byCRC32[uint32(crc)] = info
+		}
+
+		if shaField != "" {
+			var sha [sha1.Size]byte
+			decoded, err := hex.DecodeString(shaField)
+			if err != nil || len(decoded) != sha1.Size {
+				return nil, newErrGameDBInvalid(line, fmt.Sprintf("invalid sha1 %q", shaField))
+			}
+			copy(sha[:], decoded)
+			db.bySHA1[sha] = info
+		}
+	}
+}
+
+// parseGameInfo parses every column of a game database record other than the digests.
+func parseGameInfo(record []string) (*gameInfo, error) {
+	optInt := func(col int) (int, error) {
+		field := record[col]
+		if field == "" {
+			return -1, nil
+		}
+
+		num, err := strconv.Atoi(field)
+		if err != nil || num < 0 {
+			return 0, fmt.Errorf("invalid number %q", field)
+		}
+		return num, nil
+	}
+
+	info := &gameInfo{
+		title:     record[gameDBColTitle],
+		mirroring: record[gameDBColMirroring],
+		region:    regionUnknown,
+	}
+
+	switch info.mirroring {
+	case "", "horizontal", "vertical", "four-screen":
+	default:
+		return nil, fmt.Errorf("invalid mirroring %q", info.mirroring)
+	}
+
+	switch strings.ToLower(record[gameDBColRegion]) {
+	case "":
+	case "ntsc":
+		info.region = regionNTSC
+	case "pal":
+		info.region = regionPAL
+	case "multi":
+		info.region = regionMulti
+	case "dendy":
+		info.region = regionDendy
+	default:
+		return nil, fmt.Errorf("invalid region %q", record[gameDBColRegion])
+	}
+
+	var err error
+	for _, field := range []struct {
+		col int
+		dst *int
+	}{
+		{gameDBColMapper, &info.mapperNum},
+		{gameDBColSubMapper, &info.subMapperNum},
+		{gameDBColBattery, &info.battery},
+		{gameDBColPrgRAM, &info.prgRAMSize},
+		{gameDBColPrgNVRAM, &info.prgNVRAMSize},
+		{gameDBColChrRAM, &info.chrRAMSize},
+		{gameDBColChrNVRAM, &info.chrNVRAMSize},
+	} {
+		if *field.dst, err = optInt(field.col); err != nil {
+			return nil, err
+		}
+	}
+
+	if info.battery > 1 {
+		return nil, fmt.Errorf("invalid battery %v", info.battery)
+	}
+
+	return info, nil
+}
+
+var (
+	gameDBMu      sync.RWMutex
+	builtinDB     *gameDatabase // parsed lazily from builtinGameDB
+	builtinDBOnce sync.Once
+	userDB        *gameDatabase // takes precedence over builtinDB, if set
+)
+
+// lookupGame finds the gameInfo for a rom in the user supplied game database,
+// falling back to the builtin game database.
+func lookupGame(sha [sha1.Size]byte, crc uint32) (info *gameInfo, ok bool) {
+	builtinDBOnce.Do(func() {
+		db, err := parseGameDatabase(strings.NewReader(builtinGameDB))
+		if err != nil {
+			panic(fmt.Sprintf("builtin %v", err))
+		}
+		builtinDB = db
+	})
+
+	gameDBMu.RLock()
+	defer gameDBMu.RUnlock()
+
+	if userDB != nil {
+		if info, ok := userDB.lookup(sha, crc); ok {
+			return info, true
+		}
+	}
+
+	return builtinDB.lookup(sha, crc)
+}
+
+// useGameDatabase loads the game database at path, which takes precedence over the
+// builtin game database for all cartridges created afterwards.
+// See parseGameDatabase for the file format.
+func useGameDatabase(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, err := parseGameDatabase(file)
+	if err != nil {
+		return err
+	}
+
+	gameDBMu.Lock()
+	userDB = db
+	gameDBMu.Unlock()
+
+	return nil
+}
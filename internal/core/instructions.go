@@ -22,6 +22,22 @@ func (e ErrInvalidOpcode) Error() (repr string) {
 	return fmt.Sprintf("invalid opcode: %v", byte(e))
 }
 
+// errCPUJammed is an error related to the cpu jamming on an opcode which is not supported.
+type errCPUJammed struct {
+	pc     uint16 // address of the opcode
+	opcode byte
+}
+
+// Error implements error.
+func (err errCPUJammed) Error() string {
+	return fmt.Sprintf("cpu jammed at $%04X: %v", err.pc, ErrInvalidOpcode(err.opcode))
+}
+
+// Unwrap returns the ErrInvalidOpcode which jammed the cpu.
+func (err errCPUJammed) Unwrap() error {
+	return ErrInvalidOpcode(err.opcode)
+}
+
 // IsInvalidOpcodeErr returns whether or not err is of type
 // ErrInvalidOpcode.
 func IsInvalidOpcodeErr(err error) (invalid bool) {
@@ -1355,9 +1371,13 @@ func (c *cpu) BPL(address uint16) {
 }
 
 // BRK Force Interrupt
+// BRK is followed by a padding byte, skipped on return, and pushes the status with the break
+// flag set.
 func (c *cpu) BRK(address uint16) {
-	c.GenerateInterrupt(irq)
-	c.status.b = true
+	c.push16(c.pc + 1)
+	c.pushStack(c.status.asByte() | mask4 | mask5)
+	c.status.i = true
+	c.pc = c.read16(irqVector)
 }
 
 // BVC Branch if Overflow Clear
@@ -1468,8 +1488,9 @@ func (c *cpu) JMP(address uint16) {
 }
 
 // JSR Jump to Subroutine
+// The address pushed is that of the last byte of the JSR instruction, which RTS accounts for.
 func (c *cpu) JSR(address uint16) {
-	c.push16(c.pc)
+	c.push16(c.pc - 1)
 	c.pc = address
 }
 
@@ -1533,7 +1554,7 @@ func (c *cpu) PHA(address uint16) {
 
 // PHP Push Processor Status
 func (c *cpu) PHP(address uint16) {
-	c.pushStack(c.status.asByte())
+	c.pushStack(c.status.asByte() | mask4 | mask5)
 }
 
 // PLA Pull Accumulator
@@ -1545,6 +1566,8 @@ func (c *cpu) PLA(address uint16) {
 // PLP Pull Processor Status
 func (c *cpu) PLP(address uint16) {
 	c.status.fromByte(c.pullStack())
+	c.status.b = false
+	c.status.u = true
 }
 
 // ROLA Rotate Left, acting on Accumulator.
@@ -1618,12 +1641,12 @@ func (c *cpu) RTI(address uint16) {
 	c.status.fromByte(c.pullStack())
 	c.pc = c.pull16()
 	c.status.b = false
-	c.status.i = false
+	c.status.u = true
 }
 
 // RTS Return from Subroutine
 func (c *cpu) RTS(address uint16) {
-	c.pc = c.pull16()
+	c.pc = c.pull16() + 1
 }
 
 // SBC Subtract with Carry
@@ -0,0 +1,32 @@
+package core
+
+// joypad2 is the register at which the second joypad is read.  Writes go to the apu frame
+// counter instead.
+const joypad2 = 0x4017
+
+// joypad is a standard controller.  While strobe is set, it continuously reloads its shift
+// register with the buttons held; once cleared, each read returns the next button.
+// See https://wiki.nesdev.com/w/index.php/Standard_controller.
+type joypad struct {
+	buttons byte // buttons held, A in bit 0 through Right in bit 7
+	shift   byte // buttons not yet read
+	strobe  bool
+}
+
+// write writes the strobe bit of $4016 to the joypad.
+func (j *joypad) write(data byte) {
+	j.strobe = data&mask0 != 0
+	if j.strobe {
+		j.shift = j.buttons
+	}
+}
+
+// read returns the next button, in bit 0.  Once every button has been read, reads return 1.
+func (j *joypad) read() (data byte) {
+	if j.strobe {
+		return j.buttons & mask0
+	}
+	data = j.shift & mask0
+	j.shift = j.shift>>1 | mask7
+	return data
+}
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/justinawrey/goretro/internal/app"
+)
+
+func TestJoypadRead(t *testing.T) {
+	input := app.NewWebviewInputDriver()
+	input.SetButton(app.Primary, app.A, true)
+	input.SetButton(app.Primary, app.Start, true)
+	input.SetButton(app.Primary, app.Right, true)
+
+	n := NewNes(nil, input, nil)
+	n.latchInput()
+	n.mem.write(joypad1, 1)
+	n.mem.write(joypad1, 0)
+
+	want := []byte{1, 0, 0, 1, 0, 0, 0, 1, 1, 1}
+	for i, w := range want {
+		if got := n.mem.Read(joypad1) & mask0; got != w {
+			t.Errorf("read %v: want %v, got %v", i, w, got)
+		}
+	}
+	if got := n.mem.Read(joypad2) & mask0; got != 0 {
+		t.Errorf("second joypad: want 0, got %v", got)
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import "fmt"
+
+// memoryMappedIO is a module whose registers are mapped into the cpu memory map.
+type memoryMappedIO interface {
+	readRegister(address uint16) (data byte)
+	writeRegister(address uint16, data byte)
+}
+
+// mapper is the circuitry of a cartridge which maps its prg and chr memory into the
+// cpu and ppu address spaces respectively.  The cpu side ($4020-$FFFF) is accessed as
+// memoryMappedIO, and the ppu side ($0000-$1FFF) through readChr and writeChr.
+// See https://wiki.nesdev.com/w/index.php/Mapper.
+type mapper interface {
+	memoryMappedIO
+	readChr(address uint16) (data byte)
+	writeChr(address uint16, data byte)
+}
+
+// mirroring is the arrangement of the four nametables of the ppu address space onto
+// nametable RAM.
+// See https://wiki.nesdev.com/w/index.php/Mirroring#Nametable_Mirroring.
+type mirroring int
+
+// Nametable mirroring modes
+const (
+	mirrorHorizontal mirroring = iota // nametables 0 and 1 share RAM, as do 2 and 3
+	mirrorVertical                    // nametables 0 and 2 share RAM, as do 1 and 3
+	mirrorSingleLow                   // every nametable uses the first 1kB of RAM
+	mirrorSingleHigh                  // every nametable uses the second 1kB of RAM
+	mirrorFourScreen                  // every nametable has its own RAM, 2kB of it on the cartridge
+)
+
+// String implements Stringer.
+func (m mirroring) String() string {
+	switch m {
+	case mirrorHorizontal:
+		return "horizontal"
+	case mirrorVertical:
+		return "vertical"
+	case mirrorSingleLow:
+		return "single screen (low)"
+	case mirrorSingleHigh:
+		return "single screen (high)"
+	case mirrorFourScreen:
+		return "four screen"
+	default:
+		return "unknown"
+	}
+}
+
+// mirrorController is implemented by mappers which control nametable mirroring, overriding
+// the mirroring given by the cartridge header.
+type mirrorController interface {
+	nametableMirroring() mirroring
+}
+
+// clocked is implemented by mappers with circuitry driven by the cpu clock, such as irq
+// counters, disk drives or sound channels.
+type clocked interface {
+	// clock clocks the mapper once per cpu cycle.
+	clock()
+}
+
+// interrupter is implemented by mappers which can assert the cpu irq line.
+type interrupter interface {
+	// irqPending returns whether or not the mapper is asserting an irq.
+	irqPending() bool
+}
+
+// errMapperUnsupported is an error related to a cartridge using a mapper
+// which has not been implemented.
+type errMapperUnsupported int
+
+// Error implements error.
+func (err errMapperUnsupported) Error() string {
+	return fmt.Sprintf("mapper unsupported: %v", int(err))
+}
+
+// newMapper creates the mapper used by cartridge c.
+// Returns an error of type errMapperUnsupported if the mapper has not been implemented.
+func newMapper(c *cartridge) (mapper, error) {
+	switch c.mapperNum {
+	case 0:
+		return &nrom{c}, nil
+	case 16, 159:
+		return newBandaiFCG(c), nil
+	case fdsMapperNum:
+		if c.disk == nil {
+			return nil, errMapperUnsupported(c.mapperNum)
+		}
+		return newFDS(c), nil
+	default:
+		return nil, errMapperUnsupported(c.mapperNum)
+	}
+}
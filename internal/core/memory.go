@@ -11,6 +11,12 @@ const (
 	ppuMirrorStart = 0x2000
 	ramEnd         = 0x1FFF
 	ppuEnd         = 0x3FFF
+	oamDMA         = 0x4014
+	joypad1        = 0x4016
+	ioEnd          = 0x401F
+	cartStart      = 0x4020
+	prgRAMStart    = 0x6000
+	trainerStart   = 0x7000
 	prgROMStart    = 0x8000
 )
 
@@ -30,9 +36,14 @@ const (
 // $4020-$FFFF	$BFE0	Cartridge space: PRG ROM, PRG RAM, and mapper registers (See Note)
 type memory struct {
 	internal [internalRAMSize]byte
-	// ppuIO    memoryMappedIO
-	// cartIO   memoryMappedIO
-	// apuIO    memoryMappedIO
+	ppuIO    memoryMappedIO
+	apuIO    memoryMappedIO
+	cartIO   memoryMappedIO
+
+	joypads [2]joypad // joypads read through $4016 and $4017
+
+	dmaPage    byte // page of the oam dma requested through $4014
+	dmaPending bool // whether or not an oam dma has been requested, but not yet performed
 }
 
 // New constructs a new memory.
@@ -40,22 +51,20 @@ func newMemory() (m *memory) {
 	return &memory{}
 }
 
-// AssignmemoryMappedIO sets up writing to / reading from memory to be memory mapped
-// with the specified argument modules.
-// TODO: wtf is this
-// func (m *memory) AssignMemoryMappedIO(mmios ...memoryMappedIO) {
-// for _, mmio := range mmios {
-// 	switch io := mmio.(type) {
-// 	case *PPU:
-// 		m.ppuIO = io
-// 	case *Cartridge:
-// 		m.cartIO = io
-// 	case *APU:
-// 		m.apuIO = io
-// 	default:
-// 	}
-// }
-// }
+// usePpu maps the ppu registers ($2000-$3FFF) of m to ppuIO.
+func (m *memory) usePpu(ppuIO memoryMappedIO) {
+	m.ppuIO = ppuIO
+}
+
+// useApu maps the apu registers ($4000-$4013, $4015 and $4017) of m to apuIO.
+func (m *memory) useApu(apuIO memoryMappedIO) {
+	m.apuIO = apuIO
+}
+
+// useCartridge maps the cartridge space ($4020-$FFFF) of m to cartIO.
+func (m *memory) useCartridge(cartIO memoryMappedIO) {
+	m.cartIO = cartIO
+}
 
 // readmemory reads data from address in cpu main memory.
 func (m *memory) readMemory(address uint16) (data byte) {
@@ -79,15 +88,24 @@ func (m *memory) Read(address uint16) (data byte) {
 		// memory mapped IO for PPU.  Mirrored memory at a frequency of 0x0008.
 		// Same modulus trick as above.
 		address = (address % ppuMirrorFreq) + ppuMirrorStart
-		// return m.ppuIO.readRegister(address)
-		//TODO:
-		return 1
-	case address >= prgROMStart:
+		if m.ppuIO == nil {
+			return 0x00
+		}
+		return m.ppuIO.readRegister(address)
+	case address <= ioEnd:
+		switch {
+		case address == apuStatus && m.apuIO != nil:
+			return m.apuIO.readRegister(address)
+		case address == joypad1:
+			return m.joypads[0].read()
+		case address == joypad2:
+			return m.joypads[1].read()
+		}
+		return 0x00
+	case address >= cartStart && m.cartIO != nil:
 		// Even though most mappers only have a couple of registers for IO purposes,
-		// we treat all of prgROM as memory mapped IO.  This simplifies code structure.
-		// return m.cartIO.readRegister(address)
-		//TODO:
-		return 1
+		// we treat all of cartridge space as memory mapped IO.  This simplifies code structure.
+		return m.cartIO.readRegister(address)
 	default:
 		// TODO: handle the rest
 		return 0x00
@@ -101,13 +119,34 @@ func (m *memory) write(address uint16, data byte) {
 		m.writeMemory(address, data)
 	case address <= ppuEnd:
 		address = (address % ppuMirrorFreq) + ppuMirrorStart
-	//TODO:
-	// m.ppuIO.writeRegister(address, data)
+		if m.ppuIO != nil {
+			m.ppuIO.writeRegister(address, data)
+		}
+	case address <= ioEnd:
+		switch {
+		case address == oamDMA:
+			m.dmaPage = data
+			m.dmaPending = true
+		case address == joypad1:
+			m.joypads[0].write(data)
+			m.joypads[1].write(data)
+		case address <= apuEnd && m.apuIO != nil:
+			m.apuIO.writeRegister(address, data)
+		}
+	case address >= cartStart && m.cartIO != nil:
+		m.cartIO.writeRegister(address, data)
 	default:
 		// TODO: handle the rest
 	}
 }
 
+// takeDMA returns the page of the oam dma requested through $4014, if there is one.
+func (m *memory) takeDMA() (page byte, ok bool) {
+	ok = m.dmaPending
+	m.dmaPending = false
+	return m.dmaPage, ok
+}
+
 // Clear sets all cpu RAM (0x0000 to 0x1FFF) to 0x00.
 func (m *memory) clear() {
 	for i := range m.internal {
@@ -115,6 +154,31 @@ func (m *memory) clear() {
 	}
 }
 
+// saveState implements stateful.
+func (m *memory) saveState(w *stateWriter) {
+	w.bytes(m.internal[:])
+	for _, j := range m.joypads {
+		w.u8(j.buttons)
+		w.u8(j.shift)
+		w.bool(j.strobe)
+	}
+	w.u8(m.dmaPage)
+	w.bool(m.dmaPending)
+}
+
+// loadState implements stateful.
+func (m *memory) loadState(r *stateReader) {
+	r.bytes(m.internal[:])
+	for i := range m.joypads {
+		j := &m.joypads[i]
+		j.buttons = r.u8()
+		j.shift = r.u8()
+		j.strobe = r.bool()
+	}
+	m.dmaPage = r.u8()
+	m.dmaPending = r.bool()
+}
+
 // Read16 reads two bytes, in little endian order, starting
 // at memory location from.  The bytes are concatenated
 // into a two byte word and returned.
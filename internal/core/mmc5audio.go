@@ -0,0 +1,81 @@
+package core
+
+// mmc5FrameLen is the period of the MMC5 frame counter in cpu cycles.  Unlike the apu, the
+// MMC5 clocks its envelopes and length counters together at a fixed rate of about 240 Hz.
+const mmc5FrameLen = 7457
+
+// mmc5Audio is the sound hardware of the Nintendo MMC5: two pulse channels without sweep units
+// and a raw pcm channel, mapped to $5000-$5015.
+// See https://wiki.nesdev.com/w/index.php/MMC5_audio.
+type mmc5Audio struct {
+	pulse1 pulse
+	pulse2 pulse
+	pcm    byte // $5011
+	cycle  int  // cpu cycles since the last frame counter clock
+	even   bool // whether or not the current cpu cycle is the first of an apu cycle
+}
+
+// newMmc5Audio creates MMC5 sound hardware in its power up state.
+func newMmc5Audio() *mmc5Audio {
+	m := &mmc5Audio{}
+	m.pulse1.sweepless = true
+	m.pulse2.sweepless = true
+	return m
+}
+
+// readRegister implements memoryMappedIO.
+// Only $5015, the length counter status, is readable.
+func (m *mmc5Audio) readRegister(address uint16) (data byte) {
+	if address != 0x5015 {
+		return 0x00
+	}
+	if m.pulse1.length > 0 {
+		data |= mask0
+	}
+	if m.pulse2.length > 0 {
+		data |= mask1
+	}
+	return data
+}
+
+// writeRegister implements memoryMappedIO.
+func (m *mmc5Audio) writeRegister(address uint16, data byte) {
+	switch {
+	case address <= 0x5003:
+		m.pulse1.write(address-0x5000, data)
+	case address <= 0x5007:
+		m.pulse2.write(address-0x5004, data)
+	case address == 0x5011:
+		// Writes of 0 are ignored in pcm write mode
+		if data != 0 {
+			m.pcm = data
+		}
+	case address == 0x5015:
+		m.pulse1.setEnabled(data&mask0 != 0)
+		m.pulse2.setEnabled(data&mask1 != 0)
+	}
+}
+
+// clock clocks the channels once per cpu cycle.
+func (m *mmc5Audio) clock() {
+	m.cycle++
+	if m.cycle == mmc5FrameLen {
+		m.cycle = 0
+		for _, p := range []*pulse{&m.pulse1, &m.pulse2} {
+			p.envelope.clock()
+			p.clockLength()
+		}
+	}
+
+	if m.even {
+		m.pulse1.clockTimer()
+		m.pulse2.clockTimer()
+	}
+	m.even = !m.even
+}
+
+// audioOutput implements expansionAudio.
+// The MMC5 pulse channels are mixed like the apu pulse channels, and the pcm channel like the dmc.
+func (m *mmc5Audio) audioOutput() float32 {
+	return pulseMixTable[m.pulse1.output()+m.pulse2.output()] + tndMixTable[m.pcm>>1]
+}
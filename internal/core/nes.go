@@ -3,6 +3,9 @@ package core
 import (
 	"fmt"
 	"io"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/justinawrey/goretro/internal/app"
 	"github.com/justinawrey/goretro/internal/log"
@@ -12,12 +15,50 @@ import (
 // which work together to power the entire system.
 // NES is meant be used primarily as a high-level emulation API.
 type nes struct {
+	mu sync.Mutex // guards emulated nes internals
+
 	// emulated nes internals
-	cpu  *cpu
-	ppu  *ppu
-	apu  *apu
-	mem  *memory
-	cart *cartridge
+	cpu    *cpu
+	ppu    *ppu
+	apu    *apu
+	mem    *memory
+	cart   *cartridge
+	mapper mapper
+
+	// region timing
+	timing   *timing // timing of the selected region
+	region   region  // region selected by SetRegion, or regionUnknown to follow the cartridge
+	dotClock int     // accumulates timing.dots each cpu cycle, running a ppu dot per timing.cycles
+
+	// battery backed memory persistence
+	save      *saveFile     // nil if the cartridge has no battery backed memory
+	savesDir  string        // directory holding save files, or empty to keep them next to the rom
+	stopFlush chan struct{} // closed to stop periodically flushing save
+
+	fdsBIOSPath string // path of the FDS BIOS, or empty to look for disksys.rom next to the disk
+	statesDir   string // directory holding save slots, or empty to keep them next to the rom
+
+	palette *systemPalette      // maps the colors output by the ppu to rgb
+	rgba    [frameSize * 4]byte // last completed frame, as RGBA pixels
+
+	sendMu sync.Mutex          // held while sending a frame to the display, before n.mu
+	sent   [frameSize * 4]byte // frame being sent, copied from rgba so as not to hold n.mu
+
+	// real time running
+	stopRunner chan struct{} // closed to stop the runner, nil if it is not running
+	runnerDone chan struct{} // closed once the runner has stopped
+	pacing     pacing        // how the runner paces emulation
+	paused     bool          // whether or not the runner is paused
+	speed      float64       // speed the runner runs at, relative to real time, or 0 for unlimited
+	presented  time.Time     // when the runner last sent a frame to the display
+	stats      frameStats    // timing of the frames run by the runner
+	rewind     *rewindBuffer // snapshots the runner can rewind through
+	rewinding  bool          // whether or not the runner is rewinding
+
+	// run-ahead
+	runAhead       int  // frames the runner runs ahead of the frame it shows
+	runAheadSecond bool // whether or not to run ahead on ahead, rather than on this nes
+	ahead          *nes // second instance to run ahead on, created once needed
 
 	// real io
 	disp  *app.WebviewDisplayDriver
@@ -25,54 +66,574 @@ type nes struct {
 	audio *app.WebviewAudioDriver
 }
 
-func (n *nes) UseCartridge(path string) error {
-	cart, err := newCartridge(path)
+// UseCartridge inserts the cartridge at path, ejecting the current cartridge if there is one.
+// Battery backed memory is loaded from the cartridge's save file, and is flushed back to it
+// periodically until the cartridge is ejected or the nes is shut down.
+// patches are applied to the rom in memory; see newCartridge.
+func (n *nes) UseCartridge(path string, patches ...string) error {
+	return n.UseArchivedCartridge(path, "", patches...)
+}
+
+// UseArchivedCartridge is like UseCartridge, but path may also be a zip, gzip or 7z archive
+// from which the rom named entry is loaded.  If entry is empty, the first .nes file is used, or
+// failing that the first file of another rom format.
+func (n *nes) UseArchivedCartridge(path, entry string, patches ...string) error {
+	cart, err := newArchivedCartridge(path, entry, patches...)
 	if err != nil {
 		return err
 	}
 
-	n.cart = cart
+	if cart.mapperNum == fdsMapperNum && cart.disk != nil {
+		n.mu.Lock()
+		biosPath := n.fdsBIOSPath
+		n.mu.Unlock()
+		cart.bios, err = loadFDSBIOS(biosPath, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	m, err := newMapper(cart)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// The current cartridge is only ejected once the save file of the new one is open, flushing
+	// it first in case they share the save file
+	if n.save != nil {
+		if err := n.save.flush(); err != nil {
+			return err
+		}
+	}
+	var save *saveFile
+	if battery, ok := m.(batteryBacked); ok && len(battery.batteryRAM()) > 0 {
+		if save, err = newSaveFile(savePath(path, n.savesDir), battery); err != nil {
+			return err
+		}
+	}
+
+	if err := n.stopSaving(); err != nil {
+		return err
+	}
+	if save != nil {
+		n.save = save
+		n.stopFlush = make(chan struct{})
+		go n.flushPeriodically(n.stopFlush)
+	}
+
+	n.insertCartridge(cart, m)
 	log.Log(fmt.Sprintf("cartridge loaded: %v", cart))
 
 	return nil
 }
 
+// insertCartridge connects cart, mapped by m, to the nes and powers it on.  n.mu must be held.
+func (n *nes) insertCartridge(cart *cartridge, m mapper) {
+	n.cart = cart
+	n.mapper = m
+	n.mem.useCartridge(m)
+	n.ppu.useCartridge(m, cart.headerMirroring(), cart.vRAM)
+	n.useRegion()
+	n.powerOn()
+	n.ppu.frames = 0 // frames count play time, as shown by save slots
+	n.rewind.clear()
+	n.ahead = nil
+}
+
+// ejectCartridge stops persisting battery backed memory of the current cartridge,
+// flushing it one last time.
+func (n *nes) ejectCartridge() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stopSaving()
+}
+
+// stopSaving stops persisting battery backed memory of the current cartridge, flushing it one
+// last time.  n.mu must be held.
+func (n *nes) stopSaving() error {
+	if n.stopFlush != nil {
+		close(n.stopFlush)
+		n.stopFlush = nil
+	}
+
+	if n.save == nil {
+		return nil
+	}
+
+	err := n.save.flush()
+	n.save = nil
+	return err
+}
+
+// flushPeriodically flushes battery backed memory every saveFlushInterval until stop is closed.
+func (n *nes) flushPeriodically(stop chan struct{}) {
+	ticker := time.NewTicker(saveFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n.mu.Lock()
+			if n.save != nil {
+				if err := n.save.flush(); err != nil {
+					log.Log(fmt.Sprintf("failed to flush save file: %v", err))
+				}
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// ArchiveEntries returns the names of the files within the archive at path, so that one may be
+// chosen for UseArchivedCartridge.  Returns nil if path is not an archive.
+func (n *nes) ArchiveEntries(path string) ([]string, error) {
+	return archiveEntries(path)
+}
+
+// SetSavesDir sets the directory in which save files are kept for cartridges inserted afterwards.
+// If dir is empty, save files are kept next to their rom.
+func (n *nes) SetSavesDir(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.savesDir = dir
+}
+
+// Shutdown stops the nes running and flushes any battery backed memory to disk.
+// It should be called before exiting.
+func (n *nes) Shutdown() error {
+	n.Stop()
+	return n.ejectCartridge()
+}
+
+// UseGameDatabase loads a user supplied game database from path.  Its entries take
+// precedence over the builtin game database for every cartridge loaded afterwards.
+func (n *nes) UseGameDatabase(path string) error {
+	return useGameDatabase(path)
+}
+
+// SetFDSBIOS sets the path of the FDS BIOS used for disks inserted afterwards.
+// If path is empty, disksys.rom is looked for next to each disk image.
+func (n *nes) SetFDSBIOS(path string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.fdsBIOSPath = path
+}
+
+// SetRegion sets the region whose timing the nes runs with: one of NTSC, PAL or Dendy, or auto
+// to follow the cartridge's NES 2.0 header or game database entry.
+func (n *nes) SetRegion(name string) error {
+	r, err := parseRegion(name)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.region = r
+	n.useRegion()
+	n.ahead = nil
+	return nil
+}
+
+// Region returns the name of the region whose timing the nes is running with.
+func (n *nes) Region() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.timing.region.String()
+}
+
+// FrameRate returns the number of frames per second the nes outputs in its region.
+func (n *nes) FrameRate() float64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.timing.frameRate()
+}
+
+// useRegion times the nes as the region selected by SetRegion, or otherwise the region of the
+// loaded cartridge or NSF.  n.mu must be held.
+func (n *nes) useRegion() {
+	r := n.region
+	if r == regionUnknown {
+		switch m := n.mapper.(type) {
+		case *nsfPlayer:
+			r = m.region()
+		default:
+			if n.cart != nil {
+				r = n.cart.region
+			}
+		}
+	}
+
+	n.timing = regionTiming(r)
+	n.dotClock = 0
+	n.ppu.timing = n.timing
+	n.apu.useTiming(n.timing)
+	if player, ok := n.mapper.(*nsfPlayer); ok {
+		player.useTiming(n.timing)
+	}
+}
+
+// Palettes returns the names of the builtin palettes.
+func (n *nes) Palettes() []string {
+	return append([]string(nil), paletteNames...)
+}
+
+// SetPalette selects the builtin palette called name.
+func (n *nes) SetPalette(name string) error {
+	palette, err := presetPalette(name)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.palette = palette
+	n.syncAhead()
+	return nil
+}
+
+// LoadPalette selects the palette in the .pal file at path, which holds either 64 colors or
+// 512 colors including emphasis variants.
+func (n *nes) LoadPalette(path string) error {
+	palette, err := loadPalette(path)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.palette = palette
+	n.syncAhead()
+	return nil
+}
+
+// GeneratePalette selects a palette generated from the composite signal of the ppu.  hue
+// rotates its hues, in degrees, and saturation scales its saturation, where 1 is typical.
+func (n *nes) GeneratePalette(hue, saturation float64) {
+	palette := generatePalette(hue, saturation)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.palette = palette
+	n.syncAhead()
+}
+
+// RemoveSpriteLimit sets whether or not every sprite on a scanline is rendered, rather than
+// only the first 8 as on hardware.  This reduces flicker in games that cycle their sprites, while
+// the sprite overflow flag still behaves as on hardware.
+func (n *nes) RemoveSpriteLimit(remove bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ppu.unlimitedSprites = remove
+	n.syncAhead()
+}
+
+// DiskSides returns the number of disk sides of the loaded FDS disk,
+// or 0 if the loaded cartridge is not an FDS disk.
+func (n *nes) DiskSides() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, ok := n.mapper.(*fds)
+	if !ok {
+		return 0
+	}
+	return f.diskSides()
+}
+
+// InsertDisk flips the loaded FDS disk to side, counting from 0 (disk 1 side A).
+// The drive reads as empty for about a second while the disk is swapped, as games expect.
+func (n *nes) InsertDisk(side int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, ok := n.mapper.(*fds)
+	if !ok {
+		return errNotFDS
+	}
+	return f.insertDisk(side)
+}
+
+// EjectDisk removes the disk from the FDS drive.
+func (n *nes) EjectDisk() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, ok := n.mapper.(*fds)
+	if !ok {
+		return errNotFDS
+	}
+	f.ejectDisk()
+	return nil
+}
+
+// Title returns the title of the loaded cartridge or NSF, or an empty string if
+// nothing is loaded or the game database does not know it.
+func (n *nes) Title() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if player, ok := n.mapper.(*nsfPlayer); ok {
+		return player.title
+	}
+	if n.cart == nil {
+		return ""
+	}
+
+	return n.cart.title
+}
+
+// UseNSF loads the NSF or NSFe file at path in place of a cartridge, and prepares to play
+// its starting track.  path may also be a zip, gzip or 7z archive holding the file.
+func (n *nes) UseNSF(path string) error {
+	file, _, err := readROM(path, "")
+	if err != nil {
+		return err
+	}
+	if !isNSF(file) {
+		return errNSFFileInvalid(fmt.Sprintf("%v is not an NSF or NSFe file", path))
+	}
+
+	tune, err := decodeNSF(file)
+	if err != nil {
+		return err
+	}
+
+	if err := n.ejectCartridge(); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player := newNSFPlayer(tune)
+	n.cart = nil
+	n.mapper = player
+	n.mem.useCartridge(player)
+	n.ppu.useCartridge(player, mirrorHorizontal, nil)
+	n.useRegion()
+	n.resetNSF(player, tune.start)
+	n.rewind.clear()
+	n.ahead = nil
+	log.Log(fmt.Sprintf("NSF loaded: %v", path))
+
+	return nil
+}
+
+// resetNSF resets the nes to begin playing track of player.
+func (n *nes) resetNSF(player *nsfPlayer, track int) {
+	player.reset(track)
+	n.powerOn()
+}
+
+// nsfPlayer returns the NSF player in use, or an error if an NSF is not loaded.
+func (n *nes) nsfPlayer() (*nsfPlayer, error) {
+	player, ok := n.mapper.(*nsfPlayer)
+	if !ok {
+		return nil, errNSFFileInvalid("no NSF loaded")
+	}
+	return player, nil
+}
+
+// TrackCount returns the number of tracks of the loaded NSF, or 0 if an NSF is not loaded.
+func (n *nes) TrackCount() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player, err := n.nsfPlayer()
+	if err != nil {
+		return 0
+	}
+	return len(player.tracks)
+}
+
+// Track returns the track of the loaded NSF being played, counting from 0.
+func (n *nes) Track() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player, err := n.nsfPlayer()
+	if err != nil {
+		return 0
+	}
+	return player.track
+}
+
+// SelectTrack restarts the loaded NSF playing track, counting from 0.
+func (n *nes) SelectTrack(track int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player, err := n.nsfPlayer()
+	if err != nil {
+		return err
+	}
+	if track < 0 || track >= len(player.tracks) {
+		return errNSFFileInvalid(fmt.Sprintf("track %v out of range [0, %v)", track, len(player.tracks)))
+	}
+
+	n.resetNSF(player, track)
+	return nil
+}
+
+// TrackTitle returns the title of track of the loaded NSF, or an empty string if it is unknown.
+func (n *nes) TrackTitle(track int) string {
+	t, ok := n.nsfTrack(track)
+	if !ok {
+		return ""
+	}
+	return t.title
+}
+
+// TrackDuration returns the duration of track of the loaded NSF in milliseconds,
+// or -1 if it is unknown.
+func (n *nes) TrackDuration(track int) int {
+	t, ok := n.nsfTrack(track)
+	if !ok {
+		return -1
+	}
+	return t.duration
+}
+
+// TrackFade returns the fade out time of track of the loaded NSF in milliseconds,
+// or -1 if it is unknown.
+func (n *nes) TrackFade(track int) int {
+	t, ok := n.nsfTrack(track)
+	if !ok {
+		return -1
+	}
+	return t.fade
+}
+
+// Artist returns the artist of the loaded NSF, or an empty string if it is unknown.
+func (n *nes) Artist() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player, err := n.nsfPlayer()
+	if err != nil {
+		return ""
+	}
+	return player.artist
+}
+
+// Copyright returns the copyright holder of the loaded NSF, or an empty string if it is unknown.
+func (n *nes) Copyright() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player, err := n.nsfPlayer()
+	if err != nil {
+		return ""
+	}
+	return player.copyright
+}
+
+// UnsupportedChips returns the names of the expansion chips used by the loaded NSF which goretro
+// does not emulate, such as VRC7, whose channels are silent.  Returns nil if there are none, or
+// if an NSF is not loaded.
+func (n *nes) UnsupportedChips() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player, err := n.nsfPlayer()
+	if err != nil {
+		return nil
+	}
+	return slices.Clone(player.unsupported)
+}
+
+// nsfTrack returns track of the loaded NSF, and whether or not it exists.
+func (n *nes) nsfTrack(track int) (nsfTrack, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	player, err := n.nsfPlayer()
+	if err != nil || track < 0 || track >= len(player.tracks) {
+		return nsfTrack{}, false
+	}
+	return player.tracks[track], true
+}
+
 // NewNes creates a new NES.
 func NewNes(disp *app.WebviewDisplayDriver, input *app.WebviewInputDriver, audio *app.WebviewAudioDriver) *nes {
 	cpu := newCpu()
+	mem := newMemory()
+	cpu.UseMemory(mem)
+	ppu := newPpu()
+	mem.usePpu(ppu)
+	apu := newApu(ntscTiming)
+	apu.useMemory(mem)
+	mem.useApu(apu)
 
-	// ppu := newPpu()
-	// apu := newApu()
-	// mem := newMemory()
-	//
-	// // TODO: bring this back
-	// // Set up memory mapped IO
-	// // cpu.useMemory(mem)
-	// // mem.assignMemoryMappedIO(ppu, apu)
-	//
-	// return &nes{
-	// 	cpu: cpu,
-	// 	ppu: ppu,
-	// 	apu: apu,
-	// 	mem: mem,
-	// }
-
-	return &nes{cpu: cpu, disp: disp, input: input, audio: audio}
-}
-
-// OutputTo sets the nes to log its execution to io.Writer w.
+	palette, _ := presetPalette(defaultPalette)
+	return &nes{
+		cpu:     cpu,
+		ppu:     ppu,
+		apu:     apu,
+		mem:     mem,
+		timing:  ntscTiming,
+		speed:   1,
+		rewind:  newRewindBuffer(),
+		region:  regionUnknown,
+		palette: palette,
+		disp:    disp,
+		input:   input,
+		audio:   audio,
+	}
+}
+
+// OutputTo sets the nes to log its execution to io.Writer w, a line per cpu instruction in the
+// format of the nestest log.
 func (n *nes) OutputTo(w io.Writer) {
-	// nes.cpu.OutputTo(w)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cpu.OutputTo(w)
+}
+
+// FrameRGBA returns the last completed frame as RGBA pixels, row by row, in the selected palette.
+func (n *nes) FrameRGBA() []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	rgba := make([]byte, frameSize*4)
+	n.palette.toRGBA(&n.ppu.output, rgba)
+	return rgba
+}
+
+// DrainSamples returns the audio samples output at SampleRate since the last call, for running
+// without the runner, which otherwise plays them.
+func (n *nes) DrainSamples() []float32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.apu.drainSamples()
+}
+
+// SampleRate returns the number of audio samples output per second.
+func (n *nes) SampleRate() int {
+	return sampleRate
+}
+
+// Peek reads address of the cpu memory map, as the cpu would.  Reads of registers may have side
+// effects, but reads of RAM and ROM do not.
+func (n *nes) Peek(address uint16) byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.mem.Read(address)
 }
 
-// Start begins executing the loaded cartridge.
-// For now, this is for nestest.
-// TODO: make this actually start
-func (n *nes) Start() {
-	// nes.cpu.PC = 0xC000
-	// nes.cpu.Step()
-	// nes.cpu.Step()
-	// nes.cpu.Step()
+// Fault returns the error which jammed the cpu, such as an unsupported opcode, or nil if it is
+// running.  Frames still complete while the cpu is jammed, as the rest of the nes keeps running
+// until another rom is loaded.
+func (n *nes) Fault() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cpu.fault
 }
 
 // Reset resets the nes to its initial power up state.
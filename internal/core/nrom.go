@@ -6,18 +6,32 @@ type nrom struct {
 	*cartridge
 }
 
-// readRegister implements mmio.MemoryMappedIO.
-// func (nr *nrom) readRegister(address uint16) (data byte) {
-// 	return nr.readPrg(address)
-// }
-//
-// // writeRegister implements mmio.MemoryMappedIO.
-// func (nr *nrom) writeRegister(address uint16, data byte) {
-// 	nr.writePrg(address, data)
-// }
-//
-// // load implements Mapper.
-// func (nr *nrom) load(bytes []byte) {
-// 	nr.loadPrg(bytes)
-// 	nr.loadChr(bytes)
-// }
+// readRegister implements memoryMappedIO.
+func (nr *nrom) readRegister(address uint16) (data byte) {
+	switch {
+	case address >= prgROMStart:
+		// NROM-128 mirrors its single 16kB bank into $C000-$FFFF
+		return nr.prgROM[int(address-prgROMStart)%len(nr.prgROM)]
+	case address >= prgRAMStart:
+		return nr.readPrgRAM(address)
+	default:
+		return 0x00
+	}
+}
+
+// writeRegister implements memoryMappedIO.
+func (nr *nrom) writeRegister(address uint16, data byte) {
+	if address >= prgRAMStart && address < prgROMStart {
+		nr.writePrgRAM(address, data)
+	}
+}
+
+// readChr implements mapper.
+func (nr *nrom) readChr(address uint16) (data byte) {
+	return nr.readChrMem(int(address))
+}
+
+// writeChr implements mapper.
+func (nr *nrom) writeChr(address uint16, data byte) {
+	nr.writeChrMem(int(address), data)
+}
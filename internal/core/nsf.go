@@ -0,0 +1,581 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/justinawrey/goretro/internal/log"
+)
+
+// NSF file layout
+const (
+	nsfHeaderLen = 0x80
+	nsfBankLen   = 0x1000 // length of a bank switched window
+	nsfStringLen = 32     // length of the title, artist and copyright fields
+)
+
+// NSF player memory map
+const (
+	nsfDriverStart = 0x4100 // start of the player driver
+	nsfTrackReg    = 0x41F0 // reads as the track to initialize
+	nsfRegionReg   = 0x41F1 // reads as 0 for NTSC, or 1 for PAL
+	nsfPlayReg     = 0x41F2 // reads as nonzero when PLAY is due, clearing it
+	nsfBankStart   = 0x5FF6 // first bank switching register ($5FF6-$5FF7 are FDS only)
+	nsfFDSRAMEnd   = 0xDFFF
+)
+
+// NSF default PLAY rates, in microseconds
+const (
+	nsfNTSCSpeed = 16639
+	nsfPALSpeed  = 19997
+)
+
+// NSF expansion chip flags
+const (
+	nsfVRC6      = mask0
+	nsfVRC7      = mask1
+	nsfFDS       = mask2
+	nsfMMC5      = mask3
+	nsfN163      = mask4
+	nsfSunsoft5B = mask5
+)
+
+// NSF magic numbers
+var (
+	nsfMagic  = []byte("NESM\x1A")
+	nsfeMagic = []byte("NSFE")
+)
+
+// nsfDriver is the player driver, mapped at nsfDriverStart.  It initializes the apu, calls
+// INIT with the selected track and region, and then calls PLAY whenever nsfPlayReg says so.
+// The INIT and PLAY addresses are filled in by newNSFPlayer.
+var nsfDriver = []byte{
+	0x78,       // SEI
+	0xD8,       // CLD
+	0xA2, 0xFF, // LDX #$FF
+	0x9A,       // TXS
+	0xA9, 0x00, // LDA #$00
+	0xA2, 0x13, // LDX #$13
+	0x9D, 0x00, 0x40, // STA $4000,X
+	0xCA,       // DEX
+	0x10, 0xFA, // BPL $4109
+	0x8D, 0x15, 0x40, // STA $4015
+	0xA9, 0x0F, // LDA #$0F
+	0x8D, 0x15, 0x40, // STA $4015
+	0xA9, 0x40, // LDA #$40
+	0x8D, 0x17, 0x40, // STA $4017
+	0xAD, 0xF0, 0x41, // LDA nsfTrackReg
+	0xAE, 0xF1, 0x41, // LDX nsfRegionReg
+	0x20, 0x00, 0x00, // JSR INIT
+	0xAD, 0xF2, 0x41, // LDA nsfPlayReg
+	0xF0, 0xFB, // BEQ $4125
+	0x20, 0x00, 0x00, // JSR PLAY
+	0x4C, 0x25, 0x41, // JMP $4125
+	0x40, // RTI (nmi and irq handler)
+}
+
+// Offsets into nsfDriver
+const (
+	nsfDriverInit = 0x23 // operand of JSR INIT
+	nsfDriverPlay = 0x2B // operand of JSR PLAY
+	nsfDriverRTI  = 0x30
+)
+
+// errNSFFileInvalid is an error related to a given NSF or NSFe file being invalid.
+// See https://wiki.nesdev.com/w/index.php/NSF.
+type errNSFFileInvalid string
+
+// Error implements error.
+func (err errNSFFileInvalid) Error() string {
+	return fmt.Sprintf("NSF file invalid: %v", string(err))
+}
+
+// nsfTrack describes a track of an NSF.
+type nsfTrack struct {
+	title    string // empty if unknown
+	duration int    // in milliseconds, or -1 if unknown
+	fade     int    // in milliseconds, or -1 if unknown
+}
+
+// nsf is a decoded NSF or NSFe file.
+type nsf struct {
+	title     string
+	artist    string
+	copyright string
+	ripper    string
+	tracks    []nsfTrack
+	start     int // track played first, counting from 0
+
+	loadAddr  uint16
+	initAddr  uint16
+	playAddr  uint16
+	ntscSpeed uint16 // PLAY period on NTSC, in microseconds
+	palSpeed  uint16 // PLAY period on PAL, in microseconds
+	pal       bool   // whether or not the tune is for PAL only
+	chips     byte   // expansion chip flags
+	bankInit  [8]byte
+	banked    bool
+	data      []byte // program data, starting at loadAddr
+}
+
+// isNSF returns whether or not file is an NSF or NSFe file.
+func isNSF(file []byte) bool {
+	return bytes.HasPrefix(file, nsfMagic) || bytes.HasPrefix(file, nsfeMagic)
+}
+
+// decodeNSF decodes an NSF or NSFe file.
+func decodeNSF(file []byte) (*nsf, error) {
+	n := &nsf{}
+	var err error
+	if bytes.HasPrefix(file, nsfeMagic) {
+		err = n.decodeNSFe(file[len(nsfeMagic):])
+	} else {
+		err = n.decodeNSFHeader(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if n.ntscSpeed == 0 {
+		n.ntscSpeed = nsfNTSCSpeed
+	}
+	if n.palSpeed == 0 {
+		n.palSpeed = nsfPALSpeed
+	}
+	if n.start >= len(n.tracks) {
+		n.start = 0
+	}
+	if !n.banked && n.chips&nsfFDS == 0 && n.loadAddr < prgROMStart {
+		return nil, errNSFFileInvalid(fmt.Sprintf("load address $%04X below $8000", n.loadAddr))
+	}
+
+	return n, nil
+}
+
+// decodeNSFHeader decodes an NSF file, including any NSF2 metadata.
+// See https://wiki.nesdev.com/w/index.php/NSF#Header_Overview.
+func (n *nsf) decodeNSFHeader(file []byte) error {
+	if len(file) < nsfHeaderLen {
+		return errNSFFileInvalid("header less than 128 bytes long")
+	}
+	header := file[:nsfHeaderLen]
+
+	n.tracks = newNSFTracks(int(header[0x06]))
+	n.start = int(header[0x07]) - 1
+	n.loadAddr = binary.LittleEndian.Uint16(header[0x08:])
+	n.initAddr = binary.LittleEndian.Uint16(header[0x0A:])
+	n.playAddr = binary.LittleEndian.Uint16(header[0x0C:])
+	n.title = nullTerminated(header[0x0E : 0x0E+nsfStringLen])
+	n.artist = nullTerminated(header[0x2E : 0x2E+nsfStringLen])
+	n.copyright = nullTerminated(header[0x4E : 0x4E+nsfStringLen])
+	n.ntscSpeed = binary.LittleEndian.Uint16(header[0x6E:])
+	copy(n.bankInit[:], header[0x70:0x78])
+	n.palSpeed = binary.LittleEndian.Uint16(header[0x78:])
+	n.pal = header[0x7A]&mask0 != 0 && header[0x7A]&mask1 == 0
+	n.chips = header[0x7B]
+	n.banked = n.bankInit != [8]byte{}
+
+	// NSF2 files may store NSFe chunks after the program data
+	n.data = file[nsfHeaderLen:]
+	dataLen := int(header[0x7D]) | int(header[0x7E])<<8 | int(header[0x7F])<<16
+	if header[0x05] >= 2 && dataLen != 0 && dataLen <= len(n.data) {
+		metadata := n.data[dataLen:]
+		n.data = n.data[:dataLen]
+		return n.decodeChunks(metadata)
+	}
+
+	return nil
+}
+
+// decodeNSFe decodes the chunks of an NSFe file.
+// See https://wiki.nesdev.com/w/index.php/NSFe.
+func (n *nsf) decodeNSFe(chunks []byte) error {
+	if err := n.decodeChunks(chunks); err != nil {
+		return err
+	}
+	if n.tracks == nil {
+		return errNSFFileInvalid("missing INFO chunk")
+	}
+	if n.data == nil {
+		return errNSFFileInvalid("missing DATA chunk")
+	}
+	return nil
+}
+
+// decodeChunks decodes NSFe chunks into n.  Chunks whose id starts with a lowercase letter
+// are optional, and unknown ones are skipped.
+func (n *nsf) decodeChunks(chunks []byte) error {
+	var titles []string
+	var durations, fades []int
+
+	for len(chunks) >= 8 {
+		size := int(binary.LittleEndian.Uint32(chunks))
+		id := string(chunks[4:8])
+		chunks = chunks[8:]
+		if size > len(chunks) {
+			return errNSFFileInvalid(fmt.Sprintf("%v chunk truncated", id))
+		}
+		data := chunks[:size]
+		chunks = chunks[size:]
+
+		switch id {
+		case "INFO":
+			if len(data) < 9 {
+				return errNSFFileInvalid("INFO chunk less than 9 bytes long")
+			}
+			n.loadAddr = binary.LittleEndian.Uint16(data[0:])
+			n.initAddr = binary.LittleEndian.Uint16(data[2:])
+			n.playAddr = binary.LittleEndian.Uint16(data[4:])
+			n.pal = data[6]&mask0 != 0 && data[6]&mask1 == 0
+			n.chips = data[7]
+			n.tracks = newNSFTracks(int(data[8]))
+			if len(data) > 9 {
+				n.start = int(data[9])
+			}
+		case "DATA":
+			n.data = data
+		case "BANK":
+			copy(n.bankInit[:], data)
+			n.banked = n.bankInit != [8]byte{}
+		case "RATE":
+			if len(data) >= 2 {
+				n.ntscSpeed = binary.LittleEndian.Uint16(data[0:])
+			}
+			if len(data) >= 4 {
+				n.palSpeed = binary.LittleEndian.Uint16(data[2:])
+			}
+		case "auth":
+			fields := nsfStrings(data)
+			for i, field := range []*string{&n.title, &n.artist, &n.copyright, &n.ripper} {
+				if i < len(fields) {
+					*field = fields[i]
+				}
+			}
+		case "tlbl":
+			titles = nsfStrings(data)
+		case "time":
+			durations = nsfInts(data)
+		case "fade":
+			fades = nsfInts(data)
+		case "NEND":
+			chunks = nil
+		default:
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return errNSFFileInvalid(fmt.Sprintf("unsupported %v chunk", id))
+			}
+		}
+	}
+
+	for i := range n.tracks {
+		if i < len(titles) {
+			n.tracks[i].title = titles[i]
+		}
+		if i < len(durations) {
+			n.tracks[i].duration = durations[i]
+		}
+		if i < len(fades) {
+			n.tracks[i].fade = fades[i]
+		}
+	}
+
+	return nil
+}
+
+// newNSFTracks creates count tracks of unknown title and duration.
+func newNSFTracks(count int) []nsfTrack {
+	count = max(count, 1)
+	tracks := make([]nsfTrack, count)
+	for i := range tracks {
+		tracks[i] = nsfTrack{duration: -1, fade: -1}
+	}
+	return tracks
+}
+
+// nsfStrings splits data into its null terminated strings.
+func nsfStrings(data []byte) []string {
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return nil
+	}
+
+	var fields []string
+	for _, field := range bytes.Split(data, []byte{0}) {
+		fields = append(fields, string(field))
+	}
+	return fields
+}
+
+// nsfInts decodes data as a list of little endian 32 bit signed integers.
+func nsfInts(data []byte) []int {
+	var ints []int
+	for ; len(data) >= 4; data = data[4:] {
+		ints = append(ints, int(int32(binary.LittleEndian.Uint32(data))))
+	}
+	return ints
+}
+
+// nsfPlayer maps an NSF into the cpu address space, standing in for a cartridge.  It provides
+// the player driver, bank switching through $5FF8-$5FFF, prgRAM and the expansion chips the NSF
+// uses.  Its interrupt vectors point into the driver.
+type nsfPlayer struct {
+	*nsf
+
+	driver []byte // nsfDriver, calling the NSF's INIT and PLAY
+	track  int    // track being played, counting from 0
+	image  []byte // program data, padded so that it starts at the correct offset within its bank
+	banks  [8]int // bank mapped at each 4kB window of $8000-$FFFF
+	prgRAM [0x2000]byte
+	fdsRAM []byte // 40kB of RAM at $6000-$FFFF, replacing prgRAM and banks if the FDS is used
+
+	// PLAY timing, in microseconds * the cpu clock rate
+	timing     *timing // region the tune is played as
+	playPeriod int64
+	playTimer  int64
+	playDue    bool
+
+	// expansion chips, nil if unused
+	fdsSound *fdsAudio
+	vrc6     *vrc6Audio
+	mmc5     *mmc5Audio
+	exRAM    [0x400]byte // MMC5 $5C00-$5FF5
+	mulA     byte        // MMC5 $5205
+	mulB     byte        // MMC5 $5206
+
+	unsupported []string // names of the expansion chips the NSF uses which are not emulated, and are silent
+}
+
+// newNSFPlayer creates a player for n, ready to play its starting track.
+func newNSFPlayer(n *nsf) *nsfPlayer {
+	p := &nsfPlayer{nsf: n}
+	p.driver = bytes.Clone(nsfDriver)
+	binary.LittleEndian.PutUint16(p.driver[nsfDriverInit:], n.initAddr)
+	binary.LittleEndian.PutUint16(p.driver[nsfDriverPlay:], n.playAddr)
+
+	// Pad the image so that bank numbers and windows line up with the load address
+	var pad int
+	switch {
+	case n.banked:
+		pad = int(n.loadAddr & (nsfBankLen - 1))
+	case n.chips&nsfFDS != 0:
+		pad = int(n.loadAddr) - prgRAMStart
+	default:
+		pad = int(n.loadAddr) - prgROMStart
+	}
+	p.image = append(make([]byte, max(pad, 0)), n.data...)
+
+	for _, chip := range []struct {
+		flag byte
+		name string
+	}{{nsfVRC7, "VRC7"}, {nsfN163, "Namco 163"}, {nsfSunsoft5B, "Sunsoft 5B"}} {
+		if n.chips&chip.flag != 0 {
+			p.unsupported = append(p.unsupported, chip.name)
+			log.Log(fmt.Sprintf("NSF expansion audio unsupported: %v", chip.name))
+		}
+	}
+
+	p.useTiming(regionTiming(n.region()))
+	p.reset(n.start)
+	return p
+}
+
+// region returns the region the tune is made for.  Tunes for both regions play as NTSC.
+func (n *nsf) region() region {
+	if n.pal {
+		return regionPAL
+	}
+	return regionNTSC
+}
+
+// useTiming plays the tune as the region of t, at its PLAY period for that region.
+func (p *nsfPlayer) useTiming(t *timing) {
+	p.timing = t
+	speed := p.ntscSpeed
+	if t.region == regionPAL {
+		speed = p.palSpeed
+	}
+	p.playPeriod = int64(speed) * int64(t.cpuClockRate)
+}
+
+// reset prepares the player to play track, clearing RAM, restoring the initial banks and
+// expansion chips.  The cpu must be reset afterwards so that the driver calls INIT.
+func (p *nsfPlayer) reset(track int) {
+	p.track = track
+	p.prgRAM = [len(p.prgRAM)]byte{}
+	p.exRAM = [len(p.exRAM)]byte{}
+	p.playTimer, p.playDue = 0, false
+
+	p.fdsSound, p.vrc6, p.mmc5 = nil, nil, nil
+	if p.chips&nsfFDS != 0 {
+		p.fdsSound = newFdsAudio()
+		p.fdsRAM = make([]byte, 0xA000)
+	}
+	if p.chips&nsfVRC6 != 0 {
+		p.vrc6 = &vrc6Audio{}
+	}
+	if p.chips&nsfMMC5 != 0 {
+		p.mmc5 = newMmc5Audio()
+	}
+
+	for window := range p.banks {
+		bank := byte(window)
+		switch {
+		case p.banked:
+			bank = p.bankInit[window]
+		case p.fdsRAM != nil:
+			// The image of an unbanked FDS tune starts at $6000 rather than $8000
+			bank += 2
+		}
+		p.switchBank(window+2, bank)
+	}
+	if p.fdsRAM != nil {
+		// $6000-$7FFF hold the banks of $5FF6-$5FF7, which are initialized from $5FFE-$5FFF
+		banks := [2]byte{0, 1}
+		if p.banked {
+			banks = [2]byte{p.bankInit[6], p.bankInit[7]}
+		}
+		p.switchBank(0, banks[0])
+		p.switchBank(1, banks[1])
+	}
+}
+
+// switchBank maps bank into window, where window 0 is $6000-$6FFF and window 9 is $F000-$FFFF.
+// With the FDS, the bank is copied into RAM.  Otherwise, windows 0 and 1 are not bank switched.
+func (p *nsfPlayer) switchBank(window int, bank byte) {
+	if p.fdsRAM != nil {
+		dest := p.fdsRAM[window*nsfBankLen : (window+1)*nsfBankLen]
+		clear(dest)
+		if start := int(bank) * nsfBankLen; start < len(p.image) {
+			copy(dest, p.image[start:])
+		}
+		return
+	}
+
+	if window >= 2 {
+		p.banks[window-2] = int(bank)
+	}
+}
+
+// readBank reads the byte at address within the bank switched windows of $8000-$FFFF.
+func (p *nsfPlayer) readBank(address uint16) (data byte) {
+	bank := p.banks[(address-prgROMStart)/nsfBankLen]
+	offset := bank*nsfBankLen + int(address%nsfBankLen)
+	if offset < len(p.image) {
+		return p.image[offset]
+	}
+	return 0x00
+}
+
+// readRegister implements memoryMappedIO.
+func (p *nsfPlayer) readRegister(address uint16) (data byte) {
+	switch {
+	case address >= nsfDriverStart && int(address) < nsfDriverStart+len(p.driver):
+		return p.driver[address-nsfDriverStart]
+	case address == nsfTrackReg:
+		return byte(p.track)
+	case address == nsfRegionReg:
+		if p.timing.region == regionPAL {
+			return 1
+		}
+		return 0
+	case address == nsfPlayReg:
+		due := p.playDue
+		p.playDue = false
+		if due {
+			return 1
+		}
+		return 0
+	case p.fdsSound != nil && address >= 0x4040 && address <= fdsAudioEnd:
+		return p.fdsSound.readRegister(address)
+	case p.mmc5 != nil && address == 0x5015:
+		return p.mmc5.readRegister(address)
+	case p.mmc5 != nil && address == 0x5205:
+		return byte(uint16(p.mulA) * uint16(p.mulB))
+	case p.mmc5 != nil && address == 0x5206:
+		return byte(uint16(p.mulA) * uint16(p.mulB) >> 8)
+	case p.mmc5 != nil && address >= 0x5C00 && address < nsfBankStart:
+		return p.exRAM[address-0x5C00]
+	case address >= nmiVector:
+		// Interrupts are handled by the driver
+		vector := uint16(nsfDriverStart + nsfDriverRTI)
+		if address&^1 == rstVector {
+			vector = nsfDriverStart
+		}
+		return byte(vector >> (8 * (address & 1)))
+	case p.fdsRAM != nil && address >= prgRAMStart:
+		return p.fdsRAM[address-prgRAMStart]
+	case address >= prgROMStart:
+		return p.readBank(address)
+	case address >= prgRAMStart:
+		return p.prgRAM[address-prgRAMStart]
+	default:
+		return 0x00
+	}
+}
+
+// writeRegister implements memoryMappedIO.
+func (p *nsfPlayer) writeRegister(address uint16, data byte) {
+	switch {
+	case address >= nsfBankStart && address < prgRAMStart:
+		if p.fdsRAM != nil || address >= nsfBankStart+2 {
+			p.switchBank(int(address-nsfBankStart), data)
+		}
+	case p.fdsSound != nil && address >= 0x4040 && address <= fdsAudioEnd:
+		p.fdsSound.writeRegister(address, data)
+	case p.mmc5 != nil && address >= 0x5000 && address <= 0x5015:
+		p.mmc5.writeRegister(address, data)
+	case p.mmc5 != nil && address == 0x5205:
+		p.mulA = data
+	case p.mmc5 != nil && address == 0x5206:
+		p.mulB = data
+	case p.mmc5 != nil && address >= 0x5C00 && address < nsfBankStart:
+		p.exRAM[address-0x5C00] = data
+	case p.vrc6 != nil && p.vrc6.isRegister(address):
+		p.vrc6.writeRegister(address, data)
+	case p.fdsRAM != nil && address >= prgRAMStart && address <= nsfFDSRAMEnd:
+		p.fdsRAM[address-prgRAMStart] = data
+	case p.fdsRAM == nil && address >= prgRAMStart && address < prgROMStart:
+		p.prgRAM[address-prgRAMStart] = data
+	}
+}
+
+// readChr implements mapper.  NSFs have no chr memory.
+func (p *nsfPlayer) readChr(address uint16) (data byte) {
+	return 0x00
+}
+
+// writeChr implements mapper.  NSFs have no chr memory.
+func (p *nsfPlayer) writeChr(address uint16, data byte) {}
+
+// clock clocks the PLAY timer and expansion chips once per cpu cycle.
+func (p *nsfPlayer) clock() {
+	p.playTimer += 1_000_000
+	if p.playTimer >= p.playPeriod {
+		p.playTimer -= p.playPeriod
+		p.playDue = true
+	}
+
+	if p.fdsSound != nil {
+		p.fdsSound.clock()
+	}
+	if p.vrc6 != nil {
+		p.vrc6.clock()
+	}
+	if p.mmc5 != nil {
+		p.mmc5.clock()
+	}
+}
+
+// audioOutput implements expansionAudio.
+func (p *nsfPlayer) audioOutput() (level float32) {
+	if p.fdsSound != nil {
+		level += p.fdsSound.audioOutput()
+	}
+	if p.vrc6 != nil {
+		level += p.vrc6.audioOutput()
+	}
+	if p.mmc5 != nil {
+		level += p.mmc5.audioOutput()
+	}
+	return level
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// nsfProgram is loaded at $8000.  INIT stores the track in $00, and PLAY increments $01.
+var nsfProgram = []byte{
+	0x85, 0x00, // INIT: STA $00
+	0x60,       // RTS
+	0xE6, 0x01, // PLAY: INC $01
+	0x60, // RTS
+}
+
+// nsfeChunk encodes an NSFe chunk.
+func nsfeChunk(id string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	return append(append(chunk, id...), data...)
+}
+
+// writeNSFe writes an NSFe of nsfProgram with 3 tracks, using the expansion chips flagged in chips.
+func writeNSFe(t *testing.T, chips byte) string {
+	t.Helper()
+
+	info := []byte{0x00, 0x80, 0x00, 0x80, 0x03, 0x80, 0, chips, 3, 1}
+	file := append([]byte{}, nsfeMagic...)
+	file = append(file, nsfeChunk("INFO", info)...)
+	file = append(file, nsfeChunk("DATA", nsfProgram)...)
+	file = append(file, nsfeChunk("tlbl", []byte("Title\x00Stage\x00Ending\x00"))...)
+	file = append(file, nsfeChunk("time", binary.LittleEndian.AppendUint32(nil, 90000))...)
+	file = append(file, nsfeChunk("NEND", nil)...)
+
+	path := filepath.Join(t.TempDir(), "tune.nsfe")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNSFPlayer(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseNSF(writeNSFe(t, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := n.TrackCount(); got != 3 {
+		t.Fatalf("track count: want 3, got %v", got)
+	}
+	if got := n.Track(); got != 1 {
+		t.Errorf("starting track: want 1, got %v", got)
+	}
+	if got := n.TrackTitle(2); got != "Ending" {
+		t.Errorf("track title: want Ending, got %q", got)
+	}
+	if got := n.TrackDuration(0); got != 90000 {
+		t.Errorf("track duration: want 90000, got %v", got)
+	}
+	if got := n.TrackDuration(1); got != -1 {
+		t.Errorf("unknown track duration: want -1, got %v", got)
+	}
+
+	if err := n.SelectTrack(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Play for just over 5 PLAY periods
+	const plays = 5
+	cycles := plays*nsfNTSCSpeed*ntscTiming.cpuClockRate/1_000_000 + 1000
+	for n.cpu.cycles < cycles {
+		n.step()
+	}
+
+	if got := n.mem.internal[0]; got != 2 {
+		t.Errorf("INIT track: want 2, got %v", got)
+	}
+	if got := n.mem.internal[1]; got != plays {
+		t.Errorf("PLAY calls: want %v, got %v", plays, got)
+	}
+}
+
+func TestNSFUnsupportedChips(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if got := n.UnsupportedChips(); got != nil {
+		t.Errorf("unsupported chips without an NSF: want none, got %v", got)
+	}
+
+	if err := n.UseNSF(writeNSFe(t, nsfVRC6|nsfVRC7|nsfSunsoft5B)); err != nil {
+		t.Fatal(err)
+	}
+	if got := n.UnsupportedChips(); !slices.Equal(got, []string{"VRC7", "Sunsoft 5B"}) {
+		t.Errorf("unsupported chips: want VRC7 and Sunsoft 5B, got %v", got)
+	}
+}
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// System palette dimensions.  Entries are indexed by the 3 emphasis bits of ctrl2 followed by
+// the 6 bit color read from palette RAM.
+// See https://wiki.nesdev.com/w/index.php/PPU_palettes.
+const (
+	paletteColors    = 64
+	emphasisVariants = 8
+	paletteEntries   = paletteColors * emphasisVariants
+	paletteFileLen   = paletteColors * 3  // length of a .pal file without emphasis variants
+	paletteFullLen   = paletteEntries * 3 // length of a .pal file with emphasis variants
+)
+
+// emphasisAttenuation is the factor by which emphasis darkens the channels it does not emphasize.
+const emphasisAttenuation = 0.816328
+
+// defaultPalette is the name of the palette used until another is selected.
+const defaultPalette = "2C02"
+
+// rgb is a 24 bit color.
+type rgb struct {
+	r, g, b byte
+}
+
+// systemPalette maps each color the ppu outputs, including emphasis, to rgb.
+type systemPalette [paletteEntries]rgb
+
+// errPaletteInvalid is an error related to a palette being unknown or malformed.
+type errPaletteInvalid string
+
+// Error implements error.
+func (err errPaletteInvalid) Error() string {
+	return fmt.Sprintf("palette invalid: %v", string(err))
+}
+
+// paletteNames are the names of the builtin palettes, in the order they are offered.
+var paletteNames = []string{defaultPalette, "FCEUX", "Smooth FBX"}
+
+// palettePresets are the builtin palettes, without emphasis variants.
+var palettePresets = map[string][paletteColors]rgb{
+	// Measured from an NTSC 2C02
+	defaultPalette: {
+		{0x54, 0x54, 0x54}, {0x00, 0x1E, 0x74}, {0x08, 0x10, 0x90}, {0x30, 0x00, 0x88},
+		{0x44, 0x00, 0x64}, {0x5C, 0x00, 0x30}, {0x54, 0x04, 0x00}, {0x3C, 0x18, 0x00},
+		{0x20, 0x2A, 0x00}, {0x08, 0x3A, 0x00}, {0x00, 0x40, 0x00}, {0x00, 0x3C, 0x00},
+		{0x00, 0x32, 0x3C}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0x98, 0x96, 0x98}, {0x08, 0x4C, 0xC4}, {0x30, 0x32, 0xEC}, {0x5C, 0x1E, 0xE4},
+		{0x88, 0x14, 0xB0}, {0xA0, 0x14, 0x64}, {0x98, 0x22, 0x20}, {0x78, 0x3C, 0x00},
+		{0x54, 0x5A, 0x00}, {0x28, 0x72, 0x00}, {0x08, 0x7C, 0x00}, {0x00, 0x76, 0x28},
+		{0x00, 0x66, 0x78}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xEC, 0xEE, 0xEC}, {0x4C, 0x9A, 0xEC}, {0x78, 0x7C, 0xEC}, {0xB0, 0x62, 0xEC},
+		{0xE4, 0x54, 0xEC}, {0xEC, 0x58, 0xB4}, {0xEC, 0x6A, 0x64}, {0xD4, 0x88, 0x20},
+		{0xA0, 0xAA, 0x00}, {0x74, 0xC4, 0x00}, {0x4C, 0xD0, 0x20}, {0x38, 0xCC, 0x6C},
+		{0x38, 0xB4, 0xCC}, {0x3C, 0x3C, 0x3C}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xEC, 0xEE, 0xEC}, {0xA8, 0xCC, 0xEC}, {0xBC, 0xBC, 0xEC}, {0xD4, 0xB2, 0xEC},
+		{0xEC, 0xAE, 0xEC}, {0xEC, 0xAE, 0xD4}, {0xEC, 0xB4, 0xB0}, {0xE4, 0xC4, 0x90},
+		{0xCC, 0xD2, 0x78}, {0xB4, 0xDE, 0x78}, {0xA8, 0xE2, 0x90}, {0x98, 0xE2, 0xB4},
+		{0xA0, 0xD6, 0xE4}, {0xA0, 0xA2, 0xA0}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+	},
+	// The default palette of FCEUX
+	"FCEUX": {
+		{0x74, 0x74, 0x74}, {0x24, 0x18, 0x8C}, {0x00, 0x00, 0xA8}, {0x44, 0x00, 0x9C},
+		{0x8C, 0x00, 0x74}, {0xA8, 0x00, 0x10}, {0xA4, 0x00, 0x00}, {0x7C, 0x08, 0x00},
+		{0x40, 0x2C, 0x00}, {0x00, 0x44, 0x00}, {0x00, 0x50, 0x00}, {0x00, 0x3C, 0x14},
+		{0x18, 0x3C, 0x5C}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xBC, 0xBC, 0xBC}, {0x00, 0x70, 0xEC}, {0x20, 0x38, 0xEC}, {0x80, 0x00, 0xF0},
+		{0xBC, 0x00, 0xBC}, {0xE4, 0x00, 0x58}, {0xD8, 0x28, 0x00}, {0xC8, 0x4C, 0x0C},
+		{0x88, 0x70, 0x00}, {0x00, 0x94, 0x00}, {0x00, 0xA8, 0x00}, {0x00, 0x90, 0x38},
+		{0x00, 0x80, 0x88}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xFC, 0xFC, 0xFC}, {0x3C, 0xBC, 0xFC}, {0x5C, 0x94, 0xFC}, {0xCC, 0x88, 0xFC},
+		{0xF4, 0x78, 0xFC}, {0xFC, 0x74, 0xB4}, {0xFC, 0x74, 0x60}, {0xFC, 0x98, 0x38},
+		{0xF0, 0xBC, 0x3C}, {0x80, 0xD0, 0x10}, {0x4C, 0xDC, 0x48}, {0x58, 0xF8, 0x98},
+		{0x00, 0xE8, 0xD8}, {0x78, 0x78, 0x78}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xFC, 0xFC, 0xFC}, {0xA8, 0xE4, 0xFC}, {0xC4, 0xD4, 0xFC}, {0xD4, 0xC8, 0xFC},
+		{0xFC, 0xC4, 0xFC}, {0xFC, 0xC4, 0xD8}, {0xFC, 0xBC, 0xB0}, {0xFC, 0xD8, 0xA8},
+		{0xFC, 0xE4, 0xA0}, {0xE0, 0xFC, 0xA0}, {0xA8, 0xF0, 0xBC}, {0xB0, 0xFC, 0xCC},
+		{0x9C, 0xFC, 0xF0}, {0xC4, 0xC4, 0xC4}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+	},
+	// FirebrandX's Smooth palette
+	"Smooth FBX": {
+		{0x6A, 0x6D, 0x6A}, {0x00, 0x13, 0x80}, {0x1E, 0x00, 0x8A}, {0x39, 0x00, 0x7A},
+		{0x55, 0x00, 0x56}, {0x5A, 0x00, 0x18}, {0x4F, 0x10, 0x00}, {0x3D, 0x1C, 0x00},
+		{0x25, 0x32, 0x00}, {0x00, 0x3D, 0x00}, {0x00, 0x40, 0x00}, {0x00, 0x39, 0x24},
+		{0x00, 0x2E, 0x55}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xB9, 0xBC, 0xB9}, {0x18, 0x50, 0xC7}, {0x4B, 0x30, 0xE3}, {0x73, 0x22, 0xD6},
+		{0x95, 0x1F, 0xA9}, {0x9D, 0x28, 0x5C}, {0x98, 0x37, 0x00}, {0x7F, 0x4C, 0x00},
+		{0x5E, 0x64, 0x00}, {0x22, 0x77, 0x00}, {0x02, 0x7E, 0x02}, {0x00, 0x76, 0x45},
+		{0x00, 0x6E, 0x8A}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xFF, 0xFF, 0xFF}, {0x68, 0xA6, 0xFF}, {0x8C, 0x9C, 0xFF}, {0xB5, 0x86, 0xFF},
+		{0xD9, 0x75, 0xFD}, {0xE3, 0x77, 0xB9}, {0xE5, 0x8D, 0x68}, {0xD4, 0x9D, 0x29},
+		{0xB3, 0xAF, 0x0C}, {0x7B, 0xC2, 0x11}, {0x55, 0xCA, 0x47}, {0x46, 0xCB, 0x81},
+		{0x47, 0xC1, 0xC5}, {0x4A, 0x4D, 0x4A}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+		{0xFF, 0xFF, 0xFF}, {0xCC, 0xEA, 0xFF}, {0xDD, 0xDE, 0xFF}, {0xEC, 0xDA, 0xFF},
+		{0xF8, 0xD7, 0xFE}, {0xFC, 0xD6, 0xF5}, {0xFD, 0xDB, 0xCF}, {0xF9, 0xE7, 0xB5},
+		{0xF1, 0xF0, 0xAA}, {0xDA, 0xFA, 0xA9}, {0xC9, 0xFF, 0xBC}, {0xC3, 0xFB, 0xD7},
+		{0xC4, 0xF6, 0xF6}, {0xBE, 0xC1, 0xBE}, {0x00, 0x00, 0x00}, {0x00, 0x00, 0x00},
+	},
+}
+
+// presetPalette returns the builtin palette called name.
+func presetPalette(name string) (*systemPalette, error) {
+	colors, ok := palettePresets[name]
+	if !ok {
+		return nil, errPaletteInvalid(fmt.Sprintf("no builtin palette %q", name))
+	}
+	return emphasize(colors[:]), nil
+}
+
+// emphasize returns the palette of colors, approximating its emphasis variants by darkening
+// the channels each does not emphasize.  colors must hold paletteColors entries.
+func emphasize(colors []rgb) *systemPalette {
+	s := &systemPalette{}
+	for emphasis := range emphasisVariants {
+		for i, c := range colors {
+			// Emphasis bits are red, green and blue from least significant
+			channels := [3]*byte{&c.r, &c.g, &c.b}
+			for bit, channel := range channels {
+				if emphasis != 0 && emphasis&(1<<bit) == 0 {
+					*channel = byte(float64(*channel) * emphasisAttenuation)
+				}
+			}
+			s[emphasis*paletteColors+i] = c
+		}
+	}
+	return s
+}
+
+// loadPalette loads the .pal file at path.  Files of 64 colors have their emphasis variants
+// approximated, while files of 512 colors give them explicitly.
+func loadPalette(path string) (*systemPalette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != paletteFileLen && len(data) != paletteFullLen {
+		return nil, errPaletteInvalid(fmt.Sprintf("%v is %v bytes, want %v or %v", path, len(data), paletteFileLen, paletteFullLen))
+	}
+
+	colors := make([]rgb, len(data)/3)
+	for i := range colors {
+		colors[i] = rgb{data[i*3], data[i*3+1], data[i*3+2]}
+	}
+	if len(colors) == paletteColors {
+		return emphasize(colors), nil
+	}
+	s := &systemPalette{}
+	copy(s[:], colors)
+	return s, nil
+}
+
+// Composite signal levels of the ppu, low and high for each of the 4 luma levels of a color.
+// See https://wiki.nesdev.com/w/index.php/NTSC_video.
+var (
+	signalLow  = [4]float64{0.350, 0.518, 0.962, 1.550}
+	signalHigh = [4]float64{1.094, 1.506, 1.962, 1.962}
+)
+
+// Composite signal constants
+const (
+	signalBlack       = 0.518 // signal level of black
+	signalWhite       = 1.962 // signal level of white
+	signalEmphasis    = 0.746 // factor by which emphasis attenuates the signal
+	signalPhases      = 12    // phases of the color subcarrier per cycle
+	signalHueOffset   = 3.9   // phase offset aligning decoded hues with those of a typical television
+	emphasisPhaseRed  = 0     // color whose phases red emphasis attenuates
+	emphasisPhaseStep = 4     // colors between the phases of each emphasis bit
+)
+
+// generatePalette generates a palette by decoding the composite signal the ppu outputs for each
+// color.  hue rotates the hues, in degrees, and saturation scales the saturation, where 1 is
+// the saturation of a typical television.
+func generatePalette(hue, saturation float64) *systemPalette {
+	s := &systemPalette{}
+	for emphasis := range emphasisVariants {
+		for color := range paletteColors {
+			s[emphasis*paletteColors+color] = decodeSignal(color, emphasis, hue, saturation)
+		}
+	}
+	return s
+}
+
+// decodeSignal decodes the composite signal the ppu outputs for color with emphasis into rgb.
+// The signal is high during half of the phases of the subcarrier, offset by the hue of the color,
+// and low during the rest.  Colors 0 and $D are the high and low level of their luma throughout,
+// while $E and $F are black.
+func decodeSignal(color, emphasis int, hue, saturation float64) rgb {
+	luma, chroma := color>>4, color&0x0F
+	low, high := signalLow[luma], signalHigh[luma]
+	switch {
+	case chroma == 0x00:
+		low = high
+	case chroma == 0x0D:
+		high = low
+	case chroma > 0x0D:
+		low, high = signalBlack, signalBlack
+	}
+
+	inPhase := func(chroma, phase int) bool {
+		return (chroma+phase)%signalPhases < signalPhases/2
+	}
+
+	var y, i, q float64
+	for phase := range signalPhases {
+		level := low
+		if inPhase(chroma, phase) {
+			level = high
+		}
+		for bit := range 3 {
+			if emphasis&(1<<bit) != 0 && chroma < 0x0E && inPhase(emphasisPhaseRed+bit*emphasisPhaseStep, phase) {
+				level *= signalEmphasis
+				break
+			}
+		}
+
+		level = (level - signalBlack) / (signalWhite - signalBlack) / signalPhases
+		angle := math.Pi * (float64(phase) + signalHueOffset + hue/360*signalPhases) / (signalPhases / 2)
+		y += level
+		i += level * math.Cos(angle)
+		q += level * math.Sin(angle)
+	}
+	i *= 2 * saturation
+	q *= 2 * saturation
+
+	// YIQ to rgb, as defined by the FCC
+	return rgb{
+		r: clampColor(y + 0.946882*i + 0.623557*q),
+		g: clampColor(y - 0.274788*i - 0.635691*q),
+		b: clampColor(y - 1.108545*i + 1.709007*q),
+	}
+}
+
+// clampColor converts a color channel in the range 0-1 to a byte, clamping it to that range.
+func clampColor(level float64) byte {
+	return byte(math.Round(255 * min(max(level, 0), 1)))
+}
+
+// toRGBA converts frame, as output by the ppu, into RGBA pixels in dst, which must hold
+// frameSize*4 bytes.
+func (s *systemPalette) toRGBA(frame *[frameSize]uint16, dst []byte) {
+	for i, index := range frame {
+		c := s[index%paletteEntries]
+		dst[i*4], dst[i*4+1], dst[i*4+2], dst[i*4+3] = c.r, c.g, c.b, 0xFF
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPalette(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	data := make([]byte, paletteFullLen)
+	for i := range data {
+		data[i] = byte(i / 3)
+	}
+
+	// 64 color files have their emphasis variants approximated
+	s, err := loadPalette(write("short.pal", data[:paletteFileLen]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s[0x3F], (rgb{0x3F, 0x3F, 0x3F}); got != want {
+		t.Errorf("color $3F: want %v, got %v", want, got)
+	}
+	if got, want := s[mask0<<6|0x3F], (rgb{0x3F, 0x33, 0x33}); got != want {
+		t.Errorf("color $3F with red emphasis: want %v, got %v", want, got)
+	}
+
+	// 512 color files give them explicitly
+	s, err = loadPalette(write("full.pal", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s[mask0<<6|0x3F], (rgb{0x7F, 0x7F, 0x7F}); got != want {
+		t.Errorf("color $3F with red emphasis: want %v, got %v", want, got)
+	}
+
+	if _, err := loadPalette(write("bad.pal", data[:100])); err == nil {
+		t.Errorf("want error loading a 100 byte palette")
+	}
+}
+
+func TestGeneratePalette(t *testing.T) {
+	s := generatePalette(0, 1)
+	red, green, blue := s[0x16], s[0x1A], s[0x12]
+	if red.r <= red.g || red.r <= red.b || green.g <= green.r || green.g <= green.b || blue.b <= blue.r || blue.b <= blue.g {
+		t.Errorf("want red, green and blue for colors $16, $1A and $12, got %v, %v and %v", red, green, blue)
+	}
+	if s[0x20] != (rgb{0xFF, 0xFF, 0xFF}) || s[0x0F] != (rgb{}) {
+		t.Errorf("want white and black for colors $20 and $0F, got %v and %v", s[0x20], s[0x0F])
+	}
+
+	if gray := generatePalette(0, 0)[0x16]; gray.r != gray.g || gray.g != gray.b {
+		t.Errorf("want gray without saturation, got %v", gray)
+	}
+}
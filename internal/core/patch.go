@@ -0,0 +1,419 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Patch file magic numbers
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEOF   = []byte("EOF")
+	bpsMagic = []byte("BPS1")
+	upsMagic = []byte("UPS1")
+)
+
+// patchFooterLen is the length of the crc32 footer of bps and ups patches
+// (source, target and patch crc32s).
+const patchFooterLen = 12
+
+// patchExts are the extensions of patch files detected next to a rom, in order of preference.
+var patchExts = []string{".ips", ".bps", ".ups"}
+
+// errPatchInvalid is an error related to a patch file being malformed.
+type errPatchInvalid string
+
+func newErrPatchInvalid(format, message string) errPatchInvalid {
+	return errPatchInvalid(fmt.Sprintf("%v: %v", format, message))
+}
+
+// Error implements error.
+func (err errPatchInvalid) Error() string {
+	return fmt.Sprintf("patch invalid: %v", string(err))
+}
+
+// errPatchChecksum is an error related to a checksum of a bps or ups patch not matching.
+// This usually means the patch is being applied to the wrong rom.
+type errPatchChecksum string
+
+func newErrPatchChecksum(format, which string, want, got uint32) errPatchChecksum {
+	return errPatchChecksum(fmt.Sprintf("%v %v crc32: want %08X, got %08X", format, which, want, got))
+}
+
+// Error implements error.
+func (err errPatchChecksum) Error() string {
+	return fmt.Sprintf("patch checksum mismatch: %v", string(err))
+}
+
+// findPatch returns the path of a patch with the same name as the rom at romPath
+// and residing next to it, or an empty string if there is none.
+func findPatch(romPath string) (string, error) {
+	base := strings.TrimSuffix(romPath, filepath.Ext(romPath))
+	for _, ext := range patchExts {
+		path := base + ext
+		_, err := os.Stat(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return "", err
+		default:
+			return path, nil
+		}
+	}
+
+	return "", nil
+}
+
+// applyPatchFile applies the patch at path to rom, returning the patched rom.
+// The patch format (ips, bps or ups) is detected from the patch contents.
+// rom is never modified.
+func applyPatchFile(rom []byte, path string) ([]byte, error) {
+	patch, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(patch, ipsMagic):
+		return applyIPS(rom, patch)
+	case bytes.HasPrefix(patch, bpsMagic):
+		return applyBPS(rom, patch)
+	case bytes.HasPrefix(patch, upsMagic):
+		return applyUPS(rom, patch)
+	default:
+		return nil, newErrPatchInvalid(filepath.Base(path), "unknown patch format")
+	}
+}
+
+// applyIPS applies an ips patch to rom, returning the patched rom.
+// See https://zerosoft.zophar.net/ips.php.
+func applyIPS(rom, patch []byte) ([]byte, error) {
+	out := bytes.Clone(rom)
+	pos := len(ipsMagic)
+
+	for {
+		if pos+len(ipsEOF) <= len(patch) && bytes.Equal(patch[pos:pos+len(ipsEOF)], ipsEOF) {
+			pos += len(ipsEOF)
+			break
+		}
+		if pos+5 > len(patch) {
+			return nil, newErrPatchInvalid("ips", "unexpected end of patch")
+		}
+
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		size := int(binary.BigEndian.Uint16(patch[pos+3:]))
+		pos += 5
+
+		var data []byte
+		if size == 0 {
+			// run length encoded record
+			if pos+3 > len(patch) {
+				return nil, newErrPatchInvalid("ips", "unexpected end of patch")
+			}
+			size = int(binary.BigEndian.Uint16(patch[pos:]))
+			data = bytes.Repeat(patch[pos+2:pos+3], size)
+			pos += 3
+		} else {
+			if pos+size > len(patch) {
+				return nil, newErrPatchInvalid("ips", "unexpected end of patch")
+			}
+			data = patch[pos : pos+size]
+			pos += size
+		}
+
+		if offset+size > len(out) {
+			out = append(out, make([]byte, offset+size-len(out))...)
+		}
+		copy(out[offset:], data)
+	}
+
+	// Optional truncation extension
+	if pos+3 <= len(patch) {
+		size := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if size < len(out) {
+			out = out[:size]
+		}
+	}
+
+	return out, nil
+}
+
+// patchReader reads the variable length numbers used by bps and ups patches.
+type patchReader struct {
+	format string
+	patch  []byte
+	pos    int
+	end    int // position of the footer
+}
+
+// readByte reads a single byte of the patch body.
+func (r *patchReader) readByte() (byte, error) {
+	if r.pos >= r.end {
+		return 0, newErrPatchInvalid(r.format, "unexpected end of patch")
+	}
+
+	b := r.patch[r.pos]
+	r.pos++
+	return b, nil
+}
+
+// readNumber reads a variable length number from the patch body.
+// See https://www.romhacking.net/documents/746/.
+func (r *patchReader) readNumber() (int, error) {
+	num, shift := 0, 1
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+
+		num += int(b&0x7F) * shift
+		if b&0x80 != 0 {
+			return num, nil
+		}
+
+		shift <<= 7
+		num += shift
+		if shift > 1<<42 {
+			return 0, newErrPatchInvalid(r.format, "number too large")
+		}
+	}
+}
+
+// verifyFooter checks the source and patch crc32s of a bps or ups patch,
+// returning the expected target crc32.
+func verifyFooter(format string, source, patch []byte) (targetCRC uint32, err error) {
+	footer := patch[len(patch)-patchFooterLen:]
+	sourceCRC := binary.LittleEndian.Uint32(footer[0:])
+	targetCRC = binary.LittleEndian.Uint32(footer[4:])
+	patchCRC := binary.LittleEndian.Uint32(footer[8:])
+
+	if got := crc32.ChecksumIEEE(patch[:len(patch)-4]); got != patchCRC {
+		return 0, newErrPatchChecksum(format, "patch", patchCRC, got)
+	}
+	if got := crc32.ChecksumIEEE(source); got != sourceCRC {
+		return 0, newErrPatchChecksum(format, "source", sourceCRC, got)
+	}
+
+	return targetCRC, nil
+}
+
+// applyBPS applies a bps patch to rom, returning the patched rom.
+// See https://www.romhacking.net/documents/746/.
+func applyBPS(rom, patch []byte) ([]byte, error) {
+	// bps actions
+	const (
+		sourceRead = iota
+		targetRead
+		sourceCopy
+		targetCopy
+	)
+
+	if len(patch) < len(bpsMagic)+patchFooterLen {
+		return nil, newErrPatchInvalid("bps", "patch too short")
+	}
+
+	targetCRC, err := verifyFooter("bps", rom, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &patchReader{format: "bps", patch: patch, pos: len(bpsMagic), end: len(patch) - patchFooterLen}
+	sourceSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	metadataSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != len(rom) {
+		return nil, newErrPatchInvalid("bps", fmt.Sprintf("source size: want %v, got %v", sourceSize, len(rom)))
+	}
+	if r.pos+metadataSize > r.end {
+		return nil, newErrPatchInvalid("bps", "unexpected end of patch")
+	}
+	r.pos += metadataSize
+
+	// readOffset reads a signed relative offset
+	readOffset := func() (int, error) {
+		num, err := r.readNumber()
+		if num&1 != 0 {
+			return -(num >> 1), err
+		}
+		return num >> 1, err
+	}
+
+	out := make([]byte, targetSize)
+	outPos, sourceRel, targetRel := 0, 0, 0
+	for r.pos < r.end {
+		action, err := r.readNumber()
+		if err != nil {
+			return nil, err
+		}
+
+		length := action>>2 + 1
+		if outPos+length > targetSize {
+			return nil, newErrPatchInvalid("bps", "write past end of target")
+		}
+
+		switch action & 3 {
+		case sourceRead:
+			if outPos+length > len(rom) {
+				return nil, newErrPatchInvalid("bps", "read past end of source")
+			}
+			copy(out[outPos:], rom[outPos:outPos+length])
+
+		case targetRead:
+			if r.pos+length > r.end {
+				return nil, newErrPatchInvalid("bps", "unexpected end of patch")
+			}
+			copy(out[outPos:], patch[r.pos:r.pos+length])
+			r.pos += length
+
+		case sourceCopy:
+			offset, err := readOffset()
+			if err != nil {
+				return nil, err
+			}
+			sourceRel += offset
+			if sourceRel < 0 || sourceRel+length > len(rom) {
+				return nil, newErrPatchInvalid("bps", "copy outside of source")
+			}
+			copy(out[outPos:], rom[sourceRel:sourceRel+length])
+			sourceRel += length
+
+		case targetCopy:
+			offset, err := readOffset()
+			if err != nil {
+				return nil, err
+			}
+			targetRel += offset
+			if targetRel < 0 || targetRel >= outPos {
+				return nil, newErrPatchInvalid("bps", "copy outside of target")
+			}
+			// Copy byte by byte, since the source and destination may overlap
+			for i := 0; i < length; i++ {
+				out[outPos+i] = out[targetRel]
+				targetRel++
+			}
+		}
+
+		outPos += length
+	}
+
+	if got := crc32.ChecksumIEEE(out); got != targetCRC {
+		return nil, newErrPatchChecksum("bps", "target", targetCRC, got)
+	}
+
+	return out, nil
+}
+
+// applyUPS applies a ups patch to rom, returning the patched rom.
+// See http://individual.utoronto.ca/dmeunier/ups-spec.pdf.
+func applyUPS(rom, patch []byte) ([]byte, error) {
+	if len(patch) < len(upsMagic)+patchFooterLen {
+		return nil, newErrPatchInvalid("ups", "patch too short")
+	}
+
+	targetCRC, err := verifyFooter("ups", rom, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &patchReader{format: "ups", patch: patch, pos: len(upsMagic), end: len(patch) - patchFooterLen}
+	sourceSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := r.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != len(rom) {
+		return nil, newErrPatchInvalid("ups", fmt.Sprintf("source size: want %v, got %v", sourceSize, len(rom)))
+	}
+
+	out := make([]byte, targetSize)
+	copy(out, rom)
+
+	outPos := 0
+	for r.pos < r.end {
+		skip, err := r.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		outPos += skip
+
+		// xor bytes until a terminating zero
+		for {
+			b, err := r.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if b == 0 {
+				break
+			}
+			if outPos >= targetSize {
+				return nil, newErrPatchInvalid("ups", "write past end of target")
+			}
+			out[outPos] ^= b
+			outPos++
+		}
+		outPos++
+	}
+
+	if got := crc32.ChecksumIEEE(out); got != targetCRC {
+		return nil, newErrPatchChecksum("ups", "target", targetCRC, got)
+	}
+
+	return out, nil
+}
+
+// ipsMaxRecordLen is the longest run of data a single ips record can hold.
+const ipsMaxRecordLen = 0xFFFF
+
+// ipsMaxOffset is the largest offset an ips record can start at.
+const ipsMaxOffset = 0xFFFFFF
+
+// makeIPS creates an ips patch which turns original into modified.  original and modified
+// must be the same length, and differences beyond ipsMaxOffset are not recorded.
+func makeIPS(original, modified []byte) []byte {
+	patch := bytes.Clone(ipsMagic)
+
+	for pos := 0; pos < len(modified) && pos <= ipsMaxOffset; {
+		if original[pos] == modified[pos] {
+			pos++
+			continue
+		}
+
+		// "EOF" as an offset would be mistaken for the end of the patch
+		start := pos
+		if start == 0x454F46 {
+			start--
+		}
+
+		end := pos
+		for end < len(modified) && end-start < ipsMaxRecordLen && original[end] != modified[end] {
+			end++
+		}
+
+		patch = append(patch, byte(start>>16), byte(start>>8), byte(start))
+		patch = binary.BigEndian.AppendUint16(patch, uint16(end-start))
+		patch = append(patch, modified[start:end]...)
+		pos = end
+	}
+
+	return append(patch, ipsEOF...)
+}
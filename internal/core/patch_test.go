@@ -0,0 +1,124 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeNumber encodes num as a bps/ups variable length number.
+func encodeNumber(num int) (out []byte) {
+	for {
+		x := byte(num & 0x7F)
+		num >>= 7
+		if num == 0 {
+			return append(out, 0x80|x)
+		}
+		out = append(out, x)
+		num--
+	}
+}
+
+// withFooter appends the source, target and patch crc32s to patch.
+func withFooter(patch, source, target []byte) []byte {
+	patch = binary.LittleEndian.AppendUint32(patch, crc32.ChecksumIEEE(source))
+	patch = binary.LittleEndian.AppendUint32(patch, crc32.ChecksumIEEE(target))
+	return binary.LittleEndian.AppendUint32(patch, crc32.ChecksumIEEE(patch))
+}
+
+func TestApplyIPS(t *testing.T) {
+	rom := []byte{0, 1, 2, 3, 4, 5}
+	patch := append([]byte("PATCH"),
+		0x00, 0x00, 0x01, 0x00, 0x02, 0xAA, 0xBB, // 2 bytes at offset 1
+		0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x03, 0xCC, // 3 byte run at offset 5
+	)
+	patch = append(patch, []byte("EOF")...)
+
+	got, err := applyIPS(rom, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0, 0xAA, 0xBB, 3, 4, 0xCC, 0xCC, 0xCC}
+	if !bytes.Equal(got, want) {
+		t.Errorf("want % X, got % X", want, got)
+	}
+	if !bytes.Equal(rom, []byte{0, 1, 2, 3, 4, 5}) {
+		t.Error("source rom was modified")
+	}
+}
+
+func TestApplyBPS(t *testing.T) {
+	source := []byte("ABCDEFGH")
+	target := []byte("ABCDxyxyxyGH")
+
+	patch := append([]byte("BPS1"), encodeNumber(len(source))...)
+	patch = append(patch, encodeNumber(len(target))...)
+	patch = append(patch, encodeNumber(0)...)
+	patch = append(patch, encodeNumber((4-1)<<2|0)...) // source read ABCD
+	patch = append(patch, encodeNumber((2-1)<<2|1)...) // target read xy
+	patch = append(patch, 'x', 'y')
+	patch = append(patch, encodeNumber((4-1)<<2|3)...) // target copy xyxy
+	patch = append(patch, encodeNumber(4<<1)...)
+	patch = append(patch, encodeNumber((2-1)<<2|2)...) // source copy GH
+	patch = append(patch, encodeNumber(6<<1)...)
+	patch = withFooter(patch, source, target)
+
+	got, err := applyBPS(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Errorf("want %q, got %q", target, got)
+	}
+
+	var checksumErr errPatchChecksum
+	if _, err := applyBPS([]byte("ABCDEFGX"), patch); !errors.As(err, &checksumErr) {
+		t.Errorf("want errPatchChecksum, got %v", err)
+	}
+}
+
+func TestCartridgeAutoDetectsUPS(t *testing.T) {
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 0}
+	path := writeROM(t, "hack.nes", header)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// set the mapper to 1 (byte 6 high nibble) and the first prg byte to 0x42
+	target := bytes.Clone(source)
+	target[6] ^= 0x10
+	target[iNesHeaderLen] ^= 0x42
+
+	patch := append([]byte("UPS1"), encodeNumber(len(source))...)
+	patch = append(patch, encodeNumber(len(target))...)
+	patch = append(patch, encodeNumber(6)...)
+	patch = append(patch, 0x10, 0x00)
+	patch = append(patch, encodeNumber(iNesHeaderLen-8)...)
+	patch = append(patch, 0x42, 0x00)
+	patch = withFooter(patch, source, target)
+
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "hack.ups"), patch, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := newCartridge(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cart.mapperNum != 1 || cart.prgROM[0] != 0x42 {
+		t.Errorf("patch not applied: %v", cart)
+	}
+	if len(cart.patches) != 1 {
+		t.Errorf("want 1 patch recorded, got %v", cart.patches)
+	}
+
+	if onDisk, _ := os.ReadFile(path); !bytes.Equal(onDisk, source) {
+		t.Error("rom on disk was modified")
+	}
+}
@@ -24,6 +24,7 @@ func (c *ctrl1) write(data byte) {
 	c.ntAddr = 0x2000
 	c.addrInc = 1
 	c.sprPtable = 0x0000
+	c.bgPtable = 0x0000
 	c.sprSize = 8
 	c.nmi = false
 
@@ -56,6 +57,27 @@ func (c *ctrl1) write(data byte) {
 	}
 }
 
+// value returns the byte last written to the first ppu control register.
+func (c *ctrl1) value() (data byte) {
+	data = byte((c.ntAddr - 0x2000) / nametableLen)
+	if c.addrInc == 32 {
+		data |= mask2
+	}
+	if c.sprPtable == 0x1000 {
+		data |= mask3
+	}
+	if c.bgPtable == 0x1000 {
+		data |= mask4
+	}
+	if c.sprSize == 16 {
+		data |= mask5
+	}
+	if c.nmi {
+		data |= mask7
+	}
+	return data
+}
+
 // ctrl2 is the first ppu control register.
 // See https://wiki.nesdev.com/w/index.php/ppu_registers.
 type ctrl2 struct {
@@ -118,6 +140,32 @@ func (c *ctrl2) write(data byte) {
 	}
 }
 
+// value returns the byte last written to the second ppu control register.
+func (c *ctrl2) value() (data byte) {
+	for bit, set := range []bool{
+		c.monochrome, c.showBgPixels, c.showSpritePixels, c.showBg,
+		c.showSprites, c.emphasizeRed, c.emphasizeGreen, c.emphasizeBlue,
+	} {
+		data |= convert(set) << bit
+	}
+	return data
+}
+
+// emphasis returns the emphasis bits of the second ppu control register, red, green and blue
+// from least significant.
+func (c *ctrl2) emphasis() (bits uint16) {
+	if c.emphasizeRed {
+		bits |= mask0
+	}
+	if c.emphasizeGreen {
+		bits |= mask1
+	}
+	if c.emphasizeBlue {
+		bits |= mask2
+	}
+	return bits
+}
+
 // statusReg is the ppu status register.
 // See https://wiki.nesdev.com/w/index.php/ppu_registers for more info.
 type ppuStatusReg struct {
@@ -146,35 +194,21 @@ func (s *ppuStatusReg) read() (data byte) {
 	return data
 }
 
-// doubleWriter is a register which has different functionality based on
-// whether or not it has been written to an even or odd number of times.
-type doubleWriter struct {
-	toggle bool
-	data1  byte
-	data2  byte
-}
-
-// write writes to dw with a byte of data.
-func (dw *doubleWriter) write(data byte) {
-	if !dw.toggle {
-		dw.data1 = data
-	} else {
-		dw.data2 = data
-	}
-	dw.toggle = !dw.toggle
-}
-
-// read16 reads a word of data from dw.
-func (dw *doubleWriter) read16() (word uint16) {
-	lo := uint16(dw.data2)
-	hi := uint16(dw.data1)
-	return hi<<8 | lo
-}
-
 // Memory sizes
 const (
-	sprRAMSize = 0x100
-	vRAMSize   = 0x1000
+	sprRAMSize   = 0x100
+	ciRAMSize    = 0x800 // internal nametable RAM
+	nametableLen = 0x400
+	paletteSize  = 0x20
+)
+
+// ppu memory map
+// See https://wiki.nesdev.com/w/index.php/PPU_memory_map.
+const (
+	nametableStart   = 0x2000
+	nametableMirrors = 0x1000 // $3000-$3EFF mirror $2000-$2EFF
+	paletteStart     = 0x3F00
+	ppuAddrSpace     = 0x4000
 )
 
 // IO registers
@@ -187,7 +221,6 @@ const (
 	scrollAddrReg = 0x2005
 	vRAMAddrReg   = 0x2006
 	vRAMDataReg   = 0x2007
-	sprDMAReg     = 0x4014
 )
 
 // ppu represents the picture processing unit of the nes.
@@ -197,35 +230,88 @@ type ppu struct {
 
 	ppuStatusReg // ppu status reg
 
-	sprRAMAddr byte          // SPR-RAM read/write address
-	scrollAddr *doubleWriter // Fine scroll position (two writes: X scroll, Y scroll)
-	vRAMAddr   *doubleWriter // ppu read/write address (two writes: hi byte, lo byte)
+	sprRAMAddr byte // SPR-RAM read/write address
 
-	//TODO: dma
+	// Internal scroll registers, shared by $2005 and $2006.  v and t are laid out as
+	// yyy NN YYYYY XXXXX (fine y, nametable, coarse y, coarse x).
+	// See https://wiki.nesdev.com/w/index.php/PPU_scrolling.
+	v uint16 // current vram address
+	t uint16 // temporary vram address, the top left of the screen during rendering
+	x byte   // fine x scroll (3 bits)
+	w bool   // write toggle, shared by $2005 and $2006
 
-	sprRAM [sprRAMSize]byte // ppu SPR-RAM
-	vRAM   [vRAMSize]byte   // ppu VRAM
+	readBuffer byte // $2007 read buffer, holding the byte read by the previous access
+
+	sprRAM           [sprRAMSize]byte // ppu SPR-RAM
+	lineSprites      []sprite         // sprites on the current scanline, in priority order
+	unlimitedSprites bool             // whether or not to render every sprite on a scanline, rather than 8
+	ciRAM            [ciRAMSize]byte  // ppu internal nametable RAM
+
+	// timing
+	timing     *timing // frame layout of the region
+	scanline   int     // current scanline, where 0 is the first visible scanline
+	dot        int     // current dot within the scanline, 0-340
+	oddFrame   bool    // whether or not the current frame is odd, and so one dot shorter
+	frames     int     // number of frames completed
+	nmiPending bool    // whether or not an nmi should be delivered to the cpu
+	frameReady bool    // whether or not a frame has been completed since output was last taken
+
+	// background pipeline
+	ntByte   byte   // nametable byte of the tile being fetched
+	atBits   byte   // 2 bit palette of the tile being fetched
+	patLo    byte   // low pattern plane of the tile being fetched
+	patHi    byte   // high pattern plane of the tile being fetched
+	bgPatLo  uint16 // low pattern plane shift register
+	bgPatHi  uint16 // high pattern plane shift register
+	bgAttrLo uint16 // low palette bit shift register
+	bgAttrHi uint16 // high palette bit shift register
+
+	frame  [frameSize]uint16 // frame being drawn, as indices into the system palette
+	output [frameSize]uint16 // last completed frame
+
+	palette [paletteSize]byte // palette RAM
+
+	// cartridge memory
+	mapper     mapper           // chr memory, at $0000-$1FFF
+	mirroring  mirroring        // nametable mirroring given by the cartridge
+	controller mirrorController // mapper controlling mirroring, or nil to use mirroring
+	vRAM       []byte           // extra nametable RAM for four screen mirroring, nil otherwise
 }
 
-// newPpu creates a new ppu.
+// newPpu creates a new ppu, timed as NTSC until told otherwise.
 func newPpu() (p *ppu) {
-	return &ppu{
-		scrollAddr: &doubleWriter{},
-		vRAMAddr:   &doubleWriter{},
-	}
+	p = &ppu{timing: ntscTiming, lineSprites: make([]sprite, 0, spriteCount)}
+	p.ctrl1.write(0x00)
+	return p
 }
 
 // readRegister implements mmio.MemoryMappedIO.
 func (p *ppu) readRegister(reg uint16) (data byte) {
 	switch reg {
 	case statusReg:
-		return p.ppuStatusReg.read()
-	case sprRAMAddrReg:
-		// TODO: read data from sprRam
-		fallthrough
+		data = p.ppuStatusReg.read()
+		p.vBlank = false
+		p.w = false
+		return data
+	case sprRAMDataReg:
+		data = p.sprRAM[p.sprRAMAddr]
+		if p.sprRAMAddr%4 == 2 {
+			data &= spriteAttrMask
+		}
+		return data
 	case vRAMDataReg:
-		// TODO: read data from vram
-		fallthrough
+		// Reads return the buffer, which is then filled from v.  Palette reads bypass the
+		// buffer, filling it from the nametable underneath the palette instead.
+		// See https://wiki.nesdev.com/w/index.php/PPU_registers#The_PPUDATA_read_buffer_.28post-fetch.29.
+		address := p.v % ppuAddrSpace
+		data = p.readBuffer
+		p.readBuffer = p.read(address)
+		if address >= paletteStart {
+			data = p.readPalette(address)
+			p.readBuffer = p.read(address - nametableMirrors)
+		}
+		p.incrementV()
+		return data
 	default:
 		return 0x00
 	}
@@ -235,23 +321,245 @@ func (p *ppu) readRegister(reg uint16) (data byte) {
 func (p *ppu) writeRegister(reg uint16, data byte) {
 	switch reg {
 	case ctrlReg1:
+		// Enabling nmis during vblank generates one immediately
+		wasEnabled := p.nmi
 		p.ctrl1.write(data)
+		p.t = p.t&^0x0C00 | uint16(data&mask01)<<10
+		if !wasEnabled && p.nmi && p.vBlank {
+			p.nmiPending = true
+		}
 	case ctrlReg2:
 		p.ctrl2.write(data)
 	case sprRAMAddrReg:
 		p.sprRAMAddr = data
 	case sprRAMDataReg:
 		p.sprRAM[p.sprRAMAddr] = data
+		p.sprRAMAddr++
 	case scrollAddrReg:
-		p.scrollAddr.write(data)
+		if !p.w {
+			// coarse x and fine x
+			p.t = p.t&^0x001F | uint16(data>>3)
+			p.x = data & 0x07
+		} else {
+			// coarse y and fine y
+			p.t = p.t&^0x73E0 | uint16(data>>3)<<5 | uint16(data&0x07)<<12
+		}
+		p.w = !p.w
 	case vRAMAddrReg:
-		p.vRAMAddr.write(data)
+		if !p.w {
+			// high byte, with bit 14 cleared
+			p.t = p.t&0x00FF | uint16(data&0x3F)<<8
+		} else {
+			p.t = p.t&0xFF00 | uint16(data)
+			p.v = p.t
+		}
+		p.w = !p.w
 	case vRAMDataReg:
-		address := p.vRAMAddr.read16()
-		p.vRAM[address] = data
-	case sprDMAReg:
-		//TODO: perform DMA
+		p.write(p.v, data)
+		p.incrementV()
+	default:
+	}
+}
+
+// useCartridge connects the ppu to the chr memory of m, and to vRAM, the extra nametable RAM of
+// cartridges with four screen mirroring (or nil).  Nametables are mirrored according to
+// mode, unless m is a mirrorController.
+func (p *ppu) useCartridge(m mapper, mode mirroring, vRAM []byte) {
+	p.mapper = m
+	p.mirroring = mode
+	p.controller, _ = m.(mirrorController)
+	p.vRAM = vRAM
+}
+
+// saveState implements stateful.  The extra nametable RAM of the cartridge is saved with the
+// mapper.
+func (p *ppu) saveState(w *stateWriter) {
+	w.u8(p.ctrl1.value())
+	w.u8(p.ctrl2.value())
+	w.bool(p.vramWriteIgnore)
+	w.bool(p.highScanlineSprites)
+	w.bool(p.spriteHit)
+	w.bool(p.vBlank)
+	w.u8(p.sprRAMAddr)
+
+	w.u16(p.v)
+	w.u16(p.t)
+	w.u8(p.x)
+	w.bool(p.w)
+	w.u8(p.readBuffer)
+
+	w.bytes(p.sprRAM[:])
+	w.int(len(p.lineSprites))
+	for _, s := range p.lineSprites {
+		w.int(s.x)
+		w.u8(s.patLo)
+		w.u8(s.patHi)
+		w.u8(s.attr)
+		w.bool(s.zero)
+	}
+	w.bytes(p.ciRAM[:])
+	w.bytes(p.palette[:])
+
+	w.int(p.scanline)
+	w.int(p.dot)
+	w.bool(p.oddFrame)
+	w.int(p.frames)
+	w.bool(p.nmiPending)
+	w.bool(p.frameReady)
+
+	w.u8(p.ntByte)
+	w.u8(p.atBits)
+	w.u8(p.patLo)
+	w.u8(p.patHi)
+	w.u16(p.bgPatLo)
+	w.u16(p.bgPatHi)
+	w.u16(p.bgAttrLo)
+	w.u16(p.bgAttrHi)
+
+	w.words(p.frame[:])
+	w.words(p.output[:])
+}
+
+// loadState implements stateful.
+func (p *ppu) loadState(r *stateReader) {
+	p.ctrl1.write(r.u8())
+	p.ctrl2.write(r.u8())
+	p.vramWriteIgnore = r.bool()
+	p.highScanlineSprites = r.bool()
+	p.spriteHit = r.bool()
+	p.vBlank = r.bool()
+	p.sprRAMAddr = r.u8()
+
+	p.v = r.u16()
+	p.t = r.u16()
+	p.x = r.u8Index(8)
+	p.w = r.bool()
+	p.readBuffer = r.u8()
+
+	r.bytes(p.sprRAM[:])
+	p.lineSprites = p.lineSprites[:0]
+	for range r.count(spriteCount) {
+		p.lineSprites = append(p.lineSprites, sprite{
+			x:     r.int(),
+			patLo: r.u8(),
+			patHi: r.u8(),
+			attr:  r.u8(),
+			zero:  r.bool(),
+		})
+	}
+	r.bytes(p.ciRAM[:])
+	r.bytes(p.palette[:])
+
+	p.scanline = r.index(p.timing.scanlines)
+	p.dot = r.index(dotsPerScanline)
+	p.oddFrame = r.bool()
+	p.frames = r.int()
+	p.nmiPending = r.bool()
+	p.frameReady = r.bool()
+
+	p.ntByte = r.u8()
+	p.atBits = r.u8()
+	p.patLo = r.u8()
+	p.patHi = r.u8()
+	p.bgPatLo = r.u16()
+	p.bgPatHi = r.u16()
+	p.bgAttrLo = r.u16()
+	p.bgAttrHi = r.u16()
+
+	r.words(p.frame[:])
+	r.words(p.output[:])
+}
+
+// incrementV increments v by ctrl1.addrInc after an access through $2007.  While rendering,
+// the access instead increments coarse x and y at once, as the rendering increments do.
+// See https://wiki.nesdev.com/w/index.php/PPU_scrolling#.242007_reads_and_writes.
+func (p *ppu) incrementV() {
+	if p.renderingEnabled() && (p.scanline < postRenderLine || p.scanline == p.timing.preRenderLine()) {
+		p.incrementX()
+		p.incrementY()
+		return
+	}
+	p.v = (p.v + p.addrInc) & 0x7FFF
+}
+
+// nametable returns the nametable RAM backing address, which must be within $2000-$3EFF.
+func (p *ppu) nametable(address uint16) *byte {
+	offset := (address - nametableStart) % nametableMirrors
+	table, within := offset/nametableLen, offset%nametableLen
+
+	mode := p.mirroring
+	if p.controller != nil {
+		mode = p.controller.nametableMirroring()
+	}
+
+	switch mode {
+	case mirrorHorizontal:
+		table >>= 1
+	case mirrorVertical:
+		table &= 1
+	case mirrorSingleLow:
+		table = 0
+	case mirrorSingleHigh:
+		table = 1
+	case mirrorFourScreen:
+		if p.vRAM == nil {
+			table &= 1
+		} else if table >= 2 {
+			return &p.vRAM[(table-2)*nametableLen+within]
+		}
+	}
+	return &p.ciRAM[table*nametableLen+within]
+}
+
+// paletteIndex returns the index into palette RAM of address, which must be within
+// $3F00-$3FFF.  The backdrop entries of the sprite palettes ($3F10, $3F14, $3F18 and $3F1C)
+// mirror those of the background palettes.
+func paletteIndex(address uint16) uint16 {
+	index := address % paletteSize
+	if index&0x13 == 0x10 {
+		index &^= 0x10
+	}
+	return index
+}
+
+// read reads a byte of data from address on the ppu bus.
+func (p *ppu) read(address uint16) (data byte) {
+	address %= ppuAddrSpace
+	switch {
+	case address < nametableStart:
+		if p.mapper == nil {
+			return 0x00
+		}
+		return p.mapper.readChr(address)
+	case address < paletteStart:
+		return *p.nametable(address)
+	default:
+		return p.palette[paletteIndex(address)] & 0x3F
+	}
+}
+
+// readPalette reads the color at address in palette RAM, which must be within $3F00-$3FFF, as
+// the ppu outputs it: masked to the grey column of the system palette while monochrome.
+func (p *ppu) readPalette(address uint16) (color byte) {
+	color = p.read(address)
+	if p.monochrome {
+		color &= 0x30
+	}
+	return color
+}
+
+// write writes a byte of data to address on the ppu bus.
+func (p *ppu) write(address uint16, data byte) {
+	address %= ppuAddrSpace
+	switch {
+	case address < nametableStart:
+		if p.mapper != nil {
+			p.mapper.writeChr(address, data)
+		}
+	case address < paletteStart:
+		*p.nametable(address) = data
 	default:
+		p.palette[paletteIndex(address)] = data & 0x3F
 	}
 }
 
@@ -263,8 +571,5 @@ func (p *ppu) writeRegister(reg uint16, data byte) {
 //
 // // clear implements core.Component.
 // func (p *ppu) clear() {
-// 	*p = ppu{
-// 		scrollAddr: &doubleWriter{},
-// 		vRAMAddr:   &doubleWriter{},
-// 	}
+// 	*p = ppu{}
 // }
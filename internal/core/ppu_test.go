@@ -0,0 +1,340 @@
+package core
+
+import "testing"
+
+// stepFrame steps p until the start of the next frame, returning the number of dots stepped.
+func stepFrame(p *ppu) (dots int) {
+	frames := p.frames
+	for p.frames == frames {
+		p.step()
+		dots++
+	}
+	return dots
+}
+
+func TestPpuFrameTiming(t *testing.T) {
+	p := newPpu()
+	if dots := stepFrame(p); dots != dotsPerScanline*ntscTiming.scanlines {
+		t.Errorf("frame with rendering disabled: want %v dots, got %v", dotsPerScanline*ntscTiming.scanlines, dots)
+	}
+
+	// With rendering enabled, odd frames skip a dot
+	p.writeRegister(ctrlReg2, mask3)
+	odd, even := stepFrame(p), stepFrame(p)
+	if even-odd != 1 {
+		t.Errorf("odd frame: want 1 dot shorter than even frame, got %v and %v", odd, even)
+	}
+}
+
+func TestPpuVBlankNMI(t *testing.T) {
+	p := newPpu()
+	p.writeRegister(ctrlReg1, mask7)
+
+	for !(p.scanline == p.timing.vBlankLine && p.dot == 1) {
+		p.step()
+		if p.nmiPending || p.vBlank {
+			t.Fatalf("vblank started early, at scanline %v dot %v", p.scanline, p.dot)
+		}
+	}
+	p.step()
+	if !p.vBlank || !p.nmiPending {
+		t.Fatal("vblank did not start at scanline 241 dot 1")
+	}
+	if !p.frameReady {
+		t.Error("frame not output by the end of the visible scanlines")
+	}
+
+	if p.readRegister(statusReg)&mask7 == 0 {
+		t.Error("$2002 did not report vblank")
+	}
+	if p.readRegister(statusReg)&mask7 != 0 {
+		t.Error("reading $2002 did not clear vblank")
+	}
+
+	// Enabling nmis during vblank generates one immediately
+	p.nmiPending = false
+	p.writeRegister(ctrlReg1, 0)
+	p.writeRegister(ctrlReg1, mask7)
+	p.vBlank = true
+	p.writeRegister(ctrlReg1, 0)
+	p.writeRegister(ctrlReg1, mask7)
+	if !p.nmiPending {
+		t.Error("enabling nmis during vblank did not generate an nmi")
+	}
+}
+
+func TestPpuLoopyRegisters(t *testing.T) {
+	// The example from https://wiki.nesdev.com/w/index.php/PPU_scrolling#Summary
+	p := newPpu()
+	p.writeRegister(ctrlReg1, 0x00)
+	p.readRegister(statusReg)
+
+	p.writeRegister(scrollAddrReg, 0x7D)
+	if p.t != 0x000F || p.x != 0x05 || !p.w {
+		t.Errorf("first $2005 write: t=$%04X x=%v w=%v", p.t, p.x, p.w)
+	}
+	p.writeRegister(scrollAddrReg, 0x5E)
+	if p.t != 0x616F || p.w {
+		t.Errorf("second $2005 write: t=$%04X w=%v", p.t, p.w)
+	}
+
+	// $2005 and $2006 share the write toggle
+	p.writeRegister(vRAMAddrReg, 0x3D)
+	if p.t != 0x3D6F || !p.w {
+		t.Errorf("first $2006 write: t=$%04X w=%v", p.t, p.w)
+	}
+	p.writeRegister(vRAMAddrReg, 0xF0)
+	if p.t != 0x3DF0 || p.v != 0x3DF0 || p.w {
+		t.Errorf("second $2006 write: t=$%04X v=$%04X w=%v", p.t, p.v, p.w)
+	}
+
+	// Reading $2002 resets the write toggle
+	p.writeRegister(vRAMAddrReg, 0x3D)
+	p.readRegister(statusReg)
+	if p.w {
+		t.Error("reading $2002 did not reset w")
+	}
+}
+
+func TestPpuIncrementY(t *testing.T) {
+	tests := []struct {
+		v, want uint16
+	}{
+		{0x0000, 0x1000}, // fine y
+		{0x7000, 0x0020}, // coarse y
+		{0x73A0, 0x0800}, // row 29 wraps to the next nametable
+		{0x7BA0, 0x0000},
+		{0x73E0, 0x0000}, // row 31 wraps within the nametable
+	}
+
+	for _, test := range tests {
+		p := newPpu()
+		p.v = test.v
+		p.incrementY()
+		if p.v != test.want {
+			t.Errorf("incrementY($%04X): want $%04X, got $%04X", test.v, test.want, p.v)
+		}
+	}
+}
+
+func TestPpuNametableMirroring(t *testing.T) {
+	tests := []struct {
+		mode mirroring
+		want [4]byte // RAM nametable each of $2000, $2400, $2800 and $2C00 map to
+	}{
+		{mirrorHorizontal, [4]byte{0, 0, 1, 1}},
+		{mirrorVertical, [4]byte{0, 1, 0, 1}},
+		{mirrorSingleLow, [4]byte{0, 0, 0, 0}},
+		{mirrorSingleHigh, [4]byte{1, 1, 1, 1}},
+		{mirrorFourScreen, [4]byte{0, 1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		p := newPpu()
+		p.useCartridge(nil, test.mode, make([]byte, vRAMLen))
+		for table := uint16(0); table < 4; table++ {
+			p.write(nametableStart+table*nametableLen, byte(table+1))
+		}
+
+		// Each RAM nametable holds the last table written through it
+		var last [4]byte
+		for table, ram := range test.want {
+			last[ram] = byte(table + 1)
+		}
+		for table, ram := range test.want {
+			if got := p.read(nametableStart + uint16(table)*nametableLen); got != last[ram] {
+				t.Errorf("%v mirroring, nametable %v: want %v, got %v", test.mode, table, last[ram], got)
+			}
+		}
+	}
+}
+
+func TestPpuPaletteAndAddressIncrement(t *testing.T) {
+	p := newPpu()
+
+	// The sprite palette backdrops mirror the background palette backdrops
+	p.writeRegister(vRAMAddrReg, 0x3F)
+	p.writeRegister(vRAMAddrReg, 0x10)
+	p.writeRegister(vRAMDataReg, 0x2A)
+	p.writeRegister(vRAMDataReg, 0x11)
+	if p.palette[0x00] != 0x2A || p.palette[0x11] != 0x11 || p.v != 0x3F12 {
+		t.Errorf("palette writes: $3F00=$%02X $3F11=$%02X v=$%04X", p.palette[0x00], p.palette[0x11], p.v)
+	}
+	if got := p.read(0x3F30); got != 0x2A {
+		t.Errorf("palette mirrored through $3FFF: want $2A, got $%02X", got)
+	}
+
+	// Increment by 32 moves down a nametable row
+	p.writeRegister(ctrlReg1, mask2)
+	p.writeRegister(vRAMAddrReg, 0x20)
+	p.writeRegister(vRAMAddrReg, 0x00)
+	p.writeRegister(vRAMDataReg, 0x01)
+	if p.v != 0x2020 {
+		t.Errorf("address increment of 32: want v=$2020, got $%04X", p.v)
+	}
+}
+
+// newChrRAMPpu returns a ppu connected to an NROM cartridge with chr RAM, in which tile 1 is
+// solid color 1.
+func newChrRAMPpu(t *testing.T) *ppu {
+	t.Helper()
+
+	header := [iNesHeaderLen]byte{'N', 'E', 'S', 0x1A, 1, 0}
+	cart, err := newCartridge(writeROM(t, "chrram.nes", header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newMapper(cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPpu()
+	p.useCartridge(m, cart.headerMirroring(), cart.vRAM)
+
+	for row := uint16(0); row < 8; row++ {
+		p.write(tileLen+row, 0xFF)
+	}
+	return p
+}
+
+func TestPpuRenderBackground(t *testing.T) {
+	p := newChrRAMPpu(t)
+
+	// Tile 1 is placed at the top left of the screen
+	p.write(nametableStart, 0x01)
+	p.write(paletteStart, 0x0F)
+	p.write(paletteStart+1, 0x16)
+
+	p.writeRegister(ctrlReg2, mask1|mask3)
+	stepFrame(p)
+	stepFrame(p)
+
+	for x, want := range map[int]uint16{0: 0x16, 7: 0x16, 8: 0x0F} {
+		if got := p.output[7*frameWidth+x]; got != want {
+			t.Errorf("pixel (%v, 7): want $%02X, got $%02X", x, want, got)
+		}
+	}
+	if got := p.output[8*frameWidth]; got != 0x0F {
+		t.Errorf("pixel (0, 8): want $0F, got $%02X", got)
+	}
+}
+
+func TestPpuDataReadBuffer(t *testing.T) {
+	p := newPpu()
+	p.useCartridge(nil, mirrorVertical, nil)
+	p.write(0x2000, 0x11)
+	p.write(0x2001, 0x22)
+	p.write(0x2F00, 0x33)
+	p.write(0x3F00, 0x0F)
+
+	setV := func(address uint16) {
+		p.writeRegister(vRAMAddrReg, byte(address>>8))
+		p.writeRegister(vRAMAddrReg, byte(address))
+	}
+
+	// Nametable reads lag one access behind
+	setV(0x2000)
+	p.readRegister(vRAMDataReg)
+	if got := p.readRegister(vRAMDataReg); got != 0x11 {
+		t.Errorf("buffered read of $2000: want $11, got $%02X", got)
+	}
+	if got := p.readRegister(vRAMDataReg); got != 0x22 {
+		t.Errorf("buffered read of $2001: want $22, got $%02X", got)
+	}
+
+	// Palette reads are immediate, and fill the buffer from the nametable underneath
+	setV(0x3F00)
+	if got := p.readRegister(vRAMDataReg); got != 0x0F {
+		t.Errorf("palette read: want $0F, got $%02X", got)
+	}
+	if p.readBuffer != 0x33 {
+		t.Errorf("buffer after palette read: want $33, got $%02X", p.readBuffer)
+	}
+
+	// Monochrome masks palette reads as it does the colors rendered
+	p.writeRegister(ctrlReg2, mask0)
+	setV(0x3F00)
+	if got := p.readRegister(vRAMDataReg); got != 0x00 {
+		t.Errorf("monochrome palette read: want $00, got $%02X", got)
+	}
+
+	// While rendering, accesses increment coarse x and y instead
+	p.writeRegister(ctrlReg2, mask3)
+	setV(0x0000)
+	p.readRegister(vRAMDataReg)
+	if p.v != 0x1001 {
+		t.Errorf("access during rendering: want v=$1001, got $%04X", p.v)
+	}
+}
+
+// stepToLine steps p to dot 0 of scanline.
+func stepToLine(p *ppu, scanline int) {
+	for p.scanline != scanline || p.dot != 0 {
+		p.step()
+	}
+}
+
+func TestPpuSpriteEvaluation(t *testing.T) {
+	p := newChrRAMPpu(t)
+	p.writeRegister(ctrlReg2, mask2|mask4)
+
+	// Nine sprites on scanlines 20-27, the rest offscreen
+	for n := range spriteCount {
+		y := byte(0xFF)
+		if n < 9 {
+			y = 19
+		}
+		p.sprRAM[n*4], p.sprRAM[n*4+1], p.sprRAM[n*4+3] = y, 0x01, byte(n*8)
+	}
+
+	stepToLine(p, 21)
+	if len(p.lineSprites) != spritesPerLine || !p.highScanlineSprites {
+		t.Errorf("sprite limit: want %v sprites and overflow, got %v and %v", spritesPerLine, len(p.lineSprites), p.highScanlineSprites)
+	}
+
+	p.unlimitedSprites = true
+	stepToLine(p, 22)
+	if len(p.lineSprites) != 9 {
+		t.Errorf("removed sprite limit: want 9 sprites, got %v", len(p.lineSprites))
+	}
+
+	// With only 8 sprites, the buggy overflow check reads the tile of sprite 9 as its y
+	// coordinate, falsely setting the flag
+	stepToLine(p, p.timing.preRenderLine())
+	p.unlimitedSprites = false
+	p.sprRAM[8*4], p.sprRAM[9*4+1] = 0xFF, 20
+	stepToLine(p, 0)
+	stepToLine(p, 21)
+	if !p.highScanlineSprites {
+		t.Errorf("overflow bug: want overflow set by sprite 9's tile")
+	}
+}
+
+func TestPpuSpriteZeroHit(t *testing.T) {
+	p := newChrRAMPpu(t)
+	p.write(nametableStart+3*32+2, 0x01) // tile at (16, 24)
+	p.write(paletteStart+0x11, 0x30)
+
+	// Sprite 0 is flipped, with its leftmost column covering the background tile's rightmost
+	// column; its other columns hang over transparent background
+	p.write(2*tileLen, 0x01)
+	p.sprRAM[0], p.sprRAM[1], p.sprRAM[2], p.sprRAM[3] = 23, 0x02, spriteFlipX, 23
+	p.writeRegister(ctrlReg2, mask1|mask2|mask3|mask4)
+
+	stepToLine(p, 24)
+	if p.spriteHit {
+		t.Fatalf("sprite 0 hit before sprite 0 is drawn")
+	}
+	for p.dot <= 24 {
+		p.step()
+	}
+	if !p.spriteHit {
+		t.Errorf("sprite 0 hit: want set at x=23")
+	}
+	if got := p.frame[24*frameWidth+23]; got != 0x30 {
+		t.Errorf("sprite pixel: want $30, got $%02X", got)
+	}
+	if got := p.frame[24*frameWidth+22]; got == 0x30 {
+		t.Errorf("flipped sprite: want transparent pixel at x=22")
+	}
+}
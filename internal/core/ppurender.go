@@ -0,0 +1,218 @@
+package core
+
+import "github.com/justinawrey/goretro/internal/app"
+
+// ppu frame timing, common to every region.  The remainder is given by the ppu's timing.
+// See https://wiki.nesdev.com/w/index.php/PPU_rendering.
+const (
+	dotsPerScanline = 341
+	postRenderLine  = 240
+)
+
+// ppu frame dimensions
+const (
+	frameWidth  = app.FrameWidth
+	frameHeight = app.FrameHeight
+	frameSize   = frameWidth * frameHeight
+)
+
+// Background fetch addresses
+const (
+	attributeOffset = 0x3C0 // offset of the attribute table within a nametable
+	tileLen         = 16    // bytes per pattern table tile
+)
+
+// renderingEnabled returns whether or not the background or sprites are shown.
+// While neither are, the ppu neither fetches nor renders, and vRAM may be accessed freely.
+func (p *ppu) renderingEnabled() bool {
+	return p.showBg || p.showSprites
+}
+
+// step advances the ppu by a single dot.
+func (p *ppu) step() {
+	visible := p.scanline < postRenderLine
+	if p.renderingEnabled() && (visible || p.scanline == p.timing.preRenderLine()) {
+		p.stepBackground()
+		if p.dot == spriteEvalDot {
+			p.sprRAMAddr = 0
+			p.evaluateSprites()
+		}
+	}
+	if visible && p.dot >= 1 && p.dot <= frameWidth {
+		p.renderPixel()
+	}
+
+	switch {
+	case p.scanline == postRenderLine && p.dot == 0:
+		p.output = p.frame
+		p.frameReady = true
+	case p.scanline == p.timing.vBlankLine && p.dot == 1:
+		p.vBlank = true
+		if p.nmi {
+			p.nmiPending = true
+		}
+	case p.scanline == p.timing.preRenderLine() && p.dot == 1:
+		p.vBlank = false
+		p.spriteHit = false
+		p.highScanlineSprites = false
+	}
+
+	p.advance()
+}
+
+// advance moves to the next dot, skipping the last dot of the pre-render line on odd NTSC
+// frames while rendering is enabled.
+func (p *ppu) advance() {
+	p.dot++
+	if p.timing.oddFrameSkip && p.scanline == p.timing.preRenderLine() && p.dot == dotsPerScanline-1 && p.oddFrame && p.renderingEnabled() {
+		p.dot++
+	}
+	if p.dot < dotsPerScanline {
+		return
+	}
+
+	p.dot = 0
+	p.scanline++
+	if p.scanline >= p.timing.scanlines {
+		p.scanline = 0
+		p.oddFrame = !p.oddFrame
+		p.frames++
+	}
+}
+
+// stepBackground runs the background fetch pipeline for the current dot.  Each tile takes
+// 8 dots to fetch: its nametable byte, attribute byte and the two planes of its pattern, after
+// which v moves to the next tile.  The first two tiles of a scanline are fetched at the end of
+// the previous one.
+func (p *ppu) stepBackground() {
+	fetching := p.dot >= 1 && p.dot <= frameWidth || p.dot >= 321 && p.dot <= 336
+	if p.dot >= 2 && p.dot <= 257 || p.dot >= 322 && p.dot <= 337 {
+		p.shiftBackground()
+	}
+	if p.dot%8 == 1 && (p.dot >= 9 && p.dot <= 257 || p.dot >= 329 && p.dot <= 337) {
+		p.loadBackground()
+	}
+	switch {
+	case p.dot == frameWidth:
+		p.incrementY()
+	case p.dot == frameWidth+1:
+		p.copyX()
+	case p.scanline == p.timing.preRenderLine() && p.dot >= 280 && p.dot <= 304:
+		p.copyY()
+	}
+	if !fetching {
+		return
+	}
+
+	switch (p.dot - 1) % 8 {
+	case 0:
+		p.ntByte = p.read(nametableStart | p.v&0x0FFF)
+	case 2:
+		at := p.read(nametableStart | attributeOffset | p.v&0x0C00 | p.v>>4&0x38 | p.v>>2&0x07)
+		shift := p.v>>4&0x04 | p.v&0x02
+		p.atBits = at >> shift & 0x03
+	case 4:
+		p.patLo = p.read(p.bgPtable + uint16(p.ntByte)*tileLen + p.fineY())
+	case 6:
+		p.patHi = p.read(p.bgPtable + uint16(p.ntByte)*tileLen + p.fineY() + 8)
+	case 7:
+		p.incrementX()
+	}
+}
+
+// fineY returns the fine y scroll held in v.
+func (p *ppu) fineY() uint16 {
+	return p.v >> 12
+}
+
+// incrementX moves v to the next tile horizontally, wrapping into the next nametable.
+func (p *ppu) incrementX() {
+	if p.v&0x001F == 31 {
+		p.v &^= 0x001F
+		p.v ^= 0x0400
+		return
+	}
+	p.v++
+}
+
+// incrementY moves v down a pixel, wrapping into the next tile and nametable.  Coarse y
+// rows 30 and 31 hold attributes, so row 29 wraps to the next nametable, while row 31 wraps
+// within the same nametable.
+func (p *ppu) incrementY() {
+	if p.v&0x7000 != 0x7000 {
+		p.v += 0x1000
+		return
+	}
+
+	p.v &^= 0x7000
+	switch coarseY := p.v & 0x03E0 >> 5; coarseY {
+	case 29:
+		p.v &^= 0x03E0
+		p.v ^= 0x0800
+	case 31:
+		p.v &^= 0x03E0
+	default:
+		p.v += 0x0020
+	}
+}
+
+// copyX copies the horizontal position from t into v.
+func (p *ppu) copyX() {
+	p.v = p.v&^0x041F | p.t&0x041F
+}
+
+// copyY copies the vertical position from t into v.
+func (p *ppu) copyY() {
+	p.v = p.v&^0x7BE0 | p.t&0x7BE0
+}
+
+// shiftBackground shifts the background shift registers by one pixel.
+func (p *ppu) shiftBackground() {
+	p.bgPatLo <<= 1
+	p.bgPatHi <<= 1
+	p.bgAttrLo <<= 1
+	p.bgAttrHi <<= 1
+}
+
+// loadBackground loads the latches of the most recently fetched tile into the low bytes of
+// the background shift registers.
+func (p *ppu) loadBackground() {
+	p.bgPatLo = p.bgPatLo&0xFF00 | uint16(p.patLo)
+	p.bgPatHi = p.bgPatHi&0xFF00 | uint16(p.patHi)
+
+	p.bgAttrLo &= 0xFF00
+	if p.atBits&mask0 != 0 {
+		p.bgAttrLo |= 0x00FF
+	}
+	p.bgAttrHi &= 0xFF00
+	if p.atBits&mask1 != 0 {
+		p.bgAttrHi |= 0x00FF
+	}
+}
+
+// backgroundPixel returns the palette RAM index of the background at pixel x of the current
+// scanline, or 0 if it is transparent.
+func (p *ppu) backgroundPixel(x int) (index byte) {
+	if !p.showBg || x < 8 && !p.showBgPixels {
+		return 0
+	}
+
+	bit := 15 - uint16(p.x)
+	pixel := byte(p.bgPatLo>>bit&1 | p.bgPatHi>>bit&1<<1)
+	if pixel == 0 {
+		return 0
+	}
+	palette := byte(p.bgAttrLo>>bit&1 | p.bgAttrHi>>bit&1<<1)
+	return palette<<2 | pixel
+}
+
+// renderPixel outputs the pixel at the current dot into the frame being drawn.
+func (p *ppu) renderPixel() {
+	x := p.dot - 1
+	var index byte
+	if p.renderingEnabled() {
+		index = p.composite(x, p.backgroundPixel(x))
+	}
+	color := p.readPalette(paletteStart + uint16(index))
+	p.frame[p.scanline*frameWidth+x] = p.emphasis()<<6 | uint16(color)
+}
@@ -0,0 +1,149 @@
+package core
+
+// Sprite limits
+const (
+	spriteCount      = sprRAMSize / 4 // sprites held in SPR-RAM
+	spritesPerLine   = 8              // sprites the ppu renders per scanline
+	spriteAttrMask   = 0xE3           // attribute bits implemented by SPR-RAM
+	spriteEvalDot    = 257            // dot at which sprites for the next scanline are evaluated
+	spritePaletteOff = 0x10           // offset of the sprite palettes within palette RAM
+)
+
+// Sprite attribute bits
+// See https://wiki.nesdev.com/w/index.php/PPU_OAM.
+const (
+	spritePalette  = mask01 // palette of the sprite
+	spriteBehindBg = mask5  // whether or not the sprite is drawn behind the background
+	spriteFlipX    = mask6  // whether or not the sprite is flipped horizontally
+	spriteFlipY    = mask7  // whether or not the sprite is flipped vertically
+)
+
+// sprite is a sprite selected for rendering on the current scanline.
+type sprite struct {
+	x     int  // leftmost pixel of the sprite
+	patLo byte // low pattern plane of the sprite's row, flipped horizontally if need be
+	patHi byte // high pattern plane of the sprite's row, flipped horizontally if need be
+	attr  byte // attribute byte
+	zero  bool // whether or not this is sprite 0
+}
+
+// inRange returns whether or not a sprite at y is on the scanline following scanline.
+func (p *ppu) inRange(y byte, scanline int) bool {
+	row := scanline - int(y)
+	return row >= 0 && row < p.sprSize
+}
+
+// evaluateSprites selects the sprites to render on the next scanline, and sets the sprite
+// overflow flag as the hardware does.  Once 8 sprites are found, the hardware keeps looking for
+// a ninth, but mistakenly increments the byte it compares within each sprite along with the
+// sprite, so the flag is both missed and falsely set.  Unless the sprite limit is removed, only
+// the first 8 sprites are rendered.
+// See https://wiki.nesdev.com/w/index.php/PPU_sprite_evaluation.
+func (p *ppu) evaluateSprites() {
+	p.lineSprites = p.lineSprites[:0]
+	if p.scanline == p.timing.preRenderLine() {
+		return
+	}
+
+	n := 0
+	for ; n < spriteCount && len(p.lineSprites) < spritesPerLine; n++ {
+		if p.inRange(p.sprRAM[n*4], p.scanline) {
+			p.fetchSprite(n)
+		}
+	}
+	full := len(p.lineSprites) == spritesPerLine
+
+	if p.unlimitedSprites {
+		for extra := n; extra < spriteCount; extra++ {
+			if p.inRange(p.sprRAM[extra*4], p.scanline) {
+				p.fetchSprite(extra)
+			}
+		}
+	}
+
+	for m := 0; full && n < spriteCount; n++ {
+		if p.inRange(p.sprRAM[n*4+m], p.scanline) {
+			p.highScanlineSprites = true
+			return
+		}
+		m = (m + 1) % 4
+	}
+}
+
+// fetchSprite fetches the pattern of sprite n's row on the next scanline into lineSprites.
+func (p *ppu) fetchSprite(n int) {
+	y, tile, attr, x := p.sprRAM[n*4], p.sprRAM[n*4+1], p.sprRAM[n*4+2], p.sprRAM[n*4+3]
+	row := uint16(p.scanline - int(y))
+	if attr&spriteFlipY != 0 {
+		row = uint16(p.sprSize-1) - row
+	}
+
+	// 8x16 sprites take their pattern table from bit 0 of the tile index, and are made of two
+	// consecutive tiles
+	table := p.sprPtable
+	if p.sprSize == 16 {
+		table = uint16(tile&mask0) * 0x1000
+		tile &^= mask0
+		if row >= 8 {
+			tile++
+			row -= 8
+		}
+	}
+
+	address := table + uint16(tile)*tileLen + row
+	s := sprite{x: int(x), patLo: p.read(address), patHi: p.read(address + 8), attr: attr, zero: n == 0}
+	if attr&spriteFlipX != 0 {
+		s.patLo, s.patHi = reverseBits(s.patLo), reverseBits(s.patHi)
+	}
+	p.lineSprites = append(p.lineSprites, s)
+}
+
+// reverseBits returns b with its bits in reverse order.
+func reverseBits(b byte) byte {
+	b = b&0xF0>>4 | b&0x0F<<4
+	b = b&0xCC>>2 | b&0x33<<2
+	return b&0xAA>>1 | b&0x55<<1
+}
+
+// spritePixel returns the frontmost opaque sprite at pixel x of the current scanline, and the
+// palette RAM index of its pixel, or ok == false if every sprite is transparent there.
+func (p *ppu) spritePixel(x int) (s sprite, index byte, ok bool) {
+	if !p.showSprites || x < 8 && !p.showSpritePixels {
+		return sprite{}, 0, false
+	}
+
+	for _, s := range p.lineSprites {
+		col := x - s.x
+		if col < 0 || col >= 8 {
+			continue
+		}
+		bit := 7 - col
+		pixel := s.patLo>>bit&1 | s.patHi>>bit&1<<1
+		if pixel == 0 {
+			continue
+		}
+		return s, spritePaletteOff | (s.attr&spritePalette)<<2 | pixel, true
+	}
+	return sprite{}, 0, false
+}
+
+// composite returns the palette RAM index of pixel x given the background index bg, setting
+// the sprite 0 hit flag if sprite 0 overlaps an opaque background pixel.
+// See https://wiki.nesdev.com/w/index.php/PPU_OAM#Sprite_0_hits.
+func (p *ppu) composite(x int, bg byte) (index byte) {
+	s, spr, ok := p.spritePixel(x)
+	if !ok {
+		return bg
+	}
+	if bg == 0 {
+		return spr
+	}
+
+	if s.zero && x != frameWidth-1 {
+		p.spriteHit = true
+	}
+	if s.attr&spriteBehindBg != 0 {
+		return bg
+	}
+	return spr
+}
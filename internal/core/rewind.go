@@ -0,0 +1,263 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/justinawrey/goretro/internal/log"
+)
+
+// Rewind constants
+const (
+	defaultRewindLimit    = 64 << 20 // bytes of snapshots kept for rewinding by default
+	defaultRewindInterval = 2        // frames between snapshots by default
+	maxRewindInterval     = 60       // most frames between snapshots
+	minDeltaRun           = 8        // unchanged bytes which end a run of changed bytes in a delta
+)
+
+// rewindEntry is a snapshot kept for rewinding.
+type rewindEntry struct {
+	state []byte    // save state, or a delta against the next newer state for older entries
+	audio []float32 // samples output in the frames leading up to the snapshot
+}
+
+// size returns the number of bytes held by e.
+func (e rewindEntry) size() int {
+	return len(e.state) + len(e.audio)*4
+}
+
+// rewindBuffer is a ring buffer of snapshots taken every interval frames, from which the nes can
+// be rewound.  Only the newest snapshot is kept whole; each older snapshot is kept as a delta
+// against the one after it, which is small since little changes between frames.  The oldest
+// snapshots are dropped to keep the buffer within limit bytes.
+type rewindBuffer struct {
+	limit        int     // bytes the buffer may hold, or 0 if rewinding is disabled
+	interval     int     // frames between snapshots
+	speed        float64 // frames rewound per frame run, while rewinding
+	reverseAudio bool    // whether audio is played backwards while rewinding, rather than muted
+
+	latest rewindEntry   // newest snapshot, whole
+	older  []rewindEntry // older snapshots, oldest first
+	size   int           // bytes held by latest and older
+
+	frames int       // frames run since the newest snapshot
+	audio  []float32 // samples output since the newest snapshot
+	behind float64   // frames rewound towards the next snapshot to load
+}
+
+// newRewindBuffer creates a rewind buffer with the default limit and interval.
+func newRewindBuffer() *rewindBuffer {
+	return &rewindBuffer{
+		limit:        defaultRewindLimit,
+		interval:     defaultRewindInterval,
+		speed:        1,
+		reverseAudio: true,
+	}
+}
+
+// push adds state, a snapshot taken after the samples audio were output, as the newest snapshot,
+// dropping the oldest snapshots if need be.
+func (b *rewindBuffer) push(state []byte, audio []float32) {
+	if b.latest.state != nil {
+		entry := rewindEntry{diffState(state, b.latest.state), b.latest.audio}
+		b.older = append(b.older, entry)
+		b.size += entry.size() - b.latest.size()
+	}
+	b.latest = rewindEntry{state, audio}
+	b.size += b.latest.size()
+
+	drop := 0
+	for ; b.size > b.limit && drop < len(b.older); drop++ {
+		b.size -= b.older[drop].size()
+	}
+	b.older = slices.Delete(b.older, 0, drop)
+}
+
+// pop removes and returns the newest snapshot, along with the samples output in the frames
+// leading up to it.  The oldest snapshot is returned again without audio rather than removed, so
+// that rewinding stops there.  ok is false if there are no snapshots.
+func (b *rewindBuffer) pop() (state []byte, audio []float32, ok bool) {
+	if b.latest.state == nil {
+		return nil, nil, false
+	}
+	if len(b.older) == 0 {
+		return b.latest.state, nil, true
+	}
+
+	state, audio = b.latest.state, b.latest.audio
+	last := b.older[len(b.older)-1]
+	b.older = b.older[:len(b.older)-1]
+	b.size -= b.latest.size() + last.size()
+	b.latest = rewindEntry{patchState(state, last.state), last.audio}
+	b.size += b.latest.size()
+	return state, audio, true
+}
+
+// clear drops every snapshot, such as when the game or its timeline changes.
+func (b *rewindBuffer) clear() {
+	*b = rewindBuffer{
+		limit:        b.limit,
+		interval:     b.interval,
+		speed:        b.speed,
+		reverseAudio: b.reverseAudio,
+	}
+}
+
+// diffState returns a delta from which patchState recreates target given base.  The delta holds
+// the length of target, followed by runs of bytes unchanged from base alternating with runs of
+// changed bytes, each preceded by its length.
+func diffState(base, target []byte) (delta []byte) {
+	unchanged := func(i int) bool {
+		return i < len(base) && base[i] == target[i]
+	}
+
+	common := min(len(base), len(target))
+
+	delta = binary.AppendUvarint(nil, uint64(len(target)))
+	for i := 0; i < len(target); {
+		// Unchanged bytes are skipped 8 at a time while possible, as most are unchanged
+		start := i
+		for i+8 <= common && bytes.Equal(base[i:i+8], target[i:i+8]) {
+			i += 8
+		}
+		for i < len(target) && unchanged(i) {
+			i++
+		}
+		delta = binary.AppendUvarint(delta, uint64(i-start))
+
+		// Changed bytes run until minDeltaRun unchanged bytes, which are cheaper as a run of their own
+		start = i
+		same := 0
+		for ; i < len(target) && same < minDeltaRun; i++ {
+			if unchanged(i) {
+				same++
+			} else {
+				same = 0
+			}
+		}
+		i -= same
+		delta = binary.AppendUvarint(delta, uint64(i-start))
+		delta = append(delta, target[start:i]...)
+	}
+	return delta
+}
+
+// patchState returns the target recreated from base and delta, as returned by diffState.
+func patchState(base, delta []byte) (target []byte) {
+	length, n := binary.Uvarint(delta)
+	delta = delta[n:]
+	target = make([]byte, length)
+
+	for i := 0; i < len(target); {
+		same, n := binary.Uvarint(delta)
+		delta = delta[n:]
+		copy(target[i:i+int(same)], base[i:])
+		i += int(same)
+
+		changed, n := binary.Uvarint(delta)
+		delta = delta[n:]
+		i += copy(target[i:i+int(changed)], delta)
+		delta = delta[changed:]
+	}
+	return target
+}
+
+// SetRewindBuffer sets the megabytes of snapshots kept for rewinding, or 0 to disable rewinding,
+// and the frames between snapshots, from 1 to 60.  Fewer frames between snapshots rewind more
+// smoothly, but keep less time within the same memory.
+func (n *nes) SetRewindBuffer(megabytes, interval int) error {
+	if megabytes < 0 {
+		return fmt.Errorf("rewind buffer of %vMB out of range", megabytes)
+	}
+	if interval < 1 || interval > maxRewindInterval {
+		return fmt.Errorf("rewind interval of %v frames out of range [1, %v]", interval, maxRewindInterval)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rewind.limit = megabytes << 20
+	n.rewind.interval = interval
+	n.rewind.clear()
+	return nil
+}
+
+// SetRewindSpeed sets the speed at which Rewind plays frames backwards relative to real time,
+// from 0.25 to 4, and whether audio is played backwards or muted while doing so.
+func (n *nes) SetRewindSpeed(speed float64, reverseAudio bool) error {
+	if speed < minSpeed || speed > maxSpeed {
+		return fmt.Errorf("rewind speed %v out of range [%v, %v]", speed, minSpeed, maxSpeed)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rewind.speed = speed
+	n.rewind.reverseAudio = reverseAudio
+	return nil
+}
+
+// Rewind sets whether or not the runner plays frames backwards, as while the rewind key is held.
+// It also rewinds while paused, leaving the nes paused once released.
+func (n *nes) Rewind(hold bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rewinding = hold
+	n.rewind.behind = 0
+}
+
+// recordRewind counts a frame run towards the next rewind snapshot, whose output was samples,
+// taking the snapshot once interval frames have been run.  n.mu must be held.
+func (n *nes) recordRewind(samples []float32) {
+	b := n.rewind
+	if b.limit == 0 || n.cart == nil {
+		return
+	}
+
+	b.frames++
+	b.audio = append(b.audio, samples...)
+	if b.frames < b.interval {
+		return
+	}
+
+	state, err := n.snapshot()
+	if err != nil {
+		log.Log(fmt.Sprintf("failed to take rewind snapshot: %v", err))
+		return
+	}
+	b.push(state, b.audio)
+	b.frames, b.audio = 0, nil
+}
+
+// rewindFrame rewinds by a frame at the rewind speed, loading an older snapshot once as many
+// frames as are between snapshots have been rewound, and returns the samples to play.
+// n.mu must be held.
+func (n *nes) rewindFrame() (samples []float32, loaded bool) {
+	b := n.rewind
+	b.frames, b.audio = 0, nil
+
+	var state []byte
+	for b.behind += b.speed; b.behind >= float64(b.interval); b.behind -= float64(b.interval) {
+		popped, audio, ok := b.pop()
+		if !ok {
+			break
+		}
+		state = popped
+		for i := len(audio) - 1; i >= 0; i-- {
+			samples = append(samples, audio[i])
+		}
+	}
+	if state == nil {
+		return nil, false
+	}
+
+	if err := n.restoreSnapshot(state); err != nil {
+		log.Log(fmt.Sprintf("failed to rewind: %v", err))
+		b.clear()
+		return nil, false
+	}
+	if !b.reverseAudio {
+		return nil, true
+	}
+	return resample(samples, 1/b.speed), true
+}
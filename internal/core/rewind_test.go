@@ -0,0 +1,79 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStateDelta(t *testing.T) {
+	base := make([]byte, 1000)
+	for i := range base {
+		base[i] = byte(i)
+	}
+	target := append(bytes.Clone(base), 1, 2, 3)
+	target[0], target[500], target[502], target[999] = 0xFF, 0xFF, 0xFF, 0xFF
+
+	delta := diffState(base, target)
+	if len(delta) > 32 {
+		t.Errorf("delta length: want at most 32, got %v", len(delta))
+	}
+	if got := patchState(base, delta); !bytes.Equal(got, target) {
+		t.Error("patched state differs from target")
+	}
+	if got := patchState(target, diffState(target, base)); !bytes.Equal(got, base) {
+		t.Error("patched shorter state differs from target")
+	}
+}
+
+func TestRewindBufferLimit(t *testing.T) {
+	b := newRewindBuffer()
+	b.limit = 3000
+	for i := range 10 {
+		state := make([]byte, 1000)
+		state[i] = 1
+		b.push(state, nil)
+	}
+
+	if b.size > b.limit {
+		t.Errorf("size: want at most %v, got %v", b.limit, b.size)
+	}
+	kept := 1 + len(b.older)
+	for i := 9; i > 9-kept; i-- {
+		state, _, ok := b.pop()
+		if !ok || state[i] != 1 {
+			t.Fatalf("snapshot %v: not popped in order", i)
+		}
+	}
+	if state, _, ok := b.pop(); !ok || state[10-kept] != 1 {
+		t.Error("oldest snapshot: want popped again, got otherwise")
+	}
+}
+
+func TestRewind(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetRewindBuffer(8, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var states [][]byte
+	for range 10 {
+		n.AdvanceFrame()
+		state, _ := n.SaveState()
+		states = append(states, state)
+	}
+
+	// The first frame rewound loads the newest snapshot, taken after the last frame
+	n.Rewind(true)
+	for i := 9; i >= 5; i-- {
+		if _, loaded := n.rewindFrame(); !loaded {
+			t.Fatalf("rewinding to frame %v: no snapshot loaded", i+1)
+		}
+		if got, _ := n.SaveState(); !bytes.Equal(got, states[i]) {
+			t.Errorf("rewinding to frame %v: state differs", i+1)
+		}
+	}
+	n.Rewind(false)
+}
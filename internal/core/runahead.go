@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/justinawrey/goretro/internal/log"
+)
+
+// maxRunAhead is the most frames the runner can run ahead.
+const maxRunAhead = 4
+
+// SetRunAhead sets the frames, from 0 to 4, that the runner runs ahead of the frame it has run
+// before showing a frame, hiding as many frames of the latency games have in responding to input.
+// Each frame, the nes is snapshotted, run ahead with the buttons held and restored.  If
+// secondInstance is set, it is instead run ahead on a second nes loaded with the snapshot, so
+// that the nes whose audio is played is never restored.
+func (n *nes) SetRunAhead(frames int, secondInstance bool) error {
+	if frames < 0 || frames > maxRunAhead {
+		return fmt.Errorf("run-ahead of %v frames out of range [0, %v]", frames, maxRunAhead)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.runAhead = frames
+	n.runAheadSecond = secondInstance
+	if frames == 0 || !secondInstance {
+		n.ahead = nil
+	}
+	return nil
+}
+
+// runAheadFrames runs ahead by runAhead frames, leaving n as it was, and returns the last frame
+// run ahead to.  Returns the last completed frame of n if run-ahead is disabled or fails, in
+// which case it is turned off.  n.mu must be held.
+func (n *nes) runAheadFrames() *[frameSize]uint16 {
+	if n.runAhead == 0 || n.cart == nil {
+		return &n.ppu.output
+	}
+
+	state, err := n.snapshot()
+	if err != nil {
+		n.stopRunningAhead(err)
+		return &n.ppu.output
+	}
+
+	runner := n
+	if n.runAheadSecond {
+		if runner, err = n.aheadInstance(); err != nil {
+			n.stopRunningAhead(err)
+			return &n.ppu.output
+		}
+		if err := runner.restoreSnapshot(state); err != nil {
+			n.stopRunningAhead(err)
+			return &n.ppu.output
+		}
+	}
+
+	for range n.runAhead {
+		runner.runFrame()
+	}
+	runner.apu.drainSamples()
+	if runner != n {
+		return &runner.ppu.output
+	}
+
+	// The frame run ahead to is overwritten by restoring, so keep it aside
+	ahead := n.ppu.output
+	if err := n.restoreSnapshot(state); err != nil {
+		n.stopRunningAhead(err)
+		return &n.ppu.output
+	}
+	return &ahead
+}
+
+// stopRunningAhead logs err, with which running ahead failed, and turns run-ahead off so that it
+// is not retried every frame.  n.mu must be held.
+func (n *nes) stopRunningAhead(err error) {
+	log.Log(fmt.Sprintf("failed to run ahead, turning run-ahead off: %v", err))
+	n.runAhead = 0
+	n.ahead = nil
+}
+
+// aheadInstance returns the second instance to run ahead on, creating it with a copy of the
+// cartridge if need be.  The instance has no display or audio, and reads the input of n.  It is
+// only used while n.mu is held.
+func (n *nes) aheadInstance() (*nes, error) {
+	if n.ahead != nil {
+		return n.ahead, nil
+	}
+
+	cart := n.cart.clone()
+	m, err := newMapper(cart)
+	if err != nil {
+		return nil, err
+	}
+
+	n.ahead = NewNes(nil, n.input, nil)
+	n.ahead.region = n.region
+	n.ahead.insertCartridge(cart, m)
+	n.ahead.rewind.limit = 0
+	n.syncAhead()
+	return n.ahead, nil
+}
+
+// syncAhead copies the display settings of n to its second instance, if it has one, so that
+// frames run ahead on it are rendered as n renders them.  n.mu must be held.
+func (n *nes) syncAhead() {
+	if n.ahead == nil {
+		return
+	}
+	n.ahead.palette = n.palette
+	n.ahead.ppu.unlimitedSprites = n.ppu.unlimitedSprites
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRunAhead(t *testing.T) {
+	path := writeProgram(t, schedulerProgram, 0xC00E, 0xC011)
+	for _, second := range []bool{false, true} {
+		n := NewNes(nil, nil, nil)
+		if err := n.UseCartridge(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.SetRunAhead(2, second); err != nil {
+			t.Fatal(err)
+		}
+		twin := NewNes(nil, nil, nil)
+		if err := twin.UseCartridge(path); err != nil {
+			t.Fatal(err)
+		}
+
+		for range 3 {
+			n.runFrameRealtime()
+			twin.RunFrame()
+		}
+
+		// Running ahead leaves the nes as it was, but shows the frame 2 frames ahead
+		got, _ := n.SaveState()
+		want, _ := twin.SaveState()
+		if !bytes.Equal(got, want) {
+			t.Errorf("second instance %v: state after running ahead differs", second)
+		}
+		shown := n.rgba
+		for range 2 {
+			twin.RunFrame()
+		}
+		twin.convertFrame(&twin.ppu.output)
+		if shown != twin.rgba {
+			t.Errorf("second instance %v: shown frame is not the frame run ahead to", second)
+		}
+	}
+
+	// The second instance renders with the display settings of the nes
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(path); err != nil {
+		t.Fatal(err)
+	}
+	n.RemoveSpriteLimit(true)
+	n.SetRunAhead(1, true)
+	n.runFrameRealtime()
+	n.GeneratePalette(30, 1)
+	if n.ahead == nil || !n.ahead.ppu.unlimitedSprites || n.ahead.palette != n.palette {
+		t.Error("second instance: want the sprite limit and palette of the nes")
+	}
+
+	if err := n.SetRunAhead(maxRunAhead+1, false); err == nil {
+		t.Error("run-ahead of 5 frames: want error, got none")
+	}
+}
@@ -0,0 +1,330 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/justinawrey/goretro/internal/app"
+)
+
+// pacing is the way the runner keeps emulation in time with the host.
+type pacing int
+
+// Pacing modes
+const (
+	// pacingVideo runs frames at the frame rate of the region, by a drift correcting timer.
+	// Audio is played as emulated.
+	pacingVideo pacing = iota
+	// pacingAudio runs each frame once the webview's audio buffer has drained to audioTarget,
+	// so that its fill level rather than a timer sets the pace, and slightly resamples audio
+	// to smooth out the level between frames.  The buffer neither underruns and crackles nor
+	// grows and lags, while video stays smooth.  It applies at normal speed only.
+	pacingAudio
+)
+
+// String implements Stringer.
+func (p pacing) String() string {
+	switch p {
+	case pacingAudio:
+		return "audio"
+	default:
+		return "video"
+	}
+}
+
+// Runner constants
+const (
+	minSpeed        = 0.25                  // slowest speed the runner runs at
+	maxSpeed        = 4                     // fastest speed the runner runs at, other than unlimited
+	maxFramesBehind = 4                     // frames the runner may fall behind before giving up on catching up
+	audioTarget     = 0.05                  // seconds of audio the webview should keep queued
+	maxRateDelta    = 0.005                 // most that pacingAudio resamples audio by
+	statsInterval   = time.Second           // interval over which fps is measured
+	idleInterval    = 50 * time.Millisecond // interval at which the runner checks for a cartridge
+)
+
+// Start starts a goroutine running the loaded cartridge or NSF in real time, sending frames to
+// the display and samples to the audio driver.  It does nothing if the nes is already running.
+func (n *nes) Start() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopRunner != nil {
+		return
+	}
+	n.stopRunner = make(chan struct{})
+	n.runnerDone = make(chan struct{})
+	go n.run(n.stopRunner, n.runnerDone)
+}
+
+// Stop stops the goroutine started by Start, waiting for it to finish its frame.
+func (n *nes) Stop() {
+	n.mu.Lock()
+	stop, done := n.stopRunner, n.runnerDone
+	n.stopRunner, n.runnerDone = nil, nil
+	n.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// SetPacing selects how the runner paces emulation: video, to run frames at the frame rate of
+// the region, or audio, to run them as the webview's audio buffer drains, keeping it from
+// crackling.
+func (n *nes) SetPacing(mode string) error {
+	var p pacing
+	switch strings.ToLower(mode) {
+	case pacingVideo.String():
+		p = pacingVideo
+	case pacingAudio.String():
+		p = pacingAudio
+	default:
+		return fmt.Errorf("unknown pacing %q", mode)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pacing = p
+	return nil
+}
+
+// Pause pauses the runner, until Resume is called.  Frames may still be run one at a time by
+// AdvanceFrame.
+func (n *nes) Pause() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.paused = true
+}
+
+// Resume resumes the runner after Pause.
+func (n *nes) Resume() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.paused = false
+}
+
+// Paused returns whether or not the runner is paused.
+func (n *nes) Paused() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.paused
+}
+
+// AdvanceFrame pauses the runner, and runs exactly one frame with the buttons held now.
+// Its audio is muted, since a lone frame of audio only clicks.
+func (n *nes) AdvanceFrame() {
+	n.mu.Lock()
+	n.paused = true
+	if n.mapper == nil {
+		n.mu.Unlock()
+		return
+	}
+	n.runFrame()
+	n.recordRewind(n.apu.drainSamples())
+	n.mu.Unlock()
+
+	n.present()
+}
+
+// SetSpeed sets the speed the runner runs at relative to real time: from 0.25 for slow motion
+// to 4 for fast forward, or 0 to run as fast as possible.  Audio is stretched while running
+// slower than real time, and muted while running faster.
+func (n *nes) SetSpeed(speed float64) error {
+	if speed != 0 && (speed < minSpeed || speed > maxSpeed) {
+		return fmt.Errorf("speed %v out of range: want 0 or %v-%v", speed, minSpeed, maxSpeed)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.speed = speed
+	return nil
+}
+
+// Speed returns the speed the runner runs at relative to real time, or 0 if it is unlimited.
+func (n *nes) Speed() float64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.speed
+}
+
+// FrameStats returns the timing of the frames run by the runner.
+func (n *nes) FrameStats() app.FrameStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	stats := n.stats.FrameStats
+	stats.TargetFPS = n.timing.frameRate()
+	return stats
+}
+
+// frameStats accumulates app.FrameStats.
+type frameStats struct {
+	app.FrameStats
+
+	total       time.Duration // summed time taken to emulate each frame
+	windowStart time.Time     // start of the interval over which fps is being measured
+	window      int           // frames run in the interval
+}
+
+// record records a frame which took took to emulate, and finished at end.  late is whether
+// or not the frame finished after it was due.
+func (s *frameStats) record(took time.Duration, end time.Time, late bool) {
+	s.Frames++
+	if late {
+		s.Late++
+	}
+	s.total += took
+	s.LastMs = float64(took) / float64(time.Millisecond)
+	s.MeanMs = float64(s.total) / float64(s.Frames) / float64(time.Millisecond)
+	s.MaxMs = max(s.MaxMs, s.LastMs)
+
+	s.window++
+	if elapsed := end.Sub(s.windowStart); elapsed >= statsInterval {
+		s.FPS = float64(s.window) / elapsed.Seconds()
+		s.windowStart, s.window = end, 0
+	}
+}
+
+// run runs frames until stop is closed, then closes done.  The deadline of each frame follows
+// that of the last by the frame period, rather than being set from when it ended, so that
+// timer and scheduling jitter does not accumulate into drift.
+func (n *nes) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var due time.Time
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		n.mu.Lock()
+		idle := n.mapper == nil || n.paused && !n.rewinding
+		rewinding := n.rewinding
+		var period time.Duration
+		switch {
+		case rewinding:
+			period = time.Duration(float64(time.Second) / n.timing.frameRate())
+		case n.speed != 0:
+			period = time.Duration(float64(time.Second) / n.timing.frameRate() / n.speed)
+		}
+		n.mu.Unlock()
+		if idle {
+			due = time.Time{}
+			timer.Reset(idleInterval)
+			continue
+		}
+
+		start := time.Now()
+		if due.IsZero() || start.Sub(due) > maxFramesBehind*period {
+			// Starting, or too far behind to catch up
+			due = start
+		}
+		due = due.Add(period)
+
+		if rewinding {
+			n.rewindFrameRealtime()
+		} else {
+			n.runFrameRealtime()
+		}
+
+		end := time.Now()
+		n.mu.Lock()
+		n.stats.record(end.Sub(start), end, end.After(due))
+		if !rewinding && n.pacing == pacingAudio && n.speed == 1 && n.audio != nil {
+			due = n.audioDue(end, period)
+		}
+		n.mu.Unlock()
+		timer.Reset(due.Sub(end))
+	}
+}
+
+// audioDue returns when the frame after one ending at end is due under pacingAudio: once the
+// audio buffered beyond audioTarget has played.  It is due at most two periods away, so that
+// an audio context which is not playing, as before the user first interacts with the webview,
+// slows emulation rather than stopping it.  n.mu must be held.
+func (n *nes) audioDue(end time.Time, period time.Duration) time.Time {
+	excess := time.Duration((n.audio.Buffered() - audioTarget) * float64(time.Second))
+	return end.Add(max(min(excess, 2*period), 0))
+}
+
+// runFrameRealtime runs a frame, sending it to the display and its samples to the audio driver.
+// Faster than real time, frames are only sent as often as the display shows them, and audio
+// is muted.  Slower than real time, audio is stretched to the real duration of the frame.
+func (n *nes) runFrameRealtime() {
+	n.mu.Lock()
+	n.runFrame()
+	samples := n.apu.drainSamples()
+	n.recordRewind(samples)
+	speed := n.speed
+	switch {
+	case speed == 0 || speed > 1:
+		samples = nil
+	case speed < 1:
+		samples = resample(samples, 1/speed)
+	case n.pacing == pacingAudio && n.audio != nil:
+		samples = resample(samples, rateControl(n.audio.Buffered()))
+	}
+
+	now := time.Now()
+	show := speed != 0 && speed <= 1 || now.Sub(n.presented).Seconds() >= 1/n.timing.frameRate()
+	if show {
+		n.presented = now
+		n.convertFrame(n.runAheadFrames())
+	}
+	n.mu.Unlock()
+
+	if show {
+		n.sendFrame()
+	}
+	if n.audio != nil && len(samples) > 0 {
+		n.audio.PlaySamples(samples, sampleRate)
+	}
+}
+
+// rewindFrameRealtime rewinds by a frame, sending the frame rewound to, if any, to the display
+// and the reversed samples to the audio driver.
+func (n *nes) rewindFrameRealtime() {
+	n.mu.Lock()
+	samples, loaded := n.rewindFrame()
+	n.mu.Unlock()
+
+	if loaded {
+		n.present()
+	}
+	if n.audio != nil && len(samples) > 0 {
+		n.audio.PlaySamples(samples, sampleRate)
+	}
+}
+
+// rateControl returns the ratio by which to resample audio given the seconds of audio buffered,
+// producing more samples while the buffer is below audioTarget, and fewer while above it.
+func rateControl(buffered float64) (ratio float64) {
+	deviation := max(min(1-buffered/audioTarget, 1), -1)
+	return 1 + maxRateDelta*deviation
+}
+
+// resample linearly resamples samples to ratio times as many samples.
+func resample(samples []float32, ratio float64) (resampled []float32) {
+	if len(samples) == 0 {
+		return samples
+	}
+
+	resampled = make([]float32, int(float64(len(samples))*ratio+0.5))
+	step := float64(len(samples)) / float64(len(resampled))
+	for i := range resampled {
+		pos := float64(i) * step
+		j := int(pos)
+		next := min(j+1, len(samples)-1)
+		frac := float32(pos - float64(j))
+		resampled[i] = samples[j]*(1-frac) + samples[next]*frac
+	}
+	return resampled
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/justinawrey/goretro/internal/app"
+)
+
+func TestRateControl(t *testing.T) {
+	if got := rateControl(audioTarget); got != 1 {
+		t.Errorf("buffer at target: want ratio 1, got %v", got)
+	}
+	if got := rateControl(0); got != 1+maxRateDelta {
+		t.Errorf("empty buffer: want ratio %v, got %v", 1+maxRateDelta, got)
+	}
+	if got := rateControl(10 * audioTarget); got != 1-maxRateDelta {
+		t.Errorf("full buffer: want ratio %v, got %v", 1-maxRateDelta, got)
+	}
+
+	samples := make([]float32, 800)
+	for i := range samples {
+		samples[i] = float32(i)
+	}
+	resampled := resample(samples, 1.005)
+	if len(resampled) != 804 {
+		t.Fatalf("resampled length: want 804, got %v", len(resampled))
+	}
+	if resampled[0] != 0 || resampled[803] > 799 || resampled[402] < 399 || resampled[402] > 401 {
+		t.Errorf("resampled ramp: got %v, %v and %v", resampled[0], resampled[402], resampled[803])
+	}
+}
+
+func TestRunner(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+
+	n.Start()
+	n.Start()
+	time.Sleep(200 * time.Millisecond)
+	n.Stop()
+
+	// About 12 frames are due in 200ms
+	stats := n.FrameStats()
+	if stats.Frames < 3 || stats.Frames > 14 {
+		t.Errorf("frames run: want about 12, got %v", stats.Frames)
+	}
+	if stats.TargetFPS < 60 || stats.TargetFPS > 60.1 {
+		t.Errorf("target fps: want 60.0988, got %v", stats.TargetFPS)
+	}
+
+	frames := stats.Frames
+	time.Sleep(50 * time.Millisecond)
+	if got := n.FrameStats().Frames; got != frames {
+		t.Errorf("frames run after stopping: want %v, got %v", frames, got)
+	}
+}
+
+func TestRunnerAudioPacing(t *testing.T) {
+	audio := app.NewWebviewAudioDriver()
+	n := NewNes(nil, nil, audio)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetPacing("audio"); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a second of audio queued, frames wait on the buffer rather than the frame timer
+	audio.SetBuffered(1)
+	n.Start()
+	time.Sleep(300 * time.Millisecond)
+	n.Stop()
+
+	if frames := n.FrameStats().Frames; frames < 3 || frames > 12 {
+		t.Errorf("frames run with a full audio buffer: want about 9 rather than 18, got %v", frames)
+	}
+}
+
+func TestPauseAndAdvanceFrame(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.SetSpeed(3); err != nil {
+		t.Errorf("speed 3: want no error, got %v", err)
+	}
+	if err := n.SetSpeed(8); err == nil {
+		t.Error("speed 8: want error, got none")
+	}
+
+	n.Pause()
+	n.Start()
+	time.Sleep(100 * time.Millisecond)
+	if got := n.FrameStats().Frames; got != 0 {
+		t.Errorf("frames run while paused: want 0, got %v", got)
+	}
+
+	// Each frame's nmi increments $00, but the first frame completes before its vblank
+	for range 3 {
+		n.AdvanceFrame()
+	}
+	n.Stop()
+	if got := n.mem.internal[0]; got != 2 {
+		t.Errorf("nmis after advancing 3 frames: want 2, got %v", got)
+	}
+	if !n.Paused() {
+		t.Error("paused after advancing: want true, got false")
+	}
+}
+
+// TestLoadStateWhileRunning loads states from two goroutines while the runner runs, each of which
+// sends frames to the display.  Run with -race to check that frames are not sent while written;
+// GORACE=history_size=7 keeps enough history for such a race to be reported.
+func TestLoadStateWhileRunning(t *testing.T) {
+	n := NewNes(app.NewWebviewDisplayDriver(), nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	n.RunFrame()
+	state, err := n.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Start()
+	defer n.Stop()
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for end := time.Now().Add(200 * time.Millisecond); time.Now().Before(end); {
+				if err := n.LoadState(state); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,158 @@
+package core
+
+import "github.com/justinawrey/goretro/internal/app"
+
+// The scheduler keeps every component of the nes in step with the cpu.  After each cpu
+// instruction, the ppu, apu and mapper are run for the cpu cycles it took, at the clock ratio
+// of the region, and interrupts raised meanwhile are delivered before the next instruction.
+// The master clock is counted in cpu cycles, since the cpu is the slowest component.
+// See https://wiki.nesdev.com/w/index.php/Cycle_reference_chart.
+
+// RunFrame runs the nes until the ppu completes a frame, which is then sent to the display.
+func (n *nes) RunFrame() {
+	n.mu.Lock()
+	n.runFrame()
+	n.mu.Unlock()
+	n.present()
+}
+
+// RunCycles runs the nes for at least cycles cpu cycles.  The last instruction run may end after
+// them, in which case the excess cycles are not made up for by later calls.
+func (n *nes) RunCycles(cycles int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for end := n.cpu.cycles + cycles; n.cpu.cycles < end; {
+		n.step()
+	}
+}
+
+// StepInstruction runs the nes for a single cpu instruction, along with any interrupt or dma
+// preceding or triggered by it.
+func (n *nes) StepInstruction() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.step()
+}
+
+// runFrame runs the nes until the ppu completes a frame, with the buttons held at its start.
+// n.mu must be held.
+func (n *nes) runFrame() {
+	n.latchInput()
+	n.ppu.frameReady = false
+	for !n.ppu.frameReady {
+		n.step()
+	}
+}
+
+// present sends the last completed frame to the display.
+func (n *nes) present() {
+	n.mu.Lock()
+	n.convertFrame(&n.ppu.output)
+	n.mu.Unlock()
+	n.sendFrame()
+}
+
+// convertFrame converts frame, as output by the ppu, to the RGBA frame sent by sendFrame.
+// n.mu must be held.
+func (n *nes) convertFrame(frame *[frameSize]uint16) {
+	n.palette.toRGBA(frame, n.rgba[:])
+}
+
+// sendFrame sends the frame last converted by convertFrame to the display.  The frame is copied
+// under n.mu, since it may be converted again while being encoded for the display.
+func (n *nes) sendFrame() {
+	if n.disp == nil {
+		return
+	}
+
+	n.sendMu.Lock()
+	defer n.sendMu.Unlock()
+	n.mu.Lock()
+	n.sent = n.rgba
+	n.mu.Unlock()
+	n.disp.RenderFrame(n.sent[:])
+}
+
+// latchInput updates the joypads with the buttons held on the input driver.  Input is only
+// latched between frames, so that a frame sees the same buttons however long it takes to run.
+// n.mu must be held.
+func (n *nes) latchInput() {
+	if n.input == nil {
+		return
+	}
+	n.mem.joypads[0].buttons = n.input.JoypadButtons(app.Primary)
+	n.mem.joypads[1].buttons = n.input.JoypadButtons(app.Secondary)
+}
+
+// step executes a single cpu instruction, along with any dma it triggers, running the ppu and
+// clocking the apu and mapper for each cpu cycle taken.  Interrupts pending beforehand are
+// handled first.  n.mu must be held.
+func (n *nes) step() {
+	n.pollInterrupts()
+
+	start := n.cpu.cycles
+	n.cpu.step()
+	n.clock(n.cpu.cycles - start)
+
+	if page, ok := n.mem.takeDMA(); ok {
+		n.oamDMA(page)
+	}
+	for stall := n.apu.takeStall(); stall > 0; stall = n.apu.takeStall() {
+		n.cpu.cycles += stall
+		n.clock(stall)
+	}
+}
+
+// pollInterrupts delivers a pending nmi to the cpu, or otherwise an irq if any component is
+// asserting one and the cpu has not disabled them.  nmis are edge triggered, and so are
+// delivered once, while irqs are level triggered, and so are delivered until acknowledged.
+// See https://wiki.nesdev.com/w/index.php/CPU_interrupts.
+func (n *nes) pollInterrupts() {
+	if n.ppu.nmiPending {
+		n.ppu.nmiPending = false
+		n.cpu.GenerateInterrupt(nmi)
+		return
+	}
+	if n.cpu.mustHandleInterrupt || n.cpu.status.i {
+		return
+	}
+
+	m, _ := n.mapper.(interrupter)
+	if n.apu.irqPending() || m != nil && m.irqPending() {
+		n.cpu.GenerateInterrupt(irq)
+	}
+}
+
+// clock runs the ppu, apu and mapper for cycles cpu cycles.  It does not advance the cpu.
+func (n *nes) clock(cycles int) {
+	clock, _ := n.mapper.(clocked)
+	for range cycles {
+		for n.dotClock += n.timing.dots; n.dotClock >= n.timing.cycles; n.dotClock -= n.timing.cycles {
+			n.ppu.step()
+		}
+		n.apu.clock()
+		if clock != nil {
+			clock.clock()
+		}
+	}
+}
+
+// powerOn returns the cpu, apu and cpu RAM to their power up state, with the cpu about to run
+// from the reset vector of the loaded cartridge.  n.mu must be held.
+// See https://wiki.nesdev.com/w/index.php/CPU_power_up_state.
+func (n *nes) powerOn() {
+	n.mem.clear()
+
+	n.apu = newApu(n.timing)
+	n.apu.useMemory(n.mem)
+	expansion, _ := n.mapper.(expansionAudio)
+	n.apu.useExpansionAudio(expansion)
+	n.mem.useApu(n.apu)
+
+	n.cpu.Clear()
+	n.cpu.Init()
+	n.cpu.mustHandleInterrupt = false
+	n.cpu.pc = n.cpu.read16(rstVector)
+	n.ppu.nmiPending = false
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeProgram writes an NROM rom whose 16kB prg ROM bank holds program at $C000, with the nmi
+// and irq vectors pointing at nmi and irq.
+func writeProgram(t *testing.T, program []byte, nmi, irq uint16) string {
+	t.Helper()
+
+	prg := make([]byte, prgROMBankLen)
+	copy(prg, program)
+	for vector, address := range map[uint16]uint16{nmiVector: nmi, rstVector: 0xC000, irqVector: irq} {
+		prg[vector-0xC000], prg[vector-0xC000+1] = byte(address), byte(address>>8)
+	}
+
+	rom := append([]byte{'N', 'E', 'S', 0x1A, 1, 0}, make([]byte, iNesHeaderLen-6)...)
+	path := filepath.Join(t.TempDir(), "program.nes")
+	if err := os.WriteFile(path, append(rom, prg...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// schedulerProgram enables vblank nmis and apu frame irqs, then loops.  The nmi handler
+// increments $00, and the irq handler increments $01.  Its CLI, at offset 10, may be patched to
+// SEI to leave irqs disabled.
+var schedulerProgram = []byte{
+	0xA9, 0x80, // LDA #$80
+	0x8D, 0x00, 0x20, // STA $2000
+	0xA9, 0x00, // LDA #$00
+	0x8D, 0x17, 0x40, // STA $4017
+	0x58,             // CLI
+	0x4C, 0x0B, 0xC0, // JMP $C00B
+	0xE6, 0x00, // nmi: INC $00
+	0x40,       // RTI
+	0xE6, 0x01, // irq: INC $01
+	0xAD, 0x15, 0x40, // LDA $4015
+	0x40, // RTI
+}
+
+func TestSchedulerInterrupts(t *testing.T) {
+	for _, cli := range []bool{true, false} {
+		program := append([]byte{}, schedulerProgram...)
+		if !cli {
+			program[10] = 0x78 // SEI
+		}
+
+		n := NewNes(nil, nil, nil)
+		if err := n.UseCartridge(writeProgram(t, program, 0xC00E, 0xC011)); err != nil {
+			t.Fatal(err)
+		}
+
+		const frames = 10
+		for range frames {
+			n.RunFrame()
+		}
+
+		// The first frame completes before its vblank
+		if got := n.mem.internal[0]; got != frames-1 {
+			t.Errorf("nmis: want %v, got %v", frames-1, got)
+		}
+		irqs := n.mem.internal[1]
+		switch {
+		case cli && (irqs < frames-2 || irqs > frames):
+			t.Errorf("irqs: want about %v, got %v", frames, irqs)
+		case !cli && irqs != 0:
+			t.Errorf("irqs with interrupts disabled: want 0, got %v", irqs)
+		}
+	}
+}
+
+func TestSchedulerRunCycles(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+
+	n.RunCycles(1000)
+	if n.cpu.cycles < 1000 || n.cpu.cycles > 1000+interruptCycleCost+6 {
+		t.Errorf("cycles run: want about 1000, got %v", n.cpu.cycles)
+	}
+	if dots := n.ppu.scanline*dotsPerScanline + n.ppu.dot; dots != n.cpu.cycles*3 {
+		t.Errorf("ppu dots: want %v, got %v", n.cpu.cycles*3, dots)
+	}
+
+	pc := n.cpu.pc
+	n.StepInstruction()
+	if n.cpu.pc != 0xC00B || pc != 0xC00B {
+		t.Errorf("looping: want pc $C00B, got $%04X and $%04X", pc, n.cpu.pc)
+	}
+}
+
+func TestSchedulerJammed(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	program := []byte{0xE6, 0x00, 0x02} // INC $00, then an unsupported opcode
+	if err := n.UseCartridge(writeProgram(t, program, 0xC000, 0xC000)); err != nil {
+		t.Fatal(err)
+	}
+
+	n.RunFrame()
+	frames := n.ppu.frames
+	n.RunFrame()
+	if err := n.Fault(); !IsInvalidOpcodeErr(errors.Unwrap(err)) || !strings.Contains(err.Error(), "$C002") {
+		t.Errorf("fault: want an invalid opcode at $C002, got %v", err)
+	}
+	if got := n.mem.internal[0]; got != 1 {
+		t.Errorf("instructions run before jamming: want $00 incremented once, got %v", got)
+	}
+	if n.ppu.frames != frames+1 {
+		t.Errorf("frames: want one completed while jammed, got %v", n.ppu.frames-frames)
+	}
+
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Fault(); err != nil {
+		t.Errorf("fault after inserting a cartridge: want nil, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/justinawrey/goretro/internal/app"
+)
+
+// Save slot constants
+const (
+	slotCount    = 10       // quick save slots per game
+	thumbScale   = 2        // factor by which thumbnails are smaller than the frame
+	stateFileExt = ".state" // extension of files holding a save state
+	thumbFileExt = ".png"   // extension of files holding the thumbnail of a save state
+	infoFileExt  = ".json"  // extension of files holding the app.SaveSlot of a save state
+)
+
+// slotsDir returns the directory holding the save slots of the rom at romPath, or of entry
+// within it if romPath is an archive.  Slots are kept in a states directory next to the rom,
+// unless statesDir is set.
+func slotsDir(romPath, entry, statesDir string) string {
+	dir, name := filepath.Split(romPath)
+	if entry != "" {
+		name = filepath.Base(entry)
+	}
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if statesDir == "" {
+		statesDir = filepath.Join(dir, "states")
+	}
+
+	return filepath.Join(statesDir, name)
+}
+
+// SetStatesDir sets the directory in which save slots are kept, in a directory per game.
+// If dir is empty, they are kept in a states directory next to each rom.
+func (n *nes) SetStatesDir(dir string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.statesDir = dir
+}
+
+// SaveSlots returns the save slots of the loaded game, with thumbnails of those in use.
+func (n *nes) SaveSlots() ([]app.SaveSlot, error) {
+	var paths [slotCount]string
+	n.mu.Lock()
+	for i := range paths {
+		var err error
+		if paths[i], err = n.slotPath(i + 1); err != nil {
+			n.mu.Unlock()
+			return nil, err
+		}
+	}
+	n.mu.Unlock()
+
+	slots := make([]app.SaveSlot, slotCount)
+	for i, path := range paths {
+		var err error
+		if slots[i], err = readSlot(path, i+1); err != nil {
+			return nil, err
+		}
+	}
+	return slots, nil
+}
+
+// SaveToSlot saves the state of the nes to slot, from 1 to 10, along with a thumbnail of the
+// current frame, overwriting any state already saved there.
+func (n *nes) SaveToSlot(slot int) error {
+	n.mu.Lock()
+	path, err := n.slotPath(slot)
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	state, err := n.snapshot()
+	thumb := n.thumbnail()
+	info := app.SaveSlot{
+		Slot:     slot,
+		Used:     true,
+		SavedAt:  time.Now().UnixMilli(),
+		PlayTime: float64(n.ppu.frames) / n.timing.frameRate(),
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, thumb); err != nil {
+		return err
+	}
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	// The info file marks the slot as used, so it is removed first and written last, lest the
+	// slot show the info and thumbnail of an older state if saving is interrupted
+	if err := os.Remove(path + infoFileExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, file := range []struct {
+		ext  string
+		data []byte
+	}{
+		{stateFileExt, state},
+		{thumbFileExt, encoded.Bytes()},
+		{infoFileExt, infoJSON},
+	} {
+		if err := writeFileAtomic(path+file.ext, file.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFromSlot restores the nes to the state saved in slot, from 1 to 10.
+func (n *nes) LoadFromSlot(slot int) error {
+	n.mu.Lock()
+	path, err := n.slotPath(slot)
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	state, err := os.ReadFile(path + stateFileExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errSaveState(fmt.Sprintf("slot %v is empty", slot))
+	}
+	if err != nil {
+		return err
+	}
+	return n.LoadState(state)
+}
+
+// DeleteSlot deletes the state saved in slot, from 1 to 10, if there is one.
+func (n *nes) DeleteSlot(slot int) error {
+	n.mu.Lock()
+	path, err := n.slotPath(slot)
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, ext := range []string{infoFileExt, thumbFileExt, stateFileExt} {
+		if err := os.Remove(path + ext); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// slotPath returns the path of the files of slot of the loaded game, without their extension.
+// n.mu must be held.
+func (n *nes) slotPath(slot int) (string, error) {
+	if slot < 1 || slot > slotCount {
+		return "", errSaveState(fmt.Sprintf("slot %v out of range [1, %v]", slot, slotCount))
+	}
+
+	if n.cart == nil {
+		return "", errSaveState("no cartridge loaded")
+	}
+	return filepath.Join(slotsDir(n.cart.path, n.cart.entry, n.statesDir), fmt.Sprintf("slot%v", slot)), nil
+}
+
+// readSlot returns the app.SaveSlot of the files of slot at path, without their extension.
+// Slots without an info file are returned as unused.
+func readSlot(path string, slot int) (info app.SaveSlot, err error) {
+	infoJSON, err := os.ReadFile(path + infoFileExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return app.SaveSlot{Slot: slot}, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(infoJSON, &info); err != nil {
+		return info, errSaveState(fmt.Sprintf("slot %v: %v", slot, err))
+	}
+
+	thumb, err := os.ReadFile(path + thumbFileExt)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+	info.Slot, info.Used = slot, true
+	info.Thumbnail = base64.StdEncoding.EncodeToString(thumb)
+	return info, nil
+}
+
+// thumbnail returns the last completed frame, scaled down by thumbScale.  n.mu must be held.
+func (n *nes) thumbnail() *image.RGBA {
+	var rgba [frameSize * 4]byte
+	n.palette.toRGBA(&n.ppu.output, rgba[:])
+
+	thumb := image.NewRGBA(image.Rect(0, 0, frameWidth/thumbScale, frameHeight/thumbScale))
+	for y := range thumb.Rect.Dy() {
+		for x := range thumb.Rect.Dx() {
+			src := (y*thumbScale*frameWidth + x*thumbScale) * 4
+			copy(thumb.Pix[thumb.PixOffset(x, y):], rgba[src:src+4])
+		}
+	}
+	return thumb
+}
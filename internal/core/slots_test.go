@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"testing"
+)
+
+func TestSaveSlots(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	for range 60 {
+		n.RunFrame()
+	}
+
+	if err := n.SaveToSlot(3); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := n.SaveState()
+	if err := n.SaveToSlot(slotCount + 1); err == nil {
+		t.Error("saving to slot 11: want error, got none")
+	}
+
+	slots, err := n.SaveSlots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != slotCount {
+		t.Fatalf("slots: want %v, got %v", slotCount, len(slots))
+	}
+	for _, slot := range slots {
+		if slot.Used != (slot.Slot == 3) {
+			t.Errorf("slot %v used: want %v, got %v", slot.Slot, slot.Slot == 3, slot.Used)
+		}
+	}
+	if got := slots[2].PlayTime; got < 0.97 || got > 1.01 {
+		t.Errorf("play time: want about 1s, got %vs", got)
+	}
+	thumb, _ := base64.StdEncoding.DecodeString(slots[2].Thumbnail)
+	if img, err := png.Decode(bytes.NewReader(thumb)); err != nil {
+		t.Errorf("thumbnail: %v", err)
+	} else if size := img.Bounds().Size(); size.X != frameWidth/thumbScale || size.Y != frameHeight/thumbScale {
+		t.Errorf("thumbnail size: want %vx%v, got %v", frameWidth/thumbScale, frameHeight/thumbScale, size)
+	}
+
+	n.RunFrame()
+	if err := n.LoadFromSlot(3); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := n.SaveState(); !bytes.Equal(got, saved) {
+		t.Error("state loaded from slot differs from the state saved")
+	}
+
+	if err := n.DeleteSlot(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.LoadFromSlot(3); err == nil {
+		t.Error("loading deleted slot: want error, got none")
+	}
+	if slots, _ := n.SaveSlots(); slots[2].Used {
+		t.Error("deleted slot: want unused, got used")
+	}
+}
@@ -0,0 +1,415 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// stateVersion is the version of the save state format written by snapshot.  States of this or
+// any earlier version can be loaded.  Components only ever append fields to their chunks, and
+// read those added after version 1 only from states of the version which added them.
+//
+//	1: the initial format
+//	2: the jammed cpu and the opcode which jammed it
+const stateVersion = 2
+
+// Save state layout
+const (
+	stateHeaderLen      = 4 + 2 + sha1.Size // magic, version and rom hash
+	stateChunkHeaderLen = 4 + 4             // chunk id and length
+)
+
+// stateMagic is the magic number at the start of every save state.
+var stateMagic = []byte("GRST")
+
+// errSaveState is an error related to saving or loading a save state.
+type errSaveState string
+
+// Error implements error.
+func (err errSaveState) Error() string {
+	return fmt.Sprintf("save state: %v", string(err))
+}
+
+// stateful is implemented by components of the nes whose state is kept in save states.
+type stateful interface {
+	// saveState writes the state of the component to w.
+	saveState(w *stateWriter)
+	// loadState reads the state of the component from r, as written by saveState.
+	loadState(r *stateReader)
+}
+
+// stateChunk is a section of a save state holding the state of a single component.
+type stateChunk struct {
+	id        string // 4 character id of the chunk
+	component stateful
+}
+
+// stateChunks returns the chunks of a save state of n, in the order they are written.
+// n.mu must be held.
+func (n *nes) stateChunks() []stateChunk {
+	chunks := []stateChunk{
+		{"NES ", n},
+		{"CPU ", n.cpu},
+		{"RAM ", n.mem},
+		{"PPU ", n.ppu},
+		{"APU ", n.apu},
+	}
+	if m, ok := n.mapper.(stateful); ok {
+		chunks = append(chunks, stateChunk{"MAPR", m})
+	}
+	return chunks
+}
+
+// SaveState returns a save state of the entire nes, which can be restored by LoadState.
+func (n *nes) SaveState() ([]byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.snapshot()
+}
+
+// LoadState restores the nes to the save state state, returned by SaveState for the same rom.
+// If state is invalid, the nes is left as it was.  Otherwise, the nes can no longer be rewound
+// to before the state was loaded.
+func (n *nes) LoadState(state []byte) error {
+	n.mu.Lock()
+	err := n.restore(state)
+	if err == nil {
+		n.rewind.clear()
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	n.present()
+	return nil
+}
+
+// snapshot returns a save state of n: a header holding stateMagic, stateVersion and the SHA-1
+// of the rom, followed by a chunk for each of stateChunks, each preceded by its id and length.
+// n.mu must be held.
+func (n *nes) snapshot() ([]byte, error) {
+	if n.cart == nil {
+		return nil, errSaveState("no cartridge loaded")
+	}
+
+	w := &stateWriter{buf: make([]byte, 0, stateHeaderLen)}
+	w.buf = append(w.buf, stateMagic...)
+	w.u16(stateVersion)
+	w.buf = append(w.buf, n.cart.sha1[:]...)
+
+	for _, chunk := range n.stateChunks() {
+		w.buf = append(w.buf, chunk.id...)
+		start := len(w.buf)
+		w.u32(0)
+		chunk.component.saveState(w)
+		binary.LittleEndian.PutUint32(w.buf[start:], uint32(len(w.buf)-start-4))
+	}
+
+	return w.buf, nil
+}
+
+// restore restores n to the save state state.  Chunks with unknown ids are skipped, so that
+// states from later versions with extra components still load.  If state is invalid or from a
+// different rom, n is left as it was.  n.mu must be held.
+func (n *nes) restore(state []byte) error {
+	chunks, version, err := n.parseState(state)
+	if err != nil {
+		return err
+	}
+
+	// Components are restored in place, so keep the current state to fall back on
+	backup, err := n.snapshot()
+	if err != nil {
+		return err
+	}
+	if err := n.loadChunks(chunks, version); err != nil {
+		if rollback := n.restoreSnapshot(backup); rollback != nil {
+			return fmt.Errorf("%w, then rolling back failed: %w", err, rollback)
+		}
+		return err
+	}
+	return nil
+}
+
+// restoreSnapshot restores n to state, a snapshot taken by n itself since its cartridge was
+// inserted, such as for rewinding or running ahead.  Unlike restore, no backup is taken to fall
+// back on, so n may be left partially restored if state is nonetheless invalid.
+// n.mu must be held.
+func (n *nes) restoreSnapshot(state []byte) error {
+	chunks, version, err := n.parseState(state)
+	if err != nil {
+		return err
+	}
+	return n.loadChunks(chunks, version)
+}
+
+// parseState validates the header of the save state state, and returns its chunks by id along
+// with its version.  n.mu must be held.
+func (n *nes) parseState(state []byte) (chunks map[string][]byte, version int, err error) {
+	if n.cart == nil {
+		return nil, 0, errSaveState("no cartridge loaded")
+	}
+	if len(state) < stateHeaderLen || !bytes.Equal(state[:4], stateMagic) {
+		return nil, 0, errSaveState("not a save state")
+	}
+	version = int(binary.LittleEndian.Uint16(state[4:]))
+	if version == 0 || version > stateVersion {
+		return nil, 0, errSaveState(fmt.Sprintf("unsupported version %v", version))
+	}
+	if !bytes.Equal(state[6:stateHeaderLen], n.cart.sha1[:]) {
+		return nil, 0, errSaveState("saved from a different rom")
+	}
+
+	chunks = make(map[string][]byte)
+	for rest := state[stateHeaderLen:]; len(rest) > 0; {
+		if len(rest) < stateChunkHeaderLen {
+			return nil, 0, errSaveState("truncated chunk header")
+		}
+		id, length := string(rest[:4]), binary.LittleEndian.Uint32(rest[4:])
+		rest = rest[stateChunkHeaderLen:]
+		if uint64(length) > uint64(len(rest)) {
+			return nil, 0, errSaveState(fmt.Sprintf("truncated %q chunk", id))
+		}
+		chunks[id], rest = rest[:length], rest[length:]
+	}
+
+	for _, chunk := range n.stateChunks() {
+		if _, ok := chunks[chunk.id]; !ok {
+			return nil, 0, errSaveState(fmt.Sprintf("missing %q chunk", chunk.id))
+		}
+	}
+	return chunks, version, nil
+}
+
+// loadChunks restores each component of n from its chunk of a save state of version, as
+// returned by parseState, stopping at the first error.  n.mu must be held.
+func (n *nes) loadChunks(chunks map[string][]byte, version int) error {
+	for _, chunk := range n.stateChunks() {
+		r := &stateReader{data: chunks[chunk.id], version: version}
+		chunk.component.loadState(r)
+		if r.err != nil {
+			return fmt.Errorf("%w in %q chunk", r.err, chunk.id)
+		}
+	}
+
+	n.apu.samples = n.apu.samples[:0]
+	return nil
+}
+
+// saveState implements stateful.
+func (n *nes) saveState(w *stateWriter) {
+	w.int(n.dotClock)
+}
+
+// loadState implements stateful.
+func (n *nes) loadState(r *stateReader) {
+	n.dotClock = r.int()
+}
+
+// stateWriter appends the fields of components to a save state, little endian.
+type stateWriter struct {
+	buf []byte
+}
+
+// u8 writes a byte.
+func (w *stateWriter) u8(data byte) {
+	w.buf = append(w.buf, data)
+}
+
+// bool writes a bool as a byte.
+func (w *stateWriter) bool(data bool) {
+	w.u8(convert(data))
+}
+
+// u16 writes a 16 bit word.
+func (w *stateWriter) u16(data uint16) {
+	w.buf = binary.LittleEndian.AppendUint16(w.buf, data)
+}
+
+// u32 writes a 32 bit word.
+func (w *stateWriter) u32(data uint32) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, data)
+}
+
+// int writes an int as 64 bits.
+func (w *stateWriter) int(data int) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, uint64(data))
+}
+
+// f32 writes a float32.
+func (w *stateWriter) f32(data float32) {
+	w.u32(math.Float32bits(data))
+}
+
+// bytes writes memory, preceded by its length.
+func (w *stateWriter) bytes(data []byte) {
+	w.u32(uint32(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+// words writes memory of 16 bit words, preceded by its length.
+func (w *stateWriter) words(data []uint16) {
+	w.u32(uint32(len(data)))
+	start := len(w.buf)
+	w.buf = slices.Grow(w.buf, len(data)*2)[:start+len(data)*2]
+	for i, word := range data {
+		binary.LittleEndian.PutUint16(w.buf[start+i*2:], word)
+	}
+}
+
+// stateReader reads the fields of a component from a chunk of a save state, as written by
+// stateWriter.  Reads past the end of the chunk return zero values, so that fields appended to a
+// component after a state was saved are zeroed when it is loaded; components may check version
+// to do otherwise.  The first error encountered is kept in err.
+type stateReader struct {
+	data    []byte // rest of the chunk
+	version int    // version of the save state
+	err     error
+}
+
+// next returns the next n bytes of the chunk, or nil at the end of the chunk.  Fields read past
+// the end of the chunk therefore read as zero, though fields added in later versions should be
+// gated on r.version rather than rely on this.
+func (r *stateReader) next(n int) []byte {
+	if len(r.data) < n {
+		r.data = nil
+		return nil
+	}
+
+	data := r.data[:n]
+	r.data = r.data[n:]
+	return data
+}
+
+// u8 reads a byte.
+func (r *stateReader) u8() byte {
+	if data := r.next(1); data != nil {
+		return data[0]
+	}
+	return 0
+}
+
+// bool reads a bool written as a byte.
+func (r *stateReader) bool() bool {
+	return r.u8() != 0
+}
+
+// u16 reads a 16 bit word.
+func (r *stateReader) u16() uint16 {
+	if data := r.next(2); data != nil {
+		return binary.LittleEndian.Uint16(data)
+	}
+	return 0
+}
+
+// u32 reads a 32 bit word.
+func (r *stateReader) u32() uint32 {
+	if data := r.next(4); data != nil {
+		return binary.LittleEndian.Uint32(data)
+	}
+	return 0
+}
+
+// int reads an int written as 64 bits.
+func (r *stateReader) int() int {
+	if data := r.next(8); data != nil {
+		return int(binary.LittleEndian.Uint64(data))
+	}
+	return 0
+}
+
+// f32 reads a float32.
+func (r *stateReader) f32() float32 {
+	return math.Float32frombits(r.u32())
+}
+
+// count reads an int which must be within [0, limit], such as the length of a list.
+func (r *stateReader) count(limit int) int {
+	count := r.int()
+	if count < 0 || count > limit {
+		r.fail(fmt.Sprintf("count %v out of range [0, %v]", count, limit))
+		return 0
+	}
+	return count
+}
+
+// index reads an int which must be within [0, length), such as an index into memory of length
+// bytes.
+func (r *stateReader) index(length int) int {
+	index := r.int()
+	if index < 0 || index >= length {
+		r.fail(fmt.Sprintf("index %v out of range [0, %v)", index, length))
+		return 0
+	}
+	return index
+}
+
+// u8Index reads a byte which must be within [0, length), such as an index into a table.
+func (r *stateReader) u8Index(length int) byte {
+	index := r.u8()
+	if int(index) >= length {
+		r.fail(fmt.Sprintf("index %v out of range [0, %v)", index, length))
+		return 0
+	}
+	return index
+}
+
+// bytes reads memory into dst, which must be as long as the memory saved.
+func (r *stateReader) bytes(dst []byte) {
+	if len(r.data) == 0 {
+		clear(dst)
+		return
+	}
+
+	length := int(r.u32())
+	if length != len(dst) {
+		r.fail(fmt.Sprintf("%v bytes of memory, want %v", length, len(dst)))
+		return
+	}
+	if length == 0 {
+		return
+	}
+	data := r.next(length)
+	if data == nil {
+		r.fail("truncated memory")
+		return
+	}
+	copy(dst, data)
+}
+
+// words reads memory of 16 bit words into dst, which must be as long as the memory saved.
+func (r *stateReader) words(dst []uint16) {
+	if len(r.data) == 0 {
+		clear(dst)
+		return
+	}
+
+	length := int(r.u32())
+	if length != len(dst) {
+		r.fail(fmt.Sprintf("%v words of memory, want %v", length, len(dst)))
+		return
+	}
+	if length == 0 {
+		return
+	}
+	data := r.next(length * 2)
+	if data == nil {
+		r.fail("truncated memory")
+		return
+	}
+	for i := range dst {
+		dst[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+}
+
+// fail records err, unless an error has already been recorded.
+func (r *stateReader) fail(err string) {
+	if r.err == nil {
+		r.err = errSaveState(err)
+	}
+}
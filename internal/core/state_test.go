@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestSaveState(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		n.RunFrame()
+	}
+
+	state, err := n.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		n.RunFrame()
+	}
+	want, _ := n.SaveState()
+
+	// Running the same frames from the loaded state ends in the same state
+	if err := n.LoadState(state); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := n.SaveState(); !bytes.Equal(got, state) {
+		t.Error("state after loading differs from the state loaded")
+	}
+	for range 5 {
+		n.RunFrame()
+	}
+	if got, _ := n.SaveState(); !bytes.Equal(got, want) {
+		t.Error("state after running from the loaded state differs")
+	}
+}
+
+func TestLoadStateInvalid(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if _, err := n.SaveState(); err == nil {
+		t.Error("saving with no cartridge: want error, got none")
+	}
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	n.RunFrame()
+	state, _ := n.SaveState()
+
+	other := NewNes(nil, nil, nil)
+	program := append([]byte{}, schedulerProgram...)
+	program[10] = 0x78 // SEI
+	if err := other.UseCartridge(writeProgram(t, program, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	newer := bytes.Clone(state)
+	binary.LittleEndian.PutUint16(newer[4:], stateVersion+1)
+
+	for name, load := range map[string]func() error{
+		"different rom":   func() error { return other.LoadState(state) },
+		"newer version":   func() error { return n.LoadState(newer) },
+		"truncated":       func() error { return n.LoadState(state[:len(state)-100]) },
+		"not a save file": func() error { return n.LoadState([]byte("NES\x1A")) },
+	} {
+		var errState errSaveState
+		if err := load(); !errors.As(err, &errState) {
+			t.Errorf("%v: want errSaveState, got %v", name, err)
+		}
+	}
+
+	// A chunk holding the wrong amount of memory fails partway through loading
+	n.RunFrame()
+	before, _ := n.SaveState()
+	corrupt := bytes.Clone(state)
+	ram := bytes.Index(corrupt, []byte("RAM ")) + stateChunkHeaderLen
+	binary.LittleEndian.PutUint32(corrupt[ram:], 1)
+	if err := n.LoadState(corrupt); err == nil {
+		t.Error("corrupt RAM chunk: want error, got none")
+	}
+	if after, _ := n.SaveState(); !bytes.Equal(after, before) {
+		t.Error("state changed by failing to load a corrupt state")
+	}
+
+	// As does an index out of range, which would otherwise panic once run
+	scanline := n.ppu.scanline
+	n.ppu.scanline = 1000
+	corrupt, _ = n.SaveState()
+	n.ppu.scanline = scanline
+	if err := n.LoadState(corrupt); err == nil {
+		t.Error("scanline out of range: want error, got none")
+	}
+	n.RunFrame()
+}
+
+func TestStateReaderCompatibility(t *testing.T) {
+	w := &stateWriter{}
+	w.u16(0x1234)
+	w.bytes([]byte{1, 2, 3})
+
+	// Fields appended after the state was saved read as zero
+	r := &stateReader{data: w.buf, version: 1}
+	var mem [3]byte
+	if got := r.u16(); got != 0x1234 {
+		t.Errorf("u16: want $1234, got $%04X", got)
+	}
+	r.bytes(mem[:])
+	if mem != [3]byte{1, 2, 3} {
+		t.Errorf("bytes: want [1 2 3], got %v", mem)
+	}
+	if got := r.int(); got != 0 || r.err != nil {
+		t.Errorf("int past end of chunk: want 0 with no error, got %v and %v", got, r.err)
+	}
+}
+
+func TestLoadStateVersion1(t *testing.T) {
+	n := NewNes(nil, nil, nil)
+	if err := n.UseCartridge(writeProgram(t, schedulerProgram, 0xC00E, 0xC011)); err != nil {
+		t.Fatal(err)
+	}
+	n.RunFrame()
+	state, _ := n.SaveState()
+	chunks, _, err := n.parseState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 1 cpu chunks end before the jam state: a bool, the pc and the opcode
+	old := bytes.Clone(state[:stateHeaderLen])
+	binary.LittleEndian.PutUint16(old[4:], 1)
+	for _, chunk := range n.stateChunks() {
+		data := chunks[chunk.id]
+		if chunk.id == "CPU " {
+			data = data[:len(data)-4]
+		}
+		old = append(old, chunk.id...)
+		old = binary.LittleEndian.AppendUint32(old, uint32(len(data)))
+		old = append(old, data...)
+	}
+
+	n.cpu.fault = errCPUJammed{pc: 0xC000, opcode: 0x02}
+	if err := n.LoadState(old); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := n.SaveState(); !bytes.Equal(got, state) {
+		t.Error("state after loading the version 1 state differs from the state it was made from")
+	}
+	if err := n.Fault(); err != nil {
+		t.Errorf("fault: want none from a version 1 state, got %v", err)
+	}
+}
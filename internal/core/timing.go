@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// timing holds the clock rates and frame layout of the consoles of a region.
+// See https://wiki.nesdev.com/w/index.php/Cycle_reference_chart.
+type timing struct {
+	region       region
+	cpuClockRate int // cpu cycles per second
+
+	// The ppu runs dots ppu dots every cycles cpu cycles
+	dots   int
+	cycles int
+
+	scanlines    int  // scanlines per frame, including the pre-render line
+	vBlankLine   int  // scanline at which vblank starts
+	oddFrameSkip bool // whether or not odd frames skip a dot while rendering
+
+	frameCounterSteps [5]int     // cpu cycles at which the apu frame counter steps
+	noisePeriods      [16]uint16 // timer periods of the noise channel, in cpu cycles
+	dmcRates          [16]uint16 // timer periods of the dmc channel, in cpu cycles
+}
+
+// NTSC apu tables, shared by the Dendy whose apu runs on cpu cycles just as NTSC consoles do.
+var (
+	ntscFrameCounterSteps = [5]int{7457, 14913, 22371, 29829, 37281}
+	ntscNoisePeriods      = [16]uint16{4, 8, 16, 32, 64, 96, 128, 160, 202, 254, 380, 508, 762, 1016, 2034, 4068}
+	ntscDMCRates          = [16]uint16{428, 380, 340, 320, 286, 254, 226, 214, 190, 160, 142, 128, 106, 84, 72, 54}
+)
+
+// Region timings
+var (
+	ntscTiming = &timing{
+		region:            regionNTSC,
+		cpuClockRate:      1789773,
+		dots:              3,
+		cycles:            1,
+		scanlines:         262,
+		vBlankLine:        241,
+		oddFrameSkip:      true,
+		frameCounterSteps: ntscFrameCounterSteps,
+		noisePeriods:      ntscNoisePeriods,
+		dmcRates:          ntscDMCRates,
+	}
+	palTiming = &timing{
+		region:            regionPAL,
+		cpuClockRate:      1662607,
+		dots:              16,
+		cycles:            5,
+		scanlines:         312,
+		vBlankLine:        241,
+		frameCounterSteps: [5]int{8313, 16627, 24939, 33253, 41565},
+		noisePeriods:      [16]uint16{4, 8, 14, 30, 60, 88, 118, 148, 188, 236, 354, 472, 708, 944, 1890, 3778},
+		dmcRates:          [16]uint16{398, 354, 316, 298, 276, 236, 210, 198, 176, 148, 132, 118, 98, 78, 66, 50},
+	}
+	// The Dendy has as many scanlines as PAL consoles, but delays vblank until after 51
+	// post-render scanlines, so that games keep NTSC timing between vblank and rendering.
+	dendyTiming = &timing{
+		region:            regionDendy,
+		cpuClockRate:      1773448,
+		dots:              3,
+		cycles:            1,
+		scanlines:         312,
+		vBlankLine:        291,
+		frameCounterSteps: ntscFrameCounterSteps,
+		noisePeriods:      ntscNoisePeriods,
+		dmcRates:          ntscDMCRates,
+	}
+)
+
+// regionTiming returns the timing of consoles of region r.  Multi-region and unknown cartridges
+// are run as NTSC.
+func regionTiming(r region) *timing {
+	switch r {
+	case regionPAL:
+		return palTiming
+	case regionDendy:
+		return dendyTiming
+	default:
+		return ntscTiming
+	}
+}
+
+// parseRegion parses the name of a region, as given by region.String.  "auto" parses as
+// regionUnknown.
+func parseRegion(name string) (region, error) {
+	if strings.EqualFold(name, "auto") {
+		return regionUnknown, nil
+	}
+	for _, r := range []region{regionNTSC, regionPAL, regionDendy} {
+		if strings.EqualFold(name, r.String()) {
+			return r, nil
+		}
+	}
+	return regionUnknown, fmt.Errorf("unknown region %q", name)
+}
+
+// preRenderLine returns the last scanline of the frame, on which the ppu prepares to render.
+func (t *timing) preRenderLine() int {
+	return t.scanlines - 1
+}
+
+// frameRate returns the number of frames per second, averaging odd and even frames.
+func (t *timing) frameRate() float64 {
+	dots := float64(t.scanlines * dotsPerScanline)
+	if t.oddFrameSkip {
+		dots -= 0.5
+	}
+	return float64(t.cpuClockRate) * float64(t.dots) / float64(t.cycles) / dots
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRegionTiming(t *testing.T) {
+	tests := []struct {
+		region    region
+		frameRate float64
+		vBlank    int // scanlines from the end of rendering to the start of vblank
+	}{
+		{regionNTSC, 60.0988, 1},
+		{regionPAL, 50.0070, 1},
+		{regionDendy, 50.0070, 51},
+	}
+
+	for _, test := range tests {
+		n := NewNes(nil, nil, nil)
+		if err := n.SetRegion(test.region.String()); err != nil {
+			t.Fatal(err)
+		}
+		if got := n.FrameRate(); math.Abs(got-test.frameRate) > 0.0001 {
+			t.Errorf("%v frame rate: want %.4f, got %.4f", test.region, test.frameRate, got)
+		}
+		if got := n.timing.vBlankLine - postRenderLine; got != test.vBlank {
+			t.Errorf("%v post-render scanlines: want %v, got %v", test.region, test.vBlank, got)
+		}
+
+		// A frame takes the cpu cycles the clock ratio gives
+		n.clock(1000)
+		n.ppu.frames = 0
+		start := n.ppu.scanline*dotsPerScanline + n.ppu.dot
+		cycles := 0
+		for n.ppu.frames == 0 || n.ppu.scanline*dotsPerScanline+n.ppu.dot < start {
+			n.clock(1)
+			cycles++
+		}
+		want := n.timing.scanlines * dotsPerScanline * n.timing.cycles / n.timing.dots
+		if cycles < want-1 || cycles > want+1 {
+			t.Errorf("%v frame: want %v cpu cycles, got %v", test.region, want, cycles)
+		}
+	}
+
+	if err := (&nes{}).SetRegion("SECAM"); err == nil {
+		t.Errorf("want error selecting an unknown region")
+	}
+}
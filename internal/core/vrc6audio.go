@@ -0,0 +1,172 @@
+package core
+
+// vrc6Pulse is a pulse channel of the Konami VRC6.
+type vrc6Pulse struct {
+	mode    bool // whether or not the channel outputs its volume constantly, ignoring duty
+	duty    byte // 3 bit duty cycle
+	volume  byte
+	enabled bool
+	period  uint16 // 12 bit timer period
+	timer   uint16
+	step    byte // position within the 16 step duty cycle
+}
+
+// write writes to register 0-2 of the channel.
+func (p *vrc6Pulse) write(register uint16, data byte) {
+	switch register {
+	case 0:
+		p.mode = data&mask7 != 0
+		p.duty = data >> 4 & 0x07
+		p.volume = data & 0x0F
+	case 1:
+		p.period = p.period&0x0F00 | uint16(data)
+	case 2:
+		p.period = p.period&0x00FF | uint16(data&0x0F)<<8
+		p.enabled = data&mask7 != 0
+		if !p.enabled {
+			p.step = 0
+		}
+	}
+}
+
+// clock clocks the channel timer, whose period is divided by 1<<shift.
+func (p *vrc6Pulse) clock(shift byte) {
+	if !p.enabled {
+		return
+	}
+	if p.timer > 0 {
+		p.timer--
+		return
+	}
+	p.timer = p.period >> shift
+	p.step = (p.step + 1) % 16
+}
+
+// output returns the current 4 bit output of the channel.
+func (p *vrc6Pulse) output() byte {
+	if !p.enabled || !p.mode && p.step > p.duty {
+		return 0
+	}
+	return p.volume
+}
+
+// vrc6Saw is the sawtooth channel of the Konami VRC6.
+type vrc6Saw struct {
+	rate    byte // 6 bit accumulator rate
+	enabled bool
+	period  uint16 // 12 bit timer period
+	timer   uint16
+	step    byte // number of timer clocks since the accumulator was reset
+	accum   byte
+}
+
+// write writes to register 0-2 of the channel.
+func (s *vrc6Saw) write(register uint16, data byte) {
+	switch register {
+	case 0:
+		s.rate = data & 0x3F
+	case 1:
+		s.period = s.period&0x0F00 | uint16(data)
+	case 2:
+		s.period = s.period&0x00FF | uint16(data&0x0F)<<8
+		s.enabled = data&mask7 != 0
+		if !s.enabled {
+			s.step, s.accum = 0, 0
+		}
+	}
+}
+
+// clock clocks the channel timer, whose period is divided by 1<<shift.
+// The accumulator is added to on every other timer clock, and reset after the seventh addition.
+func (s *vrc6Saw) clock(shift byte) {
+	if !s.enabled {
+		return
+	}
+	if s.timer > 0 {
+		s.timer--
+		return
+	}
+	s.timer = s.period >> shift
+
+	s.step++
+	switch {
+	case s.step == 14:
+		s.step, s.accum = 0, 0
+	case s.step%2 == 0:
+		s.accum += s.rate
+	}
+}
+
+// output returns the current 5 bit output of the channel.
+func (s *vrc6Saw) output() byte {
+	return s.accum >> 3
+}
+
+// vrc6Audio is the sound hardware of the Konami VRC6: two pulse channels and a sawtooth
+// channel, mapped to $9000-$9003, $A000-$A002 and $B000-$B002.
+// See https://wiki.nesdev.com/w/index.php/VRC6_audio.
+type vrc6Audio struct {
+	pulse1 vrc6Pulse
+	pulse2 vrc6Pulse
+	saw    vrc6Saw
+	halt   bool // $9003 bit 0
+	shift  byte // frequency divider selected by $9003 bits 1-2
+}
+
+// writeRegister writes to the VRC6 audio register at address.  Addresses which are not
+// audio registers are ignored.
+func (v *vrc6Audio) writeRegister(address uint16, data byte) {
+	register := address & 0x0003
+	switch address & 0xF003 {
+	case 0x9000, 0x9001, 0x9002:
+		v.pulse1.write(register, data)
+	case 0x9003:
+		v.halt = data&mask0 != 0
+		switch {
+		case data&mask2 != 0:
+			v.shift = 8
+		case data&mask1 != 0:
+			v.shift = 4
+		default:
+			v.shift = 0
+		}
+	case 0xA000, 0xA001, 0xA002:
+		v.pulse2.write(register, data)
+	case 0xB000, 0xB001, 0xB002:
+		v.saw.write(register, data)
+	}
+}
+
+// isRegister returns whether or not address is a VRC6 audio register.
+func (v *vrc6Audio) isRegister(address uint16) bool {
+	switch address & 0xF003 {
+	case 0x9000, 0x9001, 0x9002, 0x9003, 0xA000, 0xA001, 0xA002, 0xB000, 0xB001, 0xB002:
+		return true
+	default:
+		return false
+	}
+}
+
+// clock clocks the channels once per cpu cycle.
+func (v *vrc6Audio) clock() {
+	if v.halt {
+		return
+	}
+	v.pulse1.clock(v.shift)
+	v.pulse2.clock(v.shift)
+	v.saw.clock(v.shift)
+}
+
+// audioOutput implements expansionAudio.
+// The VRC6 channels are mixed linearly, at roughly the level of the apu pulse channels.
+func (v *vrc6Audio) audioOutput() float32 {
+	out := int(v.pulse1.output()) + int(v.pulse2.output()) + int(v.saw.output())
+	return float32(out) / vrc6MaxOutput * vrc6MixLevel
+}
+
+// vrc6MaxOutput is the largest sum of the VRC6 channel outputs.
+const vrc6MaxOutput = 15 + 15 + 31
+
+// vrc6MixLevel is the output level of the VRC6 channels at full volume, relative to the
+// output level of the apu at full volume.
+const vrc6MixLevel = 0.6
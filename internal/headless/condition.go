@@ -0,0 +1,69 @@
+package headless
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errConditionInvalid is an error related to a run condition being invalid.
+type errConditionInvalid string
+
+// Error implements error.
+func (err errConditionInvalid) Error() string {
+	return fmt.Sprintf("condition invalid: %v", string(err))
+}
+
+// condition is a condition on a byte of cpu memory.
+type condition struct {
+	address uint16
+	value   byte
+	equal   bool // whether the condition is met when address holds value, or when it does not
+}
+
+// parseConditions parses comma separated conditions, each comparing an address with a value by
+// == or !=.  Numbers are decimal, or hexadecimal if prefixed by $ or 0x.  For example, the
+// results of blargg's test roms are ready once "$6001==$DE,$6002==$B0,$6000!=$80".
+func parseConditions(s string) (conditions []condition, err error) {
+	for _, term := range strings.Split(s, ",") {
+		op, equal := "==", true
+		if strings.Contains(term, "!=") {
+			op, equal = "!=", false
+		}
+		lhs, rhs, ok := strings.Cut(term, op)
+		if !ok {
+			return nil, errConditionInvalid(fmt.Sprintf("%q compares by neither == nor !=", term))
+		}
+
+		address, err := parseNumber(lhs, 16)
+		if err != nil {
+			return nil, errConditionInvalid(fmt.Sprintf("%q: invalid address: %v", term, err))
+		}
+		value, err := parseNumber(rhs, 8)
+		if err != nil {
+			return nil, errConditionInvalid(fmt.Sprintf("%q: invalid value: %v", term, err))
+		}
+		conditions = append(conditions, condition{uint16(address), byte(value), equal})
+	}
+	return conditions, nil
+}
+
+// parseNumber parses a number of at most bits bits, in decimal, or in hexadecimal if prefixed by
+// $ or 0x.
+func parseNumber(s string, bits int) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if hex, ok := strings.CutPrefix(s, "$"); ok {
+		return strconv.ParseUint(hex, 16, bits)
+	}
+	return strconv.ParseUint(s, 0, bits)
+}
+
+// met returns whether or not every condition is met, reading memory through peek.
+func met(conditions []condition, peek func(address uint16) byte) bool {
+	for _, c := range conditions {
+		if (peek(c.address) == c.value) != c.equal {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+// Package headless runs the nes without a webview, for regression testing roms in CI.
+package headless
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/justinawrey/goretro/internal/app"
+	"github.com/justinawrey/goretro/internal/core"
+)
+
+// Options configures a headless run.
+type Options struct {
+	ROM             string // path of the rom, or of an archive holding it
+	Frames          int    // frames to run, or the most to run if Until is set
+	Until           string // conditions ending the run once met after a frame; see parseConditions
+	Script          string // path of an input script, if any; see parseScript
+	Region          string // region to run as, or empty to follow the rom
+	Screenshot      string // path of a PNG of the last frame, if set
+	ScreenshotEvery int    // frames between extra screenshots, numbered by frame, or 0 for none
+	WAV             string // path of a WAV of the audio output, if set
+	Trace           string // path of a log of every cpu instruction, if set
+}
+
+// Result summarizes a headless run.
+type Result struct {
+	Frames int    // frames run
+	Hash   string // hex SHA-1 of the RGBA pixels of the last frame
+	Met    bool   // whether or not the conditions of Options.Until were met
+}
+
+// Run runs the rom of opts for opts.Frames frames, or until the conditions of opts.Until are
+// met, writing the outputs opts asks for.  Battery backed memory starts empty and is discarded,
+// so that runs are repeatable.  If the cpu jams, such as on an unsupported opcode, the run stops
+// at the end of that frame and the fault is returned, though the outputs are still written.
+func Run(opts Options) (result Result, err error) {
+	if opts.Frames <= 0 {
+		return result, fmt.Errorf("frames %v out of range: want at least 1", opts.Frames)
+	}
+
+	var conditions []condition
+	if opts.Until != "" {
+		if conditions, err = parseConditions(opts.Until); err != nil {
+			return result, err
+		}
+	}
+	var changes []inputChange
+	if opts.Script != "" {
+		if changes, err = readScript(opts.Script); err != nil {
+			return result, err
+		}
+	}
+
+	saves, err := os.MkdirTemp("", "goretro-saves-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(saves)
+
+	input := app.NewWebviewInputDriver()
+	n := core.NewNes(nil, input, nil)
+	n.SetSavesDir(saves)
+	if opts.Region != "" {
+		if err := n.SetRegion(opts.Region); err != nil {
+			return result, err
+		}
+	}
+	if err := n.UseCartridge(opts.ROM); err != nil {
+		return result, err
+	}
+	defer n.Shutdown()
+
+	if opts.Trace != "" {
+		trace, err := os.Create(opts.Trace)
+		if err != nil {
+			return result, err
+		}
+		defer trace.Close()
+
+		w := bufio.NewWriter(trace)
+		defer w.Flush()
+		n.OutputTo(w)
+	}
+
+	var samples []float32
+	var fault error
+	for result.Frames < opts.Frames && !result.Met && fault == nil {
+		for len(changes) > 0 && changes[0].frame <= result.Frames {
+			changes[0].apply(input)
+			changes = changes[1:]
+		}
+
+		n.RunFrame()
+		result.Frames++
+		if opts.WAV != "" {
+			samples = append(samples, n.DrainSamples()...)
+		} else {
+			n.DrainSamples()
+		}
+
+		if opts.Screenshot != "" && opts.ScreenshotEvery > 0 && result.Frames%opts.ScreenshotEvery == 0 {
+			if err := writePNG(numbered(opts.Screenshot, result.Frames), n.FrameRGBA()); err != nil {
+				return result, err
+			}
+		}
+		result.Met = len(conditions) > 0 && met(conditions, n.Peek)
+		fault = n.Fault()
+	}
+
+	rgba := n.FrameRGBA()
+	hash := sha1.Sum(rgba)
+	result.Hash = hex.EncodeToString(hash[:])
+
+	if opts.Screenshot != "" {
+		if err := writePNG(opts.Screenshot, rgba); err != nil {
+			return result, err
+		}
+	}
+	if opts.WAV != "" {
+		file, err := os.Create(opts.WAV)
+		if err != nil {
+			return result, err
+		}
+		defer file.Close()
+
+		w := bufio.NewWriter(file)
+		if err := writeWAV(w, samples, n.SampleRate()); err != nil {
+			return result, err
+		}
+		if err := w.Flush(); err != nil {
+			return result, err
+		}
+	}
+
+	if fault != nil {
+		return result, fmt.Errorf("frame %v: %w", result.Frames, fault)
+	}
+	return result, nil
+}
+
+// readScript parses the input script at path.
+func readScript(path string) ([]inputChange, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseScript(file)
+}
+
+// writePNG writes a frame of RGBA pixels to path as a PNG.
+func writePNG(path string, rgba []byte) error {
+	img := &image.RGBA{
+		Pix:    rgba,
+		Stride: app.FrameWidth * 4,
+		Rect:   image.Rect(0, 0, app.FrameWidth, app.FrameHeight),
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// numbered returns path with frame inserted before its extension, e.g. shot-000120.png.
+func numbered(path string, frame int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%v-%06d%v", strings.TrimSuffix(path, ext), frame, ext)
+}
@@ -0,0 +1,177 @@
+package headless
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/justinawrey/goretro/internal/app"
+)
+
+// joypadProgram stores the buttons held on the first joypad to $00 every frame, at the start of
+// vblank.  Its nmi handler is at $C008.
+var joypadProgram = []byte{
+	0xA9, 0x80, // LDA #$80
+	0x8D, 0x00, 0x20, // STA $2000
+	0x4C, 0x05, 0xC0, // JMP $C005
+	// nmi: read 8 buttons into $00, A first in bit 7
+	0xA9, 0x01, // LDA #$01
+	0x8D, 0x16, 0x40, // STA $4016
+	0xA9, 0x00, // LDA #$00
+	0x8D, 0x16, 0x40, // STA $4016
+	0xA2, 0x08, // LDX #$08
+	0xAD, 0x16, 0x40, // loop: LDA $4016
+	0x4A,       // LSR A
+	0x26, 0x00, // ROL $00
+	0xCA,       // DEX
+	0xD0, 0xF7, // BNE loop
+	0x40, // RTI
+}
+
+// writeROM writes an NROM rom which runs program from $C000, with its nmi handler at $C008.
+func writeROM(t *testing.T, program []byte) string {
+	t.Helper()
+
+	prg := make([]byte, 0x4000)
+	copy(prg, program)
+	binary.LittleEndian.PutUint16(prg[0x3FFA:], 0xC008) // nmi
+	binary.LittleEndian.PutUint16(prg[0x3FFC:], 0xC000) // reset
+
+	rom := append([]byte{'N', 'E', 'S', 0x1A, 1, 0}, make([]byte, 10)...)
+	path := filepath.Join(t.TempDir(), "test.nes")
+	if err := os.WriteFile(path, append(rom, prg...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseScript(t *testing.T) {
+	changes, err := parseScript(strings.NewReader("# start the game\n60 start\n\n61\n120 RIGHT A 2:left # jump\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("changes: want 3, got %v", len(changes))
+	}
+	if !changes[0].held[app.Primary][app.Start] || len(changes[1].held[app.Primary]) != 0 {
+		t.Error("START: want held on frame 60 and released on frame 61")
+	}
+	if held := changes[2].held; !held[app.Primary][app.A] || !held[app.Primary][app.Right] || !held[app.Secondary][app.Left] {
+		t.Errorf("frame 120: want RIGHT, A and 2:LEFT held, got %v", held)
+	}
+
+	for _, script := range []string{"10 A\n5 B\n", "x A\n", "10 TURBO\n"} {
+		if _, err := parseScript(strings.NewReader(script)); err == nil {
+			t.Errorf("script %q: want error, got none", script)
+		}
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	conditions, err := parseConditions("$6001==$DE, 0x6000 != 128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := map[uint16]byte{0x6000: 0x80, 0x6001: 0xDE}
+	peek := func(address uint16) byte { return memory[address] }
+	if met(conditions, peek) {
+		t.Error("$6000 is $80: want conditions unmet")
+	}
+	memory[0x6000] = 0x00
+	if !met(conditions, peek) {
+		t.Error("$6000 is $00: want conditions met")
+	}
+
+	for _, s := range []string{"$6000", "$10000==1", "$6000==256"} {
+		if _, err := parseConditions(s); err == nil {
+			t.Errorf("conditions %q: want error, got none", s)
+		}
+	}
+}
+
+func TestWriteWAV(t *testing.T) {
+	var wav bytes.Buffer
+	if err := writeWAV(&wav, []float32{0, 1, -1}, 44100); err != nil {
+		t.Fatal(err)
+	}
+
+	data := wav.Bytes()
+	if len(data) != wavHeaderLen+6 || string(data[:4]) != "RIFF" || string(data[36:40]) != "data" {
+		t.Fatalf("wav: malformed header % X", data[:min(len(data), wavHeaderLen)])
+	}
+	if got := binary.LittleEndian.Uint32(data[24:]); got != 44100 {
+		t.Errorf("sample rate: want 44100, got %v", got)
+	}
+	if got := int16(binary.LittleEndian.Uint16(data[wavHeaderLen+4:])); got != -32767 {
+		t.Errorf("sample -1: want -32767, got %v", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(script, []byte("3 A START\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{
+		ROM:             writeROM(t, joypadProgram),
+		Frames:          10,
+		Until:           "$0000==$90", // A and START held
+		Script:          script,
+		Screenshot:      filepath.Join(dir, "shot.png"),
+		ScreenshotEvery: 2,
+		WAV:             filepath.Join(dir, "audio.wav"),
+		Trace:           filepath.Join(dir, "trace.log"),
+	}
+	result, err := Run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The buttons held from frame 3, counting from 0, are read in its vblank before it completes
+	if !result.Met || result.Frames != 4 {
+		t.Errorf("result: want conditions met after 4 frames, got %+v", result)
+	}
+	if len(result.Hash) != 40 {
+		t.Errorf("hash: want 40 hex digits, got %q", result.Hash)
+	}
+	for _, name := range []string{"shot.png", "shot-000002.png", "shot-000004.png", "audio.wav"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("output %v: %v", name, err)
+		}
+	}
+	if trace, _ := os.ReadFile(opts.Trace); !bytes.HasPrefix(trace, []byte("C000  A9 80")) {
+		t.Errorf("trace: want to start at $C000, got %q", trace[:min(len(trace), 40)])
+	}
+
+	again, err := Run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Hash != result.Hash {
+		t.Error("hash: want the same for the same run, got otherwise")
+	}
+}
+
+func TestRunJammed(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{
+		ROM:        writeROM(t, []byte{0x02}), // an unsupported opcode
+		Frames:     10,
+		Screenshot: filepath.Join(dir, "shot.png"),
+	}
+	result, err := Run(opts)
+	if err == nil || !strings.Contains(err.Error(), "cpu jammed") {
+		t.Errorf("error: want the cpu jammed, got %v", err)
+	}
+	if result.Frames != 1 {
+		t.Errorf("frames: want to stop after 1, got %v", result.Frames)
+	}
+	if _, err := os.Stat(opts.Screenshot); err != nil {
+		t.Errorf("screenshot: %v", err)
+	}
+}
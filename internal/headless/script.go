@@ -0,0 +1,94 @@
+package headless
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/justinawrey/goretro/internal/app"
+)
+
+// errScriptInvalid is an error related to an input script being invalid.
+type errScriptInvalid string
+
+// Error implements error.
+func (err errScriptInvalid) Error() string {
+	return fmt.Sprintf("input script invalid: %v", string(err))
+}
+
+// secondJoypadPrefix prefixes the buttons of the second joypad in an input script.
+const secondJoypadPrefix = "2:"
+
+// inputChange is a line of an input script, giving the buttons held from frame onwards.
+type inputChange struct {
+	frame int                                // frame from which the buttons are held, counting from 0
+	held  map[app.Joypad]map[app.Button]bool // buttons held on each joypad; the rest are released
+}
+
+// parseScript parses an input script.  Each line holds a frame number followed by the buttons
+// held from that frame onwards, separated by spaces; buttons not given are released.  Buttons
+// are named as app.Buttons, and are on the first joypad unless prefixed by "2:".  Frames must
+// ascend.  Blank lines and text following a # are ignored.  For example:
+//
+//	60 START
+//	61
+//	120 RIGHT A 2:LEFT
+func parseScript(r io.Reader) (changes []inputChange, err error) {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, errScriptInvalid(fmt.Sprintf("line %v: invalid frame %q", line, fields[0]))
+		}
+		if len(changes) > 0 && frame <= changes[len(changes)-1].frame {
+			return nil, errScriptInvalid(fmt.Sprintf("line %v: frame %v does not follow the previous frame", line, frame))
+		}
+
+		change := inputChange{frame: frame, held: map[app.Joypad]map[app.Button]bool{
+			app.Primary:   {},
+			app.Secondary: {},
+		}}
+		for _, name := range fields[1:] {
+			joypad := app.Primary
+			if rest, ok := strings.CutPrefix(name, secondJoypadPrefix); ok {
+				joypad, name = app.Secondary, rest
+			}
+
+			button, ok := parseButton(name)
+			if !ok {
+				return nil, errScriptInvalid(fmt.Sprintf("line %v: unknown button %q", line, name))
+			}
+			change.held[joypad][button] = true
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, scanner.Err()
+}
+
+// parseButton returns the button called name, ignoring case.
+func parseButton(name string) (app.Button, bool) {
+	for _, button := range app.Buttons {
+		if strings.EqualFold(name, string(button.Value)) {
+			return button.Value, true
+		}
+	}
+	return "", false
+}
+
+// apply holds the buttons of c on input, releasing the rest.
+func (c inputChange) apply(input *app.WebviewInputDriver) {
+	for joypad, held := range c.held {
+		for _, button := range app.Buttons {
+			input.SetButton(joypad, button.Value, held[button.Value])
+		}
+	}
+}
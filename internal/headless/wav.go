@@ -0,0 +1,48 @@
+package headless
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// WAV format constants
+// See http://soundfile.sapp.org/doc/WaveFormat/.
+const (
+	wavHeaderLen     = 44
+	wavFormatPCM     = 1
+	wavBitsPerSample = 16
+)
+
+// writeWAV writes mono samples, in the range -1 to 1, to w as a 16 bit PCM WAV file of rate
+// samples per second.
+func writeWAV(w io.Writer, samples []float32, rate int) error {
+	const blockAlign = wavBitsPerSample / 8
+	dataLen := len(samples) * blockAlign
+
+	header := make([]byte, 0, wavHeaderLen)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(wavHeaderLen-8+dataLen))
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16) // fmt chunk length
+	header = binary.LittleEndian.AppendUint16(header, wavFormatPCM)
+	header = binary.LittleEndian.AppendUint16(header, 1) // channels
+	header = binary.LittleEndian.AppendUint32(header, uint32(rate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(rate*blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, blockAlign)
+	header = binary.LittleEndian.AppendUint16(header, wavBitsPerSample)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(dataLen))
+
+	pcm := make([]byte, dataLen)
+	for i, sample := range samples {
+		level := math.Round(float64(sample) * math.MaxInt16)
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(int16(max(min(level, math.MaxInt16), math.MinInt16))))
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(pcm)
+	return err
+}
@@ -1,20 +1,89 @@
 package nestest
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/justinawrey/goretro/internal/core"
 )
 
+// resetCycles is the number of cycles the cpu spends resetting before running the first
+// instruction of ideal.log, which goretro does not count.
+const resetCycles = 7
+
+// romPath returns the path of nestest.nes, which is not distributed with goretro.  It is looked
+// for in $NESTEST_ROM, or next to ideal.log.
+func romPath() string {
+	if path := os.Getenv("NESTEST_ROM"); path != "" {
+		return path
+	}
+	return "nestest.nes"
+}
+
+// TestNestest runs the official opcodes of nestest in its automated mode, from $C000, and compares
+// the trace of every instruction to ideal.log.
 func TestNestest(t *testing.T) {
-	// Set up logging
-	// nestest := nes.NewNes()
-	// nestest.Load("nestest.nes")
-	// fi, err := os.Create("output.log")
-	// if err != nil {
-	// 	t.Error("couldn't create output log file")
-	// 	return
-	// }
-	// nestest.OutputTo(fi)
-	//
-	// // Start stepping through
-	// nestest.Start()
+	rom, err := os.ReadFile(romPath())
+	if os.IsNotExist(err) {
+		t.Skip("nestest.nes not found; set NESTEST_ROM to its path")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	ideal, err := os.ReadFile("ideal.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the reset vector, at the end of its single 16kB prg ROM bank, at the automated mode
+	const iNesHeaderLen, prgROMBankLen = 16, 0x4000
+	if len(rom) < iNesHeaderLen+prgROMBankLen {
+		t.Fatalf("nestest.nes: want at least %v bytes, got %v", iNesHeaderLen+prgROMBankLen, len(rom))
+	}
+	binary.LittleEndian.PutUint16(rom[iNesHeaderLen+prgROMBankLen-4:], 0xC000)
+	path := filepath.Join(t.TempDir(), "nestest.nes")
+	if err := os.WriteFile(path, rom, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	n := core.NewNes(nil, nil, nil)
+	if err := n.UseCartridge(path); err != nil {
+		t.Fatal(err)
+	}
+	var trace bytes.Buffer
+	n.OutputTo(&trace)
+
+	want := strings.Split(strings.TrimSpace(strings.ReplaceAll(string(ideal), "\r\n", "\n")), "\n")
+	for range want {
+		n.StepInstruction()
+	}
+
+	scanner := bufio.NewScanner(&trace)
+	for i, line := range want {
+		if !scanner.Scan() {
+			t.Fatalf("line %v: want %q, got the end of the trace", i+1, line)
+		}
+		if got := withResetCycles(scanner.Text()); got != line {
+			t.Fatalf("line %v:\nwant %q\n got %q", i+1, line, got)
+		}
+	}
+}
+
+// withResetCycles adds resetCycles to the cycle count at the end of a line of the trace.
+func withResetCycles(line string) string {
+	i := strings.LastIndex(line, "CYC:")
+	if i < 0 {
+		return line
+	}
+	var cycles int
+	if _, err := fmt.Sscan(line[i+len("CYC:"):], &cycles); err != nil {
+		return line
+	}
+	return fmt.Sprintf("%vCYC:%v", line[:i], cycles+resetCycles)
 }
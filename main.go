@@ -13,6 +13,7 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/options/windows"
 
 	"github.com/justinawrey/goretro/internal/app"
+	"github.com/justinawrey/goretro/internal/core"
 )
 
 //go:embed frontend/dist
@@ -24,6 +25,8 @@ var icon []byte
 func main() {
 	inputDriver := app.NewWebviewInputDriver()
 	displayDriver := app.NewWebviewDisplayDriver()
+	audioDriver := app.NewWebviewAudioDriver()
+	nes := core.NewNes(displayDriver, inputDriver, audioDriver)
 
 	_, isDev := os.LookupEnv("WAILS_DEV")
 
@@ -48,19 +51,28 @@ func main() {
 		LogLevel:          logger.DEBUG,
 		OnStartup: func(ctx context.Context) {
 			displayDriver.Ctx = ctx
+			audioDriver.Ctx = ctx
+			nes.Start()
+		},
+		OnShutdown: func(ctx context.Context) {
+			if err := nes.Shutdown(); err != nil {
+				log.Println(err)
+			}
 		},
 		// OnDomReady:       appInstance.domReady,
 		// OnBeforeClose:    appInstance.beforeClose,
-		// OnShutdown:       appInstance.shutdown,
 		WindowStartState: options.Normal,
 		Bind: []any{
 			inputDriver,
 			displayDriver,
+			audioDriver,
+			nes,
 		},
 		EnumBind: []interface{}{
 			app.Joypads,
 			app.Buttons,
 			app.DisplayEvents,
+			app.AudioEvents,
 		},
 		// Windows platform specific options
 		Windows: &windows.Options{